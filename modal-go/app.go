@@ -8,15 +8,16 @@ import (
 	"time"
 
 	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 // App references a deployed Modal App.
 type App struct {
-	AppId string
-	Name  string
-	ctx   context.Context
+	AppId  string
+	Name   string
+	client *Client
 }
 
 // LookupOptions are options for finding deployed Modal objects.
@@ -55,6 +56,7 @@ type SandboxOptions struct {
 	Regions           []string                     // Region(s) to run the sandbox on.
 	Verbose           bool                         // Enable verbose logging.
 	Proxy             *Proxy                       // Reference to a Modal Proxy to use in front of this Sandbox.
+	RetryPolicy       *RetryPolicy                 // Overrides the client's retry policy when creating the Sandbox.
 }
 
 // ImageFromRegistryOptions are options for creating an Image from a registry.
@@ -90,13 +92,22 @@ func parseGPUConfig(gpu string) (*pb.GPUConfig, error) {
 	}.Build(), nil
 }
 
-// AppLookup looks up an existing App, or creates an empty one.
+// AppLookup looks up an existing App, or creates an empty one, using the default client.
 func AppLookup(ctx context.Context, name string, options *LookupOptions) (*App, error) {
+	c, err := getDefaultClient()
+	if err != nil {
+		return nil, err
+	}
+	return c.AppLookup(ctx, name, options)
+}
+
+// AppLookup looks up an existing App, or creates an empty one.
+func (c *Client) AppLookup(ctx context.Context, name string, options *LookupOptions) (*App, error) {
 	if options == nil {
 		options = &LookupOptions{}
 	}
 	var err error
-	ctx, err = clientContext(ctx)
+	ctx, err = c.clientContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -106,29 +117,51 @@ func AppLookup(ctx context.Context, name string, options *LookupOptions) (*App,
 		creationType = pb.ObjectCreationType_OBJECT_CREATION_TYPE_CREATE_IF_MISSING
 	}
 
-	resp, err := client.AppGetOrCreate(ctx, pb.AppGetOrCreateRequest_builder{
+	resp, err := c.cpClient.AppGetOrCreate(ctx, pb.AppGetOrCreateRequest_builder{
 		AppName:            name,
-		EnvironmentName:    environmentName(options.Environment),
+		EnvironmentName:    c.environmentName(options.Environment),
 		ObjectCreationType: creationType,
 	}.Build())
 
-	if status, ok := status.FromError(err); ok && status.Code() == codes.NotFound {
-		return nil, NotFoundError{fmt.Sprintf("app '%s' not found", name)}
+	if status.Code(err) == codes.NotFound {
+		return nil, withNotFoundMessage(err, fmt.Sprintf("app '%s' not found", name))
 	}
 	if err != nil {
 		return nil, err
 	}
 
-	return &App{AppId: resp.GetAppId(), Name: name, ctx: ctx}, nil
+	return &App{AppId: resp.GetAppId(), Name: name, client: c}, nil
 }
 
 // CreateSandbox creates a new Sandbox in the App with the specified image and options.
+// It is CreateSandboxContext with context.Background().
 func (app *App) CreateSandbox(image *Image, options *SandboxOptions) (*Sandbox, error) {
+	return app.CreateSandboxContext(context.Background(), image, options)
+}
+
+// CreateSandboxContext creates a new Sandbox in the App with the specified image
+// and options. Only building the image and creating the Sandbox are bound to ctx,
+// and the Sandbox can outlive it.
+func (app *App) CreateSandboxContext(ctx context.Context, image *Image, options *SandboxOptions) (*Sandbox, error) {
 	if options == nil {
 		options = &SandboxOptions{}
 	}
 
-	image, err := image.build(app)
+	ctx, span := app.client.startSpan(ctx, "App.CreateSandbox", attribute.String("modal.app_id", app.AppId))
+	sb, err := app.createSandbox(ctx, image, options)
+	if sb != nil {
+		span.SetAttributes(attribute.String("modal.sandbox_id", sb.SandboxId))
+	}
+	endSpan(span, err)
+	return sb, err
+}
+
+func (app *App) createSandbox(ctx context.Context, image *Image, options *SandboxOptions) (*Sandbox, error) {
+	ctx, err := app.client.clientContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	image, err = image.build(ctx, app, retryOptions(options.RetryPolicy)...)
 	if err != nil {
 		return nil, err
 	}
@@ -235,7 +268,7 @@ func (app *App) CreateSandbox(image *Image, options *SandboxOptions) (*Sandbox,
 		workdir = &options.Workdir
 	}
 
-	createResp, err := client.SandboxCreate(app.ctx, pb.SandboxCreateRequest_builder{
+	createResp, err := app.client.cpClient.SandboxCreate(ctx, pb.SandboxCreateRequest_builder{
 		AppId: app.AppId,
 		Definition: pb.Sandbox_builder{
 			EntrypointArgs: options.Command,
@@ -257,32 +290,34 @@ func (app *App) CreateSandbox(image *Image, options *SandboxOptions) (*Sandbox,
 			Verbose:            options.Verbose,
 			ProxyId:            proxyId,
 		}.Build(),
-	}.Build())
+	}.Build(), retryOptions(options.RetryPolicy)...)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return newSandbox(app.ctx, createResp.GetSandboxId()), nil
+	sb := newSandbox(ctx, app.client, createResp.GetSandboxId())
+	app.client.trackSandbox(sb)
+	return sb, nil
 }
 
 // ImageFromRegistry creates an Image from a registry tag.
 //
 // Deprecated: ImageFromRegistry is deprecated, use modal.NewImageFromRegistry instead
 func (app *App) ImageFromRegistry(tag string, options *ImageFromRegistryOptions) (*Image, error) {
-	return NewImageFromRegistry(tag, options).build(app)
+	return NewImageFromRegistry(tag, options).build(context.Background(), app)
 }
 
 // ImageFromAwsEcr creates an Image from an AWS ECR tag.
 //
 // Deprecated: ImageFromAwsEcr is deprecated, use modal.NewImageFromAwsEcr instead
 func (app *App) ImageFromAwsEcr(tag string, secret *Secret) (*Image, error) {
-	return NewImageFromAwsEcr(tag, secret).build(app)
+	return NewImageFromAwsEcr(tag, secret).build(context.Background(), app)
 }
 
 // ImageFromGcpArtifactRegistry creates an Image from a GCP Artifact Registry tag.
 //
 // Deprecated: ImageFromGcpArtifactRegistry is deprecated, use modal.NewImageFromGcpArtifactRegistry instead
 func (app *App) ImageFromGcpArtifactRegistry(tag string, secret *Secret) (*Image, error) {
-	return NewImageFromGcpArtifactRegistry(tag, secret).build(app)
+	return NewImageFromGcpArtifactRegistry(tag, secret).build(context.Background(), app)
 }
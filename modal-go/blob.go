@@ -0,0 +1,425 @@
+package modal
+
+// Uploads of large Function inputs to blob storage.
+//
+// Inputs larger than maxObjectSizeBytes are uploaded as blobs, and passed by
+// the ID of the blob. BlobCreate returns either a URL to upload the blob with a
+// single PUT, or the URLs of its parts and a completion URL for a multipart
+// upload: the parts are PUT in parallel, then assembled with a POST of their
+// ETags to the completion URL. The ETag of each PUT must be the MD5 of its data,
+// and the response of the completion must contain the ETag of the assembled
+// blob, which is the MD5 of the MD5s of its parts and the number of parts.
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	pickle "github.com/kisielk/og-rek"
+	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+)
+
+// blobUploadConcurrency is the number of parts of a multipart upload that are
+// uploaded in parallel.
+const blobUploadConcurrency = 8
+
+// ReaderArg is a Function argument that is passed to Python as bytes, whose
+// content is read from R during the call instead of being held in memory.
+// Large inputs are uploaded from R to blob storage, in parts that are uploaded
+// in parallel.
+//
+// If R is an *os.File, or an io.ReaderAt with a Size method such as
+// *bytes.Reader or *io.SectionReader, its content is read in place, and the
+// ReaderArg can be used for several calls. Otherwise R is copied to a temporary
+// file first, and can only be read once.
+//
+// ReaderArgs can be passed as arguments and keyword arguments of Function calls
+// that use a pickle codec, such as PickleCodec, but not inside other values.
+type ReaderArg struct {
+	R io.Reader
+}
+
+// readerArgMarkerSize is the size of the bytes that stand in for a ReaderArg
+// when the input is pickled, and are then replaced by its content.
+const readerArgMarkerSize = 16
+
+// encodeInput encodes the args and kwargs of a Function call, and returns a
+// reader of the payload. cleanup removes the temporary files of ReaderArgs.
+func encodeInput(codec Codec, args []any, kwargs map[string]any) (payload *io.SectionReader, cleanup func(), err error) {
+	// Inputs have no data format for other codecs yet, and would be unpickled.
+	if codec.DataFormat() != DataFormatPickle {
+		return nil, nil, InvalidError{Exception: fmt.Sprintf("%T can't encode Function inputs, which Modal only supports as pickles", codec)}
+	}
+	var readers []ReaderArg
+	var markers [][]byte
+	replace := func(v any) (any, error) {
+		r, ok := v.(ReaderArg)
+		if !ok {
+			if p, isPtr := v.(*ReaderArg); isPtr && p != nil {
+				r, ok = *p, true
+			}
+		}
+		if !ok {
+			return v, nil
+		}
+		marker := make([]byte, readerArgMarkerSize)
+		if _, err := rand.Read(marker); err != nil {
+			return nil, err
+		}
+		readers = append(readers, r)
+		markers = append(markers, marker)
+		return pickle.Bytes(marker), nil
+	}
+	args = slices.Clone(args)
+	for i, v := range args {
+		if args[i], err = replace(v); err != nil {
+			return nil, nil, err
+		}
+	}
+	kwargsCopy := make(map[string]any, len(kwargs))
+	for k, v := range kwargs {
+		if kwargsCopy[k], err = replace(v); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	data, err := codec.Encode(pickle.Tuple{args, kwargsCopy})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(readers) == 0 {
+		return io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))), func() {}, nil
+	}
+
+	// Each marker is pickled as SHORT_BINBYTES, which is replaced by BINBYTES,
+	// or BINBYTES8 over 4 GiB, with the content of the reader.
+	type splice struct {
+		pos    int
+		reader ReaderArg
+	}
+	var splices []splice
+	for i, marker := range markers {
+		op := append([]byte{'C', readerArgMarkerSize}, marker...)
+		pos := bytes.Index(data, op)
+		if pos < 0 {
+			return nil, nil, fmt.Errorf("error pickling data: ReaderArg %d not found", i)
+		}
+		splices = append(splices, splice{pos, readers[i]})
+	}
+	slices.SortFunc(splices, func(a, b splice) int { return a.pos - b.pos })
+
+	var sections []*io.SectionReader
+	var closers []func()
+	cleanup = func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+	start := 0
+	for _, s := range splices {
+		content, closeContent, err := readerArgContent(s.reader)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		closers = append(closers, closeContent)
+		var header []byte
+		if content.Size() <= math.MaxUint32 {
+			header = binary.LittleEndian.AppendUint32([]byte{'B'}, uint32(content.Size())) // BINBYTES
+		} else {
+			header = binary.LittleEndian.AppendUint64([]byte{0x8e}, uint64(content.Size())) // BINBYTES8
+		}
+		sections = append(sections,
+			io.NewSectionReader(bytes.NewReader(data[start:s.pos]), 0, int64(s.pos-start)),
+			io.NewSectionReader(bytes.NewReader(header), 0, int64(len(header))),
+			content,
+		)
+		start = s.pos + 2 + readerArgMarkerSize
+	}
+	sections = append(sections, io.NewSectionReader(bytes.NewReader(data[start:]), 0, int64(len(data)-start)))
+	r := sectionsReader(sections)
+	return io.NewSectionReader(r, 0, r.size()), cleanup, nil
+}
+
+// readerArgContent returns a reader of the content of a ReaderArg, which is
+// copied to a temporary file if it can't be read in place. cleanup removes the
+// file.
+func readerArgContent(arg ReaderArg) (content *io.SectionReader, cleanup func(), err error) {
+	switch r := arg.R.(type) {
+	case nil:
+		return nil, nil, InvalidError{Exception: "ReaderArg has no reader"}
+	case *os.File:
+		pos, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, nil, err
+		}
+		info, err := r.Stat()
+		if err != nil {
+			return nil, nil, err
+		}
+		return io.NewSectionReader(r, pos, max(info.Size()-pos, 0)), func() {}, nil
+	case interface {
+		io.ReaderAt
+		Size() int64
+	}:
+		return io.NewSectionReader(r, 0, r.Size()), func() {}, nil
+	}
+
+	f, err := os.CreateTemp("", "modal-input-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create temporary file for ReaderArg: %w", err)
+	}
+	cleanup = func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	n, err := io.Copy(f, arg.R)
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to read ReaderArg: %w", err)
+	}
+	return io.NewSectionReader(f, 0, n), cleanup, nil
+}
+
+// sectionsReader is an io.ReaderAt of sections that follow each other.
+type sectionsReader []*io.SectionReader
+
+func (s sectionsReader) size() int64 {
+	var n int64
+	for _, section := range s {
+		n += section.Size()
+	}
+	return n
+}
+
+func (s sectionsReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for _, section := range s {
+		if len(p) == 0 {
+			break
+		}
+		if off >= section.Size() {
+			off -= section.Size()
+			continue
+		}
+		m, err := section.ReadAt(p[:min(int64(len(p)), section.Size()-off)], off)
+		n += m
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+		p = p[m:]
+		off = 0
+	}
+	if len(p) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// blobUpload uploads the content of r to blob storage and returns the ID of
+// the blob.
+func blobUpload(ctx context.Context, client *Client, r *io.SectionReader) (string, error) {
+	md5Hash, sha256Hash := md5.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(md5Hash, sha256Hash), io.NewSectionReader(r, 0, r.Size())); err != nil {
+		return "", fmt.Errorf("failed to read blob data: %w", err)
+	}
+	md5sum := md5Hash.Sum(nil)
+
+	resp, err := client.cpClient.BlobCreate(ctx, pb.BlobCreateRequest_builder{
+		ContentMd5:          base64.StdEncoding.EncodeToString(md5sum),
+		ContentSha256Base64: base64.StdEncoding.EncodeToString(sha256Hash.Sum(nil)),
+		ContentLength:       r.Size(),
+	}.Build())
+	if err != nil {
+		return "", fmt.Errorf("failed to create blob: %w", err)
+	}
+
+	switch resp.WhichUploadTypeOneof() {
+	case pb.BlobCreateResponse_Multipart_case:
+		err = blobUploadMultipart(ctx, client, r, resp.GetMultipart())
+	case pb.BlobCreateResponse_UploadUrl_case:
+		_, err = blobUploadPart(ctx, client, resp.GetUploadUrl(), r, md5sum)
+	default:
+		return "", fmt.Errorf("missing upload URL in BlobCreate response")
+	}
+	if err != nil {
+		return "", err
+	}
+	client.metrics.BlobTransferred(BlobDirectionUpload, r.Size())
+	return resp.GetBlobId(), nil
+}
+
+// blobUploadMultipart uploads the parts of a blob in parallel, and then
+// assembles them.
+func blobUploadMultipart(ctx context.Context, client *Client, r *io.SectionReader, upload *pb.MultiPartUpload) error {
+	partLength, urls := upload.GetPartLength(), upload.GetUploadUrls()
+	if partLength <= 0 || int64(len(urls))*partLength < r.Size() {
+		return fmt.Errorf("invalid multipart upload of %d bytes in %d parts of %d bytes", r.Size(), len(urls), partLength)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+	sem := make(chan struct{}, blobUploadConcurrency)
+	sums := make([][]byte, len(urls))
+	for i, url := range urls {
+		offset := min(int64(i)*partLength, r.Size())
+		part := io.NewSectionReader(r, offset, min(partLength, r.Size()-offset))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+			sum, err := blobUploadPart(ctx, client, url, part, nil)
+			if err != nil {
+				fail(fmt.Errorf("part %d: %w", i+1, err))
+				return
+			}
+			sums[i] = sum
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// The completion lists the ETags of the parts, in S3's XML format.
+	var body strings.Builder
+	body.WriteString("<CompleteMultipartUpload>\n")
+	etagsHash := md5.New()
+	for i, sum := range sums {
+		fmt.Fprintf(&body, "<Part>\n<PartNumber>%d</PartNumber>\n<ETag>\"%x\"</ETag>\n</Part>\n", i+1, sum)
+		etagsHash.Write(sum)
+	}
+	body.WriteString("</CompleteMultipartUpload>")
+	expectedETag := fmt.Sprintf("%x-%d", etagsHash.Sum(nil), len(sums))
+
+	return retryBlobRequest(ctx, client, func() error {
+		req, err := http.NewRequestWithContext(ctx, "POST", upload.GetCompletionUrl(), strings.NewReader(body.String()))
+		if err != nil {
+			return fmt.Errorf("failed to create completion request: %w", err)
+		}
+		resp, err := client.httpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to complete multipart upload: %w", err)
+		}
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to complete multipart upload: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return blobStatusError{resp.StatusCode, resp.Status}
+		}
+		if !bytes.Contains(respBody, []byte(expectedETag)) {
+			return ExecutionError{Exception: fmt.Sprintf("hash mismatch on multipart upload assembly: %s not in %q", expectedETag, respBody)}
+		}
+		return nil
+	})
+}
+
+// blobUploadPart uploads data with a single PUT, and checks that the ETag of the
+// response is its MD5 sum, which it returns. The sum is sent as Content-MD5 when
+// md5sum is known up front.
+func blobUploadPart(ctx context.Context, client *Client, url string, data *io.SectionReader, md5sum []byte) (sent []byte, err error) {
+	err = retryBlobRequest(ctx, client, func() error {
+		// The MD5 is computed as the data is sent, so parts are read only once.
+		h := md5.New()
+		var body io.Reader = http.NoBody
+		if data.Size() > 0 {
+			body = io.TeeReader(io.NewSectionReader(data, 0, data.Size()), h)
+		}
+		req, err := http.NewRequestWithContext(ctx, "PUT", url, body)
+		if err != nil {
+			return fmt.Errorf("failed to create upload request: %w", err)
+		}
+		req.ContentLength = data.Size()
+		req.Header.Set("Content-Type", "application/octet-stream")
+		if md5sum != nil {
+			req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5sum))
+		}
+		resp, err := client.httpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to upload blob: %w", err)
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return blobStatusError{resp.StatusCode, resp.Status}
+		}
+		sent = h.Sum(nil)
+		if etag := strings.Trim(resp.Header.Get("ETag"), `"`); etag != hex.EncodeToString(sent) {
+			return ExecutionError{Exception: fmt.Sprintf("checksum mismatch on blob upload: ETag %q is not the MD5 %x of the data", etag, sent)}
+		}
+		return nil
+	})
+	return sent, err
+}
+
+// blobStatusError is an unsuccessful HTTP response from blob storage.
+type blobStatusError struct {
+	code   int
+	status string
+}
+
+func (e blobStatusError) Error() string {
+	return "failed blob upload: " + e.status
+}
+
+// retryBlobRequest runs a request to blob storage, and retries it after server
+// errors and failed connections, with the back-off of the client's RetryPolicy.
+func retryBlobRequest(ctx context.Context, client *Client, request func() error) error {
+	policy := client.retryPolicy
+	delay := policy.BaseDelay
+	for attempt := 1; ; attempt++ {
+		err := request()
+		if err == nil || attempt >= policy.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+		var statusErr blobStatusError
+		if errors.As(err, &statusErr) {
+			if statusErr.code < 500 && statusErr.code != http.StatusTooManyRequests {
+				return err
+			}
+		} else if errors.Is(err, ErrExecution) {
+			return err
+		}
+		d := policy.jittered(delay)
+		client.logger.DebugContext(ctx, "retrying blob upload", "attempt", attempt, "delay", d, "error", err)
+		if sleepCtx(ctx, d) != nil {
+			return err
+		}
+		delay = policy.nextDelay(delay)
+	}
+}
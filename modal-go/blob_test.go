@@ -0,0 +1,217 @@
+package modal
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	pickle "github.com/kisielk/og-rek"
+	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// blobTestModalClient answers BlobCreate with the upload URLs of a
+// blobTestServer.
+type blobTestModalClient struct {
+	pb.ModalClientClient
+	server     *blobTestServer
+	partLength int64 // 0 for uploads with a single PUT
+}
+
+func (f *blobTestModalClient) BlobCreate(ctx context.Context, in *pb.BlobCreateRequest, opts ...grpc.CallOption) (*pb.BlobCreateResponse, error) {
+	f.server.mu.Lock()
+	f.server.contentLength = in.GetContentLength()
+	f.server.mu.Unlock()
+	url := f.server.URL
+	if f.partLength == 0 {
+		return pb.BlobCreateResponse_builder{BlobId: "bl-single", UploadUrl: proto.String(url + "/part/1")}.Build(), nil
+	}
+	var urls []string
+	for i := int64(0); i*f.partLength < in.GetContentLength(); i++ {
+		urls = append(urls, fmt.Sprintf("%s/part/%d", url, i+1))
+	}
+	return pb.BlobCreateResponse_builder{
+		BlobId: "bl-multi",
+		Multipart: pb.MultiPartUpload_builder{
+			PartLength:    f.partLength,
+			UploadUrls:    urls,
+			CompletionUrl: url + "/complete",
+		}.Build(),
+	}.Build(), nil
+}
+
+// blobTestServer stands in for blob storage, like S3: the ETag of a part is its
+// MD5, and the completion of a multipart upload assembles the parts.
+type blobTestServer struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	contentLength int64
+	parts         map[string][]byte
+	failures      map[string]int // number of 500s to answer before accepting a part
+	badETag       bool
+	assembled     []byte
+}
+
+func newBlobTestServer(t *testing.T) *blobTestServer {
+	s := &blobTestServer{parts: map[string][]byte{}, failures: map[string]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *blobTestServer) handle(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/part/"):
+		if s.failures[r.URL.Path] > 0 {
+			s.failures[r.URL.Path]--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		sum := md5.Sum(body)
+		if s.badETag {
+			sum[0]++
+		}
+		s.parts[r.URL.Path] = body
+		w.Header().Set("ETag", fmt.Sprintf("%q", hex.EncodeToString(sum[:])))
+	case r.Method == "POST" && r.URL.Path == "/complete":
+		etags := md5.New()
+		s.assembled = nil
+		for i := 1; ; i++ {
+			part, ok := s.parts[fmt.Sprintf("/part/%d", i)]
+			if !ok {
+				fmt.Fprintf(w, "<CompleteMultipartUploadResult><ETag>\"%x-%d\"</ETag></CompleteMultipartUploadResult>", etags.Sum(nil), i-1)
+				return
+			}
+			if !bytes.Contains(body, fmt.Appendf(nil, "<PartNumber>%d</PartNumber>\n<ETag>\"%x\"</ETag>", i, md5.Sum(part))) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			sum := md5.Sum(part)
+			etags.Write(sum[:])
+			s.assembled = append(s.assembled, part...)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestBlobUploadMultipart(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	server := newBlobTestServer(t)
+	c := newTestClient(g, &blobTestModalClient{server: server, partLength: 1000}, ClientOptions{
+		RetryPolicy: &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+	})
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+
+	// A part that fails is retried.
+	server.failures["/part/3"] = 1
+	blobId, err := blobUpload(context.Background(), c, io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))))
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(blobId).To(gomega.Equal("bl-multi"))
+	g.Expect(server.contentLength).To(gomega.Equal(int64(len(data))))
+	g.Expect(server.parts).To(gomega.HaveLen(16))
+	g.Expect(server.failures["/part/3"]).To(gomega.Equal(0))
+	g.Expect(server.assembled).To(gomega.Equal(data))
+
+	// A part that keeps failing fails the upload.
+	server.failures["/part/2"] = 3
+	_, err = blobUpload(context.Background(), c, io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))))
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("part 2: failed blob upload: 500")))
+
+	// Parts whose ETag isn't their MD5 fail without retries.
+	server.badETag = true
+	_, err = blobUpload(context.Background(), c, io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))))
+	g.Expect(err).To(gomega.MatchError(ErrExecution))
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("checksum mismatch")))
+}
+
+func TestBlobUploadSingle(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	server := newBlobTestServer(t)
+	c := newTestClient(g, &blobTestModalClient{server: server}, ClientOptions{})
+	data := []byte("single part")
+
+	blobId, err := blobUpload(context.Background(), c, io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))))
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(blobId).To(gomega.Equal("bl-single"))
+	g.Expect(server.parts["/part/1"]).To(gomega.Equal(data))
+
+	server.badETag = true
+	_, err = blobUpload(context.Background(), c, io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))))
+	g.Expect(err).To(gomega.MatchError(ErrExecution))
+}
+
+func TestEncodeInputReaderArg(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	content := bytes.Repeat([]byte("streamed"), 100)
+	readers := map[string]func() io.Reader{
+		"bytes.Reader": func() io.Reader { return bytes.NewReader(content) },
+		"io.Reader":    func() io.Reader { return iotest.OneByteReader(bytes.NewReader(content)) },
+	}
+	// Codecs are checked by their data format, so that codecs that wrap
+	// PickleCodec can be used too.
+	codecs := []Codec{PickleCodec{}, &PickleCodec{}, struct{ Codec }{PickleCodec{}}}
+	for i, codec := range codecs {
+		name := fmt.Sprintf("codec %d", i)
+		payload, cleanup, err := encodeInput(codec, []any{ReaderArg{R: bytes.NewReader(content)}}, nil)
+		g.Expect(err).ShouldNot(gomega.HaveOccurred(), name)
+		data, err := io.ReadAll(payload)
+		cleanup()
+		g.Expect(err).ShouldNot(gomega.HaveOccurred(), name)
+		v, err := pickleDeserialize(data)
+		g.Expect(err).ShouldNot(gomega.HaveOccurred(), name)
+		g.Expect(v).To(gomega.Equal(pickle.Tuple{[]any{content}, map[any]any{}}), name)
+	}
+
+	for name, reader := range readers {
+		payload, cleanup, err := encodeInput(PickleCodec{}, []any{"a", ReaderArg{R: reader()}}, map[string]any{"k": &ReaderArg{R: reader()}, "n": 1})
+		g.Expect(err).ShouldNot(gomega.HaveOccurred(), name)
+		data, err := io.ReadAll(payload)
+		cleanup()
+		g.Expect(err).ShouldNot(gomega.HaveOccurred(), name)
+
+		v, err := pickleDeserialize(data)
+		g.Expect(err).ShouldNot(gomega.HaveOccurred(), name)
+		g.Expect(v).To(gomega.Equal(pickle.Tuple{
+			[]any{"a", content},
+			map[any]any{"k": content, "n": int64(1)},
+		}), name)
+	}
+
+	// Inputs are only sent as pickles, so ReaderArgs can't be used with JSON.
+	_, _, err := encodeInput(JSONCodec{}, []any{ReaderArg{R: bytes.NewReader(content)}}, nil)
+	g.Expect(err).To(gomega.MatchError(ErrInvalid))
+
+	// ReaderArgs inside other values aren't pickled as structs.
+	nested := []any{
+		[]any{ReaderArg{R: bytes.NewReader(content)}},
+		map[string]*ReaderArg{"k": {R: bytes.NewReader(content)}},
+	}
+	for _, v := range nested {
+		_, _, err = encodeInput(PickleCodec{}, []any{v}, nil)
+		g.Expect(err).To(gomega.MatchError(ErrInvalid))
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("not inside other values")))
+	}
+}
@@ -5,13 +5,22 @@ package modal
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
-	"os"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
@@ -31,22 +40,60 @@ type timeoutCallOption struct {
 // retryCallOption carries per-RPC retry overrides.
 type retryCallOption struct {
 	grpc.EmptyCallOption
-	retries         *int
-	baseDelay       *time.Duration
-	maxDelay        *time.Duration
-	delayFactor     *float64
-	additionalCodes []codes.Code
+	policy RetryPolicy
+}
+
+// retryOptions returns call options that override the client's retry policy
+// with policy, or no options if policy is nil.
+func retryOptions(policy *RetryPolicy) []grpc.CallOption {
+	if policy == nil {
+		return nil
+	}
+	return []grpc.CallOption{retryCallOption{policy: *policy}}
 }
 
 const (
-	apiEndpoint            = "api.modal.com:443"
-	maxMessageSize         = 100 * 1024 * 1024 // 100 MB
-	defaultRetryAttempts   = 3
-	defaultRetryBaseDelay  = 100 * time.Millisecond
-	defaultRetryMaxDelay   = 1 * time.Second
-	defaultRetryBackoffMul = 2.0
+	apiEndpoint    = "api.modal.com:443"
+	maxMessageSize = 100 * 1024 * 1024 // 100 MB
 )
 
+// RetryPolicy configures how failed gRPC calls are retried with exponential backoff.
+//
+// Zero-valued fields are inherited from the policy being overridden: a per-call
+// policy inherits from the client's policy, which in turn inherits from the
+// defaults. For unary RPCs the defaults are 4 attempts, a 100ms base delay, a
+// 1s max delay, a multiplier of 2 and no jitter. For reconnecting output streams
+// and filesystem operations they are 11 attempts with a 10ms base delay.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between retries.
+	MaxDelay time.Duration
+	// Multiplier is the factor by which the delay grows after each retry.
+	Multiplier float64
+	// Jitter randomizes each delay by up to this fraction of it, e.g. 0.2 for ±20%.
+	Jitter float64
+	// RetryableCodes are retried in addition to DeadlineExceeded, Unavailable,
+	// Canceled, Internal and Unknown.
+	RetryableCodes []codes.Code
+}
+
+var defaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    1 * time.Second,
+	Multiplier:  2.0,
+}
+
+var defaultStreamRetryPolicy = RetryPolicy{
+	MaxAttempts: 11,
+	BaseDelay:   10 * time.Millisecond,
+	MaxDelay:    1 * time.Second,
+	Multiplier:  2.0,
+}
+
 var retryableGrpcStatusCodes = map[codes.Code]struct{}{
 	codes.DeadlineExceeded: {},
 	codes.Unavailable:      {},
@@ -55,97 +102,556 @@ var retryableGrpcStatusCodes = map[codes.Code]struct{}{
 	codes.Unknown:          {},
 }
 
-func isRetryableGrpc(err error) bool {
-	if st, ok := status.FromError(err); ok {
-		if _, ok := retryableGrpcStatusCodes[st.Code()]; ok {
+// withDefaults returns p with zero-valued fields taken from base.
+func (p RetryPolicy) withDefaults(base RetryPolicy) RetryPolicy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = base.MaxAttempts
+	}
+	if p.BaseDelay == 0 {
+		p.BaseDelay = base.BaseDelay
+	}
+	if p.MaxDelay == 0 {
+		p.MaxDelay = base.MaxDelay
+	}
+	if p.Multiplier == 0 {
+		p.Multiplier = base.Multiplier
+	}
+	if p.Jitter == 0 {
+		p.Jitter = base.Jitter
+	}
+	p.RetryableCodes = append(append([]codes.Code{}, base.RetryableCodes...), p.RetryableCodes...)
+	return p
+}
+
+// isRetryable reports whether err is a gRPC error with a code that the policy retries.
+func (p RetryPolicy) isRetryable(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	if _, ok := retryableGrpcStatusCodes[st.Code()]; ok {
+		return true
+	}
+	for _, c := range p.RetryableCodes {
+		if c == st.Code() {
 			return true
 		}
 	}
 	return false
 }
 
-// defaultConfig caches the parsed ~/.modal.toml contents (may be empty).
-var defaultConfig config
+// jittered returns delay randomized by up to p.Jitter of its length.
+func (p RetryPolicy) jittered(delay time.Duration) time.Duration {
+	if p.Jitter <= 0 {
+		return delay
+	}
+	return time.Duration(float64(delay) * (1 + p.Jitter*(2*rand.Float64()-1)))
+}
 
-// defaultProfile is resolved at package init from MODAL_PROFILE, ~/.modal.toml, etc.
-var defaultProfile Profile
+// nextDelay returns the exponential back-off delay that follows delay.
+func (p RetryPolicy) nextDelay(delay time.Duration) time.Duration {
+	return min(time.Duration(float64(delay)*p.Multiplier), p.MaxDelay)
+}
 
-// clientProfile is the actual profile, from defaultProfile + InitializeClient().
-var clientProfile Profile
+// streamRetrier decides whether to reconnect a streaming RPC after an error,
+// waiting for the back-off delay of its RetryPolicy. Attempts are counted over
+// the lifetime of the stream, and are not reset after a successful reconnect.
+type streamRetrier struct {
+	ctx     context.Context
+	stream  string
+	policy  RetryPolicy
+	logger  *slog.Logger
+	metrics Metrics
+	retries int
+	delay   time.Duration
+}
 
-// client is the default Modal client that talks to the control plane.
-var client pb.ModalClientClient
+// newStreamRetrier returns a streamRetrier for the streaming RPC with the given
+// name, using the client's stream retry policy. Reconnects are logged with logAttrs,
+// which should identify the stream, e.g. "exec_id", execId.
+func (c *Client) newStreamRetrier(ctx context.Context, stream string, logAttrs ...any) *streamRetrier {
+	return &streamRetrier{
+		ctx:     ctx,
+		stream:  stream,
+		policy:  c.streamRetryPolicy,
+		logger:  c.logger.With(append([]any{"stream", stream}, logAttrs...)...),
+		metrics: c.metrics,
+		delay:   c.streamRetryPolicy.BaseDelay,
+	}
+}
 
-// clients is a map of server URL to input-plane client.
-var inputPlaneClients = map[string]pb.ModalClientClient{}
+// retry reports whether the stream should be reconnected after err. It returns
+// false if err is not retryable, no attempts remain, or ctx is done.
+func (r *streamRetrier) retry(err error) bool {
+	if !r.policy.isRetryable(err) {
+		return false
+	}
+	if r.retries >= r.policy.MaxAttempts-1 {
+		r.logger.WarnContext(r.ctx, "giving up reconnecting stream",
+			"attempts", r.retries+1, "code", status.Code(err).String(), "error", err)
+		return false
+	}
+	r.retries++
+	r.metrics.StreamReconnected(r.stream)
+	delay := r.policy.jittered(r.delay)
+	r.logger.DebugContext(r.ctx, "reconnecting stream",
+		"attempt", r.retries, "delay", delay, "code", status.Code(err).String())
+	if sleepCtx(r.ctx, delay) != nil {
+		return false
+	}
+	r.delay = r.policy.nextDelay(r.delay)
+	return true
+}
 
-// authToken is the auth token received from the control plane on the first request, and sent with all
-// subsequent requests to both the control plane and the input plane.
-var authToken string
+// defaultClient is the Client used by the package-level functions. It is created on
+// first use from MODAL_PROFILE, ~/.modal.toml, etc., or replaced by InitializeClient().
+var defaultClient *Client
 
-func init() {
-	defaultConfig, _ = readConfigFile()
-	defaultProfile = getProfile(os.Getenv("MODAL_PROFILE"))
-	clientProfile = defaultProfile
-	var err error
-	_, client, err = clientFactory(clientProfile)
-	if err != nil {
-		panic(fmt.Sprintf("failed to initialize Modal client at startup: %v", err))
-	}
+// defaultClientMu protects defaultClient, which InitializeClient may replace while it is in use.
+var defaultClientMu sync.RWMutex
+
+// Client is a Modal client bound to a single profile. It owns its gRPC connections,
+// auth token and input-plane client cache, so several Clients can be used in one
+// process to talk to different workspaces or environments.
+//
+// A Client is safe for concurrent use by multiple goroutines.
+type Client struct {
+	profile ResolvedProfile
+
+	// cpClient talks to the control plane.
+	cpClient pb.ModalClientClient
+
+	mu sync.Mutex // protects ipClients, authToken, conns, closing, closed and sandboxes
+
+	// ipClients is a map of server URL to input-plane client.
+	ipClients map[string]pb.ModalClientClient
+
+	// authToken is the auth token received from the control plane on the first request, and sent with all
+	// subsequent requests to both the control plane and the input plane.
+	authToken string
+
+	// credentials supplies the token sent with each RPC, nil if there is none.
+	credentials CredentialsProvider
+
+	retryPolicy       RetryPolicy // default retries for unary RPCs
+	streamRetryPolicy RetryPolicy // default reconnects for output streams and filesystem operations
+
+	unaryInterceptors  []grpc.UnaryClientInterceptor
+	streamInterceptors []grpc.StreamClientInterceptor
+	dialOptions        []grpc.DialOption
+
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+
+	logger  *slog.Logger
+	metrics Metrics
+
+	tlsConfig    *tls.Config
+	proxyURL     *url.URL
+	customDialer Dialer
+	httpClient   *http.Client // for blob uploads and downloads
+
+	codec Codec // default codec of Functions and Queues
+
+	// closeCtx is cancelled by Close, stopping background goroutines, which are
+	// tracked by wg.
+	closeCtx    context.Context
+	closeCancel context.CancelFunc
+	wg          sync.WaitGroup
+	closing     bool // Close has been called, and may still be terminating Sandboxes
+	closed      bool
+	conns       []grpc.ClientConnInterface // control-plane and input-plane connections
+
+	// sandboxes created by this client that Close terminates, if terminateSandboxesOnClose.
+	sandboxes                 map[string]*Sandbox
+	terminateSandboxesOnClose bool
 }
 
-// ClientOptions defines credentials and options for initializing the Modal client at runtime.
+// ClientOptions defines credentials and options for initializing a Modal client at runtime.
 type ClientOptions struct {
 	TokenId     string
 	TokenSecret string
 	Environment string // optional, defaults to the profile's environment
+	Profile     string // optional, name of a profile in ~/.modal.toml, defaults to MODAL_PROFILE or the active profile
+
+	// Credentials supplies the token of the client, which is fetched again for
+	// every RPC, so that it can be rotated. It takes precedence over TokenId and
+	// TokenSecret. Defaults to the token of the resolved profile.
+	Credentials CredentialsProvider
+
+	// RetryPolicy overrides the default retries of unary RPCs made by this client.
+	RetryPolicy *RetryPolicy
+	// StreamRetryPolicy overrides the default reconnects of sandbox and exec output
+	// streams, and of sandbox filesystem operations.
+	StreamRetryPolicy *RetryPolicy
+
+	// UnaryInterceptors are added to every gRPC connection of the client, to
+	// both the control plane and the input planes. They run after the built-in
+	// auth, retry and timeout interceptors, so they are invoked once per attempt.
+	UnaryInterceptors []grpc.UnaryClientInterceptor
+	// StreamInterceptors are added to every gRPC connection of the client.
+	StreamInterceptors []grpc.StreamClientInterceptor
+	// DialOptions are appended to the client's own options when dialing each
+	// gRPC connection, and take precedence over them.
+	DialOptions []grpc.DialOption
+
+	// TracerProvider enables OpenTelemetry tracing if set. The client creates a
+	// span for each RPC, with retries recorded as events, and for high-level
+	// operations such as Function.Remote, App.CreateSandbox and Queue.Put.
+	TracerProvider trace.TracerProvider
+	// Propagator injects the trace context into the metadata of each RPC.
+	// Defaults to W3C Trace Context.
+	Propagator propagation.TextMapPropagator
+
+	// Logger receives records about retried RPCs, reconnected streams and failed
+	// heartbeats. Credentials are never logged. Defaults to discarding all records.
+	Logger *slog.Logger
+
+	// Metrics receives RPC latencies, retries, blob transfer sizes and stream
+	// reconnects. Defaults to discarding all measurements.
+	Metrics Metrics
+
+	// TLSConfig is used for connections to https server URLs, of both the
+	// control plane and the input planes, and for blob transfers.
+	TLSConfig *tls.Config
+	// ProxyURL is an HTTP proxy used for all connections, which are tunneled with
+	// CONNECT. Defaults to the HTTPS_PROXY environment variable.
+	ProxyURL *url.URL
+	// Dialer opens connections to the server, or to ProxyURL if set.
+	Dialer Dialer
+	// HTTPClient is used to upload and download blobs, such as large Function
+	// inputs and outputs. Defaults to a client using TLSConfig, ProxyURL and Dialer.
+	HTTPClient *http.Client
+
+	// Codec encodes Function arguments and Queue items, and decodes Queue items
+	// and Function results in its DataFormat, for Functions and Queues without a
+	// codec of their own. Defaults to PickleCodec. Function arguments can only be
+	// encoded by codecs with DataFormatPickle for now.
+	Codec Codec
+
+	// TerminateSandboxesOnClose makes Client.Close terminate the Sandboxes created
+	// with the client by App.CreateSandbox that have not been terminated yet.
+	TerminateSandboxesOnClose bool
+}
+
+// NewClient creates a new Modal client. Options that are left empty are resolved
+// from the environment and config file, in the same way as for the default client
+// (see ResolveProfile). Errors reading the config file are returned here.
+func NewClient(options ClientOptions) (*Client, error) {
+	profile, err := ResolveProfile(options)
+	if err != nil {
+		return nil, err
+	}
+	return newClientFromProfile(profile, options)
 }
 
-// InitializeClient updates the global Modal client configuration with the provided options.
+// newClientFromProfile creates a Client for a resolved profile. Profile fields
+// in options are ignored.
+func newClientFromProfile(profile ResolvedProfile, options ClientOptions) (*Client, error) {
+	c := &Client{
+		profile:            profile,
+		credentials:        options.Credentials,
+		ipClients:          map[string]pb.ModalClientClient{},
+		retryPolicy:        defaultRetryPolicy,
+		streamRetryPolicy:  defaultStreamRetryPolicy,
+		unaryInterceptors:  options.UnaryInterceptors,
+		streamInterceptors: options.StreamInterceptors,
+		dialOptions:        options.DialOptions,
+		tracer:             newTracer(options.TracerProvider),
+		propagator:         options.Propagator,
+		logger:             options.Logger,
+		metrics:            options.Metrics,
+		tlsConfig:          options.TLSConfig,
+		proxyURL:           options.ProxyURL,
+		customDialer:       options.Dialer,
+		httpClient:         options.HTTPClient,
+		codec:              options.Codec,
+
+		sandboxes:                 map[string]*Sandbox{},
+		terminateSandboxesOnClose: options.TerminateSandboxesOnClose,
+	}
+	c.closeCtx, c.closeCancel = context.WithCancel(context.Background())
+	if c.credentials == nil && profile.TokenId != "" && profile.TokenSecret != "" {
+		c.credentials = StaticCredentials(profile.TokenId, profile.TokenSecret)
+	}
+	if c.httpClient == nil {
+		c.httpClient = newHTTPClient(c.tlsConfig, c.proxyURL, c.customDialer)
+	}
+	if c.logger == nil {
+		c.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	if c.metrics == nil {
+		c.metrics = noopMetrics{}
+	}
+	if c.codec == nil {
+		c.codec = PickleCodec{}
+	}
+	if c.propagator == nil {
+		c.propagator = propagation.TraceContext{}
+	}
+	if options.RetryPolicy != nil {
+		c.retryPolicy = options.RetryPolicy.withDefaults(defaultRetryPolicy)
+	}
+	if options.StreamRetryPolicy != nil {
+		c.streamRetryPolicy = options.StreamRetryPolicy.withDefaults(defaultStreamRetryPolicy)
+	}
+	conn, cpClient, err := clientFactory(profile.Profile, c)
+	if err != nil {
+		return nil, err
+	}
+	c.cpClient = cpClient
+	c.conns = append(c.conns, conn)
+	return c, nil
+}
+
+// Close releases the resources of the client. It stops the heartbeats of
+// ephemeral objects, so that they are cleaned up, cancels output streams of
+// Sandboxes and processes, and closes all gRPC connections, including those to
+// input planes. If ClientOptions.TerminateSandboxesOnClose was set, Sandboxes
+// created with the client are terminated first.
+//
+// Close waits for background goroutines to exit until ctx is done. The client
+// and objects created with it must not be used after Close. Closing a client
+// more than once has no effect.
+func (c *Client) Close(ctx context.Context) error {
+	c.mu.Lock()
+	if c.closing {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closing = true
+	sandboxes := make([]*Sandbox, 0, len(c.sandboxes))
+	for _, sb := range c.sandboxes {
+		sandboxes = append(sandboxes, sb)
+	}
+	c.mu.Unlock()
+
+	var errs []error
+	if len(sandboxes) > 0 {
+		terminateCtx, err := c.clientContext(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to terminate sandboxes: %w", err))
+			sandboxes = nil
+		}
+		for _, sb := range sandboxes {
+			_, err := c.cpClient.SandboxTerminate(terminateCtx, pb.SandboxTerminateRequest_builder{
+				SandboxId: sb.SandboxId,
+			}.Build())
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to terminate sandbox %s: %w", sb.SandboxId, err))
+			}
+		}
+	}
+
+	c.mu.Lock()
+	c.closed = true
+	conns := c.conns
+	c.conns = nil
+	c.mu.Unlock()
+
+	c.closeCancel()
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("waiting for background goroutines: %w", ctx.Err()))
+	}
+
+	for _, conn := range conns {
+		if closer, ok := conn.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// goBackground runs f in a goroutine that Close waits for. The context passed
+// to f is cancelled when ctx is done or the client is closed.
+func (c *Client) goBackground(ctx context.Context, f func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(c.closeCtx, cancel)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		cancel() // f still runs, so that it can report that the client is closed
+	} else {
+		c.wg.Add(1)
+	}
+	closed := c.closed
+	go func() {
+		defer cancel()
+		defer stop()
+		if !closed {
+			defer c.wg.Done()
+		}
+		f(ctx)
+	}()
+}
+
+// isClosed reports whether Close has been called.
+func (c *Client) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// closedInterceptor fails RPCs made after Close without retrying them.
+func closedInterceptor(c *Client) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply any,
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		if c.isClosed() {
+			return ErrClientClosed
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// trackSandbox records a Sandbox created by the client, for Close to terminate.
+func (c *Client) trackSandbox(sb *Sandbox) {
+	if !c.terminateSandboxesOnClose {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sandboxes[sb.SandboxId] = sb
+}
+
+// untrackSandbox forgets a Sandbox that has been terminated.
+func (c *Client) untrackSandbox(sandboxId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.sandboxes, sandboxId)
+}
+
+// Init creates the default Modal client, returning any error from resolving the
+// profile, such as a malformed config file.
+//
+// Calling Init is optional: otherwise the default client is created on first use,
+// and the same errors are returned from the first package-level call.
+func Init() error {
+	_, err := getDefaultClient()
+	return err
+}
+
+// InitializeClient replaces the default Modal client with one built from the provided options.
 //
 // This function is useful when you want to set the client options programmatically. It
-// should be called once at the start of your application.
+// should be called once at the start of your application. To use several sets of
+// credentials in one process, use NewClient instead.
 func InitializeClient(options ClientOptions) error {
-	mergedProfile := defaultProfile
-	mergedProfile.TokenId = options.TokenId
-	mergedProfile.TokenSecret = options.TokenSecret
-	mergedProfile.Environment = firstNonEmpty(options.Environment, mergedProfile.Environment)
-	clientProfile = mergedProfile
-	var err error
-	_, client, err = clientFactory(mergedProfile)
-	return err
+	c, err := NewClient(options)
+	if err != nil {
+		return err
+	}
+	defaultClientMu.Lock()
+	defaultClient = c
+	defaultClientMu.Unlock()
+	return nil
+}
+
+// getDefaultClient returns the Client used by the package-level functions, creating it
+// on first use. If creation fails, the error is returned and creation is retried on the next call.
+func getDefaultClient() (*Client, error) {
+	defaultClientMu.RLock()
+	c := defaultClient
+	defaultClientMu.RUnlock()
+	if c != nil {
+		return c, nil
+	}
+
+	defaultClientMu.Lock()
+	defer defaultClientMu.Unlock()
+	if defaultClient == nil {
+		c, err := NewClient(ClientOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize Modal client: %w", err)
+		}
+		defaultClient = c
+	}
+	return defaultClient, nil
+}
+
+// clientOrDefault returns c, or the default client if c is nil. This supports
+// objects that were constructed directly instead of through a Client.
+func clientOrDefault(c *Client) (*Client, error) {
+	if c == nil {
+		return getDefaultClient()
+	}
+	return c, nil
+}
+
+// Profile returns the resolved profile that the client was created with.
+func (c *Client) Profile() Profile {
+	return c.profile.Profile
+}
+
+// ResolvedProfile returns the profile that the client was created with, along
+// with the config file it was read from and the source of each value.
+func (c *Client) ResolvedProfile() ResolvedProfile {
+	return c.profile
 }
 
 // getOrCreateInputPlaneClient returns a client for the given server URL, creating it if it doesn't exist.
-func getOrCreateInputPlaneClient(serverURL string) (pb.ModalClientClient, error) {
-	if client, ok := inputPlaneClients[serverURL]; ok {
+func (c *Client) getOrCreateInputPlaneClient(serverURL string) (pb.ModalClientClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClientClosed // Close has taken the connections to close
+	}
+	if client, ok := c.ipClients[serverURL]; ok {
 		return client, nil
 	}
 
-	profile := clientProfile
+	profile := c.profile.Profile
 	profile.ServerURL = serverURL
-	_, client, err := clientFactory(profile)
+	conn, client, err := clientFactory(profile, c)
 	if err != nil {
 		return nil, err
 	}
-	inputPlaneClients[serverURL] = client
+	c.ipClients[serverURL] = client
+	c.conns = append(c.conns, conn)
 	return client, nil
 }
 
-// clientFactory is the factory used to construct gRPC connections and stubs.
+func (c *Client) environmentName(environment string) string {
+	return firstNonEmpty(environment, c.profile.Environment)
+}
+
+func (c *Client) imageBuilderVersion(version string) string {
+	return firstNonEmpty(version, c.profile.ImageBuilderVersion, "2024.10")
+}
+
+// clientFactory is the factory used to construct gRPC connections and stubs for a Client.
 // Tests may override this variable to install a mock.
-var clientFactory func(Profile) (grpc.ClientConnInterface, pb.ModalClientClient, error) = func(profile Profile) (grpc.ClientConnInterface, pb.ModalClientClient, error) {
-	return newClient(profile)
+var clientFactory func(Profile, *Client) (grpc.ClientConnInterface, pb.ModalClientClient, error) = func(profile Profile, c *Client) (grpc.ClientConnInterface, pb.ModalClientClient, error) {
+	return newClient(profile, c)
 }
 
 // newClient dials the given server URL with auth/timeout/retry interceptors installed.
 // It returns (conn, stub). Close the conn when done.
-func newClient(profile Profile) (*grpc.ClientConn, pb.ModalClientClient, error) {
+func newClient(profile Profile, c *Client) (*grpc.ClientConn, pb.ModalClientClient, error) {
 	var target string
 	var creds credentials.TransportCredentials
 	if after, ok := strings.CutPrefix(profile.ServerURL, "https://"); ok {
 		target = after
-		creds = credentials.NewTLS(&tls.Config{})
+		tlsConfig := &tls.Config{}
+		if c.tlsConfig != nil {
+			tlsConfig = c.tlsConfig.Clone()
+		}
+		creds = credentials.NewTLS(tlsConfig)
 	} else if after, ok := strings.CutPrefix(profile.ServerURL, "http://"); ok {
 		target = after
 		creds = insecure.NewCredentials()
@@ -153,45 +659,70 @@ func newClient(profile Profile) (*grpc.ClientConn, pb.ModalClientClient, error)
 		return nil, nil, status.Errorf(codes.InvalidArgument, "invalid server URL: %s", profile.ServerURL)
 	}
 
-	conn, err := grpc.NewClient(
-		target,
+	unaryInterceptors := append([]grpc.UnaryClientInterceptor{
+		errorsInterceptor(),
+		closedInterceptor(c),
+		tracingInterceptor(c),
+		metricsInterceptor(c),
+		retryInterceptor(c),
+		timeoutInterceptor(),
+		credentialsInterceptor(c),
+		authTokenInterceptor(c),
+	}, c.unaryInterceptors...)
+	dialOptions := []grpc.DialOption{
 		grpc.WithTransportCredentials(creds),
 		grpc.WithDefaultCallOptions(
 			grpc.MaxCallRecvMsgSize(maxMessageSize),
 			grpc.MaxCallSendMsgSize(maxMessageSize),
 		),
-		grpc.WithChainUnaryInterceptor(
-			authTokenInterceptor(),
-			retryInterceptor(),
-			timeoutInterceptor(),
-		),
-	)
+		grpc.WithChainUnaryInterceptor(unaryInterceptors...),
+		grpc.WithChainStreamInterceptor(append([]grpc.StreamClientInterceptor{
+			errorsStreamInterceptor(),
+			tracingStreamInterceptor(c),
+			credentialsStreamInterceptor(c),
+		}, c.streamInterceptors...)...),
+	}
+	if dialer := c.dialer(); dialer != nil {
+		dialOptions = append(dialOptions, grpc.WithContextDialer(dialer))
+	}
+	dialOptions = append(dialOptions, c.dialOptions...)
+
+	conn, err := grpc.NewClient(target, dialOptions...)
 	if err != nil {
 		return nil, nil, err
 	}
 	return conn, pb.NewModalClientClient(conn), nil
 }
 
-// clientContext returns a context with the default profile's auth headers.
-func clientContext(ctx context.Context) (context.Context, error) {
-	if clientProfile.TokenId == "" || clientProfile.TokenSecret == "" {
-		return nil, fmt.Errorf("missing token_id or token_secret, please set in .modal.toml, environment variables, or via InitializeClient()")
+// clientContext returns a context with the client headers, whose RPCs are sent
+// with the client's credentials. The credentials themselves are added to each
+// RPC by credentialsInterceptor, so that objects which keep the context pick up
+// rotated credentials.
+func (c *Client) clientContext(ctx context.Context) (context.Context, error) {
+	if c.isClosed() {
+		return nil, ErrClientClosed
+	}
+	if ctx == nil {
+		ctx = context.Background() // e.g. a Function that was not created by a lookup
 	}
+	return context.WithValue(c.anonymousContext(ctx), authenticatedKey{}, true), nil
+}
 
+// anonymousContext returns a context with the client headers but no credentials,
+// for RPCs that don't need them, such as creating a token.
+func (c *Client) anonymousContext(ctx context.Context) context.Context {
 	clientType := strconv.Itoa(int(pb.ClientType_CLIENT_TYPE_LIBMODAL_GO))
 	return metadata.AppendToOutgoingContext(
 		ctx,
 		"x-modal-client-type", clientType,
 		"x-modal-client-version", "1.0.0", // CLIENT VERSION: Behaves like this Python SDK version
-		"x-modal-token-id", clientProfile.TokenId,
-		"x-modal-token-secret", clientProfile.TokenSecret,
-	), nil
+	)
 }
 
 // authTokenInterceptor handles sending and receiving the "x-modal-auth-token" header.
 // We receive an auth token from the control plane on our first request. We then include that auth token in every
-// subsequent request to both the control plane and the input plane.
-func authTokenInterceptor() grpc.UnaryClientInterceptor {
+// subsequent request to both the control plane and the input plane of the same Client.
+func authTokenInterceptor(c *Client) grpc.UnaryClientInterceptor {
 	return func(
 		ctx context.Context,
 		method string,
@@ -202,23 +733,35 @@ func authTokenInterceptor() grpc.UnaryClientInterceptor {
 	) error {
 		var headers, trailers metadata.MD
 		// Add authToken to outgoing context if it's set
-		if authToken != "" {
-			ctx = metadata.AppendToOutgoingContext(ctx, "x-modal-auth-token", authToken)
+		if token := c.getAuthToken(); token != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "x-modal-auth-token", token)
 		}
 		opts = append(opts, grpc.Header(&headers), grpc.Trailer(&trailers))
 		err := inv(ctx, method, req, reply, cc, opts...)
 		// If we're talking to the control plane, and no auth token was sent, it will return one.
 		// The python server returns it in the trailers, the worker returns it in the headers.
 		if val, ok := headers["x-modal-auth-token"]; ok {
-			authToken = val[0]
+			c.setAuthToken(val[0])
 		} else if val, ok := trailers["x-modal-auth-token"]; ok {
-			authToken = val[0]
+			c.setAuthToken(val[0])
 		}
 
 		return err
 	}
 }
 
+func (c *Client) getAuthToken() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.authToken
+}
+
+func (c *Client) setAuthToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.authToken = token
+}
+
 func timeoutInterceptor() grpc.UnaryClientInterceptor {
 	return func(
 		ctx context.Context,
@@ -245,7 +788,7 @@ func timeoutInterceptor() grpc.UnaryClientInterceptor {
 	}
 }
 
-func retryInterceptor() grpc.UnaryClientInterceptor {
+func retryInterceptor(c *Client) grpc.UnaryClientInterceptor {
 	return func(
 		ctx context.Context,
 		method string,
@@ -254,44 +797,21 @@ func retryInterceptor() grpc.UnaryClientInterceptor {
 		inv grpc.UnaryInvoker,
 		opts ...grpc.CallOption,
 	) error {
-		// start with package defaults
-		retries := defaultRetryAttempts
-		baseDelay := defaultRetryBaseDelay
-		maxDelay := defaultRetryMaxDelay
-		factor := defaultRetryBackoffMul
-		retryable := retryableGrpcStatusCodes
+		// start with the client's policy
+		policy := c.retryPolicy
 
 		// override from call-options (first one wins)
 		for _, o := range opts {
 			if rc, ok := o.(retryCallOption); ok {
-				if rc.retries != nil {
-					retries = *rc.retries
-				}
-				if rc.baseDelay != nil {
-					baseDelay = *rc.baseDelay
-				}
-				if rc.maxDelay != nil {
-					maxDelay = *rc.maxDelay
-				}
-				if rc.delayFactor != nil {
-					factor = *rc.delayFactor
-				}
-				if len(rc.additionalCodes) > 0 {
-					retryable = map[codes.Code]struct{}{}
-					for k := range retryableGrpcStatusCodes {
-						retryable[k] = struct{}{}
-					}
-					for _, c := range rc.additionalCodes {
-						retryable[c] = struct{}{}
-					}
-				}
+				policy = rc.policy.withDefaults(policy)
 				break
 			}
 		}
 
 		idempotency := uuid.NewString()
 		start := time.Now()
-		delay := baseDelay
+		delay := policy.BaseDelay
+		retries := policy.MaxAttempts - 1
 
 		for attempt := 0; attempt <= retries; attempt++ {
 			aCtx := metadata.AppendToOutgoingContext(
@@ -306,20 +826,31 @@ func retryInterceptor() grpc.UnaryClientInterceptor {
 				return nil
 			}
 
-			if st, ok := status.FromError(err); ok { // gRPC error
-				if _, ok := retryable[st.Code()]; !ok || attempt == retries {
-					return err
-				}
-			} else { // Unexpected, non-gRPC error
+			// Unexpected, non-gRPC errors are not retryable.
+			if !policy.isRetryable(err) {
+				return err
+			}
+			if attempt == retries {
+				c.logger.WarnContext(ctx, "RPC failed after retries",
+					"method", method, "attempts", attempt+1, "code", status.Code(err).String(), "error", err)
 				return err
 			}
 
-			if sleepCtx(ctx, delay) != nil {
+			c.metrics.RPCRetried(method, status.Code(err))
+			sleep := policy.jittered(delay)
+			c.logger.DebugContext(ctx, "retrying RPC",
+				"method", method, "attempt", attempt+1, "delay", sleep, "code", status.Code(err).String())
+			trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(
+				attribute.Int("rpc.retry.attempt", attempt+1),
+				attribute.String("rpc.grpc.status_code", status.Code(err).String()),
+				attribute.Int64("rpc.retry.delay_ms", sleep.Milliseconds()),
+			))
+			if sleepCtx(ctx, sleep) != nil {
 				return err // ctx cancelled or deadline exceeded
 			}
 
 			// exponential back-off
-			delay = min(delay*time.Duration(factor), maxDelay)
+			delay = policy.nextDelay(delay)
 		}
 		return nil // unreachable
 	}
@@ -0,0 +1,533 @@
+package modal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// newTestClient returns a Client with options whose control plane RPCs are
+// answered by fake, or sent to https://localhost:1 if fake is nil.
+func newTestClient(g *gomega.WithT, fake pb.ModalClientClient, options ClientOptions) *Client {
+	c, err := newClientFromProfile(ResolvedProfile{Profile: Profile{ServerURL: "https://localhost:1", TokenId: "ak-123", TokenSecret: "as-123"}}, options)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	if fake != nil {
+		c.cpClient = fake
+	}
+	return c
+}
+
+func TestAuthTokenInterceptorConcurrent(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	c := &Client{ipClients: map[string]pb.ModalClientClient{}}
+	interceptor := authTokenInterceptor(c)
+
+	// Fake invoker that returns a new auth token in the response headers.
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		for _, o := range opts {
+			if h, ok := o.(grpc.HeaderCallOption); ok {
+				*h.HeaderAddr = metadata.Pairs("x-modal-auth-token", "token-"+method)
+			}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for i := range 64 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := interceptor(context.Background(), fmt.Sprintf("/Method%d", i%4), nil, nil, nil, invoker)
+			g.Expect(err).ShouldNot(gomega.HaveOccurred())
+		}()
+	}
+	wg.Wait()
+	g.Expect(c.getAuthToken()).To(gomega.HavePrefix("token-/Method"))
+}
+
+func TestGetOrCreateInputPlaneClientConcurrent(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	var mu sync.Mutex
+	created := map[string]int{}
+	restore := SetClientFactoryForTesting(func(profile Profile) (grpc.ClientConnInterface, pb.ModalClientClient, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		created[profile.ServerURL]++
+		return nil, pb.NewModalClientClient(nil), nil
+	})
+	t.Cleanup(restore)
+
+	c := newTestClient(g, nil, ClientOptions{})
+
+	var wg sync.WaitGroup
+	for i := range 64 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.getOrCreateInputPlaneClient(fmt.Sprintf("https://ip-%d.modal.com", i%4))
+			g.Expect(err).ShouldNot(gomega.HaveOccurred())
+		}()
+	}
+	wg.Wait()
+
+	for i := range 4 {
+		g.Expect(created[fmt.Sprintf("https://ip-%d.modal.com", i)]).To(gomega.Equal(1))
+	}
+}
+
+func TestRetryInterceptorPolicy(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	c := newTestClient(g, nil, ClientOptions{
+		RetryPolicy: &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+	})
+	g.Expect(c.retryPolicy.MaxDelay).To(gomega.Equal(defaultRetryPolicy.MaxDelay))
+	interceptor := retryInterceptor(c)
+
+	var attempts int
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		attempts++
+		return status.Error(codes.ResourceExhausted, "busy")
+	}
+
+	// ResourceExhausted is not retried by default.
+	err := interceptor(context.Background(), "/Method", nil, nil, nil, invoker)
+	g.Expect(status.Code(err)).To(gomega.Equal(codes.ResourceExhausted))
+	g.Expect(attempts).To(gomega.Equal(1))
+
+	// A per-call policy adds retryable codes, and inherits the client's other settings.
+	attempts = 0
+	err = interceptor(context.Background(), "/Method", nil, nil, nil, invoker,
+		retryOptions(&RetryPolicy{RetryableCodes: []codes.Code{codes.ResourceExhausted}})...)
+	g.Expect(status.Code(err)).To(gomega.Equal(codes.ResourceExhausted))
+	g.Expect(attempts).To(gomega.Equal(3))
+
+	attempts = 0
+	err = interceptor(context.Background(), "/Method", nil, nil, nil, invoker,
+		retryOptions(&RetryPolicy{MaxAttempts: 5, RetryableCodes: []codes.Code{codes.ResourceExhausted}})...)
+	g.Expect(status.Code(err)).To(gomega.Equal(codes.ResourceExhausted))
+	g.Expect(attempts).To(gomega.Equal(5))
+}
+
+func TestRetryPolicyDelays(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	p := RetryPolicy{Multiplier: 1.5, MaxDelay: 2 * time.Second, Jitter: 0.2}.withDefaults(defaultRetryPolicy)
+	g.Expect(p.BaseDelay).To(gomega.Equal(100 * time.Millisecond))
+	g.Expect(p.nextDelay(time.Second)).To(gomega.Equal(1500 * time.Millisecond))
+	g.Expect(p.nextDelay(1500 * time.Millisecond)).To(gomega.Equal(2 * time.Second))
+	for range 100 {
+		d := p.jittered(time.Second)
+		g.Expect(d).To(gomega.BeNumerically(">=", 800*time.Millisecond))
+		g.Expect(d).To(gomega.BeNumerically("<=", 1200*time.Millisecond))
+	}
+}
+
+func TestStreamRetrier(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	var logs bytes.Buffer
+	c := newTestClient(g, nil, ClientOptions{
+		StreamRetryPolicy: &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		Logger:            slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})
+
+	r := c.newStreamRetrier(context.Background(), "ContainerExecGetOutput", "exec_id", "ce-123")
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	g.Expect(r.retry(status.Error(codes.NotFound, "not found"))).To(gomega.BeFalse())
+	g.Expect(r.retry(unavailable)).To(gomega.BeTrue())
+	g.Expect(r.retry(unavailable)).To(gomega.BeTrue())
+	g.Expect(r.retry(unavailable)).To(gomega.BeFalse())
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	g.Expect(lines).To(gomega.HaveLen(3))
+	g.Expect(lines[0]).To(gomega.ContainSubstring(`level=DEBUG msg="reconnecting stream" stream=ContainerExecGetOutput exec_id=ce-123 attempt=1`))
+	g.Expect(lines[2]).To(gomega.ContainSubstring(`level=WARN msg="giving up reconnecting stream" stream=ContainerExecGetOutput exec_id=ce-123 attempts=3 code=Unavailable`))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r = c.newStreamRetrier(ctx, "SandboxGetLogs")
+	g.Expect(r.retry(unavailable)).To(gomega.BeFalse())
+}
+
+func TestClientInterceptorsOnAllConnections(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	errIntercepted := errors.New("intercepted")
+	var mu sync.Mutex
+	var methods []string
+	interceptor := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		mu.Lock()
+		defer mu.Unlock()
+		methods = append(methods, cc.Target()+method)
+		return errIntercepted
+	}
+
+	c := newTestClient(g, nil, ClientOptions{
+		UnaryInterceptors: []grpc.UnaryClientInterceptor{interceptor},
+	})
+
+	_, err := c.cpClient.AppGetOrCreate(context.Background(), &pb.AppGetOrCreateRequest{})
+	g.Expect(err).To(gomega.MatchError(errIntercepted))
+
+	ipClient, err := c.getOrCreateInputPlaneClient("http://localhost:2")
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	_, err = ipClient.AttemptStart(context.Background(), &pb.AttemptStartRequest{})
+	g.Expect(err).To(gomega.MatchError(errIntercepted))
+
+	g.Expect(methods).To(gomega.Equal([]string{
+		"localhost:1/modal.client.ModalClient/AppGetOrCreate",
+		"localhost:2/modal.client.ModalClient/AttemptStart",
+	}))
+}
+
+func TestRetryInterceptorLogging(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	var logs bytes.Buffer
+	c := newTestClient(g, nil, ClientOptions{
+		RetryPolicy: &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+		Logger:      slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})
+	ctx, err := c.clientContext(context.Background())
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return status.Error(codes.Unavailable, "unavailable")
+	}
+	err = retryInterceptor(c)(ctx, "/modal.client.ModalClient/QueuePut", nil, nil, nil, invoker)
+	g.Expect(status.Code(err)).To(gomega.Equal(codes.Unavailable))
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	g.Expect(lines).To(gomega.HaveLen(2))
+	g.Expect(lines[0]).To(gomega.ContainSubstring(`level=DEBUG msg="retrying RPC" method=/modal.client.ModalClient/QueuePut attempt=1 delay=1ms code=Unavailable`))
+	g.Expect(lines[1]).To(gomega.ContainSubstring(`level=WARN msg="RPC failed after retries" method=/modal.client.ModalClient/QueuePut attempts=2 code=Unavailable`))
+	g.Expect(logs.String()).ShouldNot(gomega.ContainSubstring("as-123"))
+}
+
+// closeTestModalClient is a fake control plane for TestClientClose. Methods that
+// are not overridden panic.
+type closeTestModalClient struct {
+	pb.ModalClientClient
+
+	mu         sync.Mutex
+	terminated []string
+}
+
+func (f *closeTestModalClient) QueueGetOrCreate(ctx context.Context, in *pb.QueueGetOrCreateRequest, opts ...grpc.CallOption) (*pb.QueueGetOrCreateResponse, error) {
+	return pb.QueueGetOrCreateResponse_builder{QueueId: "qu-123"}.Build(), nil
+}
+
+func (f *closeTestModalClient) ImageGetOrCreate(ctx context.Context, in *pb.ImageGetOrCreateRequest, opts ...grpc.CallOption) (*pb.ImageGetOrCreateResponse, error) {
+	return pb.ImageGetOrCreateResponse_builder{
+		ImageId: "im-123",
+		Result:  pb.GenericResult_builder{Status: pb.GenericResult_GENERIC_STATUS_SUCCESS}.Build(),
+	}.Build(), nil
+}
+
+func (f *closeTestModalClient) SandboxCreate(ctx context.Context, in *pb.SandboxCreateRequest, opts ...grpc.CallOption) (*pb.SandboxCreateResponse, error) {
+	return pb.SandboxCreateResponse_builder{SandboxId: "sb-123"}.Build(), nil
+}
+
+func (f *closeTestModalClient) SandboxTerminate(ctx context.Context, in *pb.SandboxTerminateRequest, opts ...grpc.CallOption) (*pb.SandboxTerminateResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.terminated = append(f.terminated, in.GetSandboxId())
+	return &pb.SandboxTerminateResponse{}, nil
+}
+
+func (f *closeTestModalClient) SandboxGetLogs(ctx context.Context, in *pb.SandboxGetLogsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[pb.TaskLogsBatch], error) {
+	return blockingStream[pb.TaskLogsBatch]{ctx: ctx}, nil
+}
+
+// blockingStream is a server stream that blocks until its context is cancelled.
+type blockingStream[T any] struct {
+	grpc.ClientStream
+	ctx context.Context
+}
+
+func (s blockingStream[T]) Recv() (*T, error) {
+	<-s.ctx.Done()
+	return nil, status.FromContextError(s.ctx.Err()).Err()
+}
+
+// backgroundGoroutines returns the stacks of goroutines started by Client.goBackground.
+func backgroundGoroutines() []string {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	var stacks []string
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(stack, "(*Client).goBackground") {
+			stacks = append(stacks, stack)
+		}
+	}
+	return stacks
+}
+
+// TestClientClose is not parallel, so that it can check for leaked goroutines.
+func TestClientClose(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	fake := &closeTestModalClient{}
+	c := newTestClient(g, fake, ClientOptions{TerminateSandboxesOnClose: true})
+	ctx := context.Background()
+
+	_, err := c.QueueEphemeral(ctx, nil)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	app := &App{AppId: "ap-123", client: c}
+	sb, err := app.CreateSandbox(NewImageFromRegistry("alpine:3.21", nil), nil)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	stdout := outputStreamSb(ctx, c, sb.SandboxId, pb.FileDescriptor_FILE_DESCRIPTOR_STDOUT)
+	_, err = c.getOrCreateInputPlaneClient("https://localhost:2")
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	// Queue heartbeat, Sandbox stdout and stderr, and stdout above.
+	g.Expect(backgroundGoroutines()).To(gomega.HaveLen(4))
+
+	g.Expect(c.Close(ctx)).To(gomega.Succeed())
+
+	g.Expect(fake.terminated).To(gomega.Equal([]string{"sb-123"}))
+	g.Expect(backgroundGoroutines()).To(gomega.BeEmpty())
+	_, err = io.ReadAll(stdout)
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("error getting output stream")))
+	g.Expect(c.conns).To(gomega.BeEmpty())
+
+	_, err = c.QueueLookup(ctx, "my-queue", nil)
+	g.Expect(err).To(gomega.MatchError(ErrClientClosed))
+	g.Expect(c.Close(ctx)).To(gomega.Succeed())
+}
+
+func TestClientCloseConcurrent(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	fake := &closeTestModalClient{}
+	c := newTestClient(g, fake, ClientOptions{TerminateSandboxesOnClose: true})
+	app := &App{AppId: "ap-123", client: c}
+	_, err := app.CreateSandbox(NewImageFromRegistry("alpine:3.21", nil), nil)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+	// Sandboxes are terminated once, however many times Close is called.
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Expect(c.Close(context.Background())).To(gomega.Succeed())
+		}()
+	}
+	wg.Wait()
+	g.Expect(fake.terminated).To(gomega.Equal([]string{"sb-123"}))
+}
+
+func TestClientCloseConnections(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	c := newTestClient(g, nil, ClientOptions{})
+	_, err := c.getOrCreateInputPlaneClient("https://localhost:2")
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	conns := c.conns
+	g.Expect(conns).To(gomega.HaveLen(2))
+
+	g.Expect(c.Close(context.Background())).To(gomega.Succeed())
+	for _, conn := range conns {
+		g.Expect(conn.(*grpc.ClientConn).GetState()).To(gomega.Equal(connectivity.Shutdown))
+	}
+
+	// RPCs on a closed client fail immediately, without retries.
+	_, err = c.cpClient.AppGetOrCreate(context.Background(), &pb.AppGetOrCreateRequest{})
+	g.Expect(err).To(gomega.MatchError(ErrClientClosed))
+
+	// No connections are created after Close, since they wouldn't be closed.
+	_, err = c.getOrCreateInputPlaneClient("https://localhost:3")
+	g.Expect(err).To(gomega.MatchError(ErrClientClosed))
+	_, err = c.getOrCreateInputPlaneClient("https://localhost:2")
+	g.Expect(err).To(gomega.MatchError(ErrClientClosed))
+	g.Expect(c.conns).To(gomega.BeEmpty())
+}
+
+// contextTestModalClient is a fake control plane that fails RPCs whose context
+// is done, like a real connection would.
+type contextTestModalClient struct {
+	pb.ModalClientClient
+	release chan struct{} // closed to let streams send their items
+}
+
+func (f *contextTestModalClient) QueueGetOrCreate(ctx context.Context, in *pb.QueueGetOrCreateRequest, opts ...grpc.CallOption) (*pb.QueueGetOrCreateResponse, error) {
+	return pb.QueueGetOrCreateResponse_builder{QueueId: "qu-123"}.Build(), nil
+}
+
+func (f *contextTestModalClient) QueueLen(ctx context.Context, in *pb.QueueLenRequest, opts ...grpc.CallOption) (*pb.QueueLenResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	if md, _ := metadata.FromOutgoingContext(ctx); len(md.Get("x-modal-client-type")) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "missing client headers")
+	}
+	return pb.QueueLenResponse_builder{Len: 3}.Build(), nil
+}
+
+func (f *contextTestModalClient) FunctionGet(ctx context.Context, in *pb.FunctionGetRequest, opts ...grpc.CallOption) (*pb.FunctionGetResponse, error) {
+	return pb.FunctionGetResponse_builder{FunctionId: "fu-123"}.Build(), nil
+}
+
+func (f *contextTestModalClient) FunctionGetCurrentStats(ctx context.Context, in *pb.FunctionGetCurrentStatsRequest, opts ...grpc.CallOption) (*pb.FunctionStats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	return pb.FunctionStats_builder{Backlog: 2}.Build(), nil
+}
+
+func (f *contextTestModalClient) FunctionCallCancel(ctx context.Context, in *pb.FunctionCallCancelRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (f *contextTestModalClient) SandboxWait(ctx context.Context, in *pb.SandboxWaitRequest, opts ...grpc.CallOption) (*pb.SandboxWaitResponse, error) {
+	return &pb.SandboxWaitResponse{}, nil
+}
+
+func (f *contextTestModalClient) SandboxGetTaskId(ctx context.Context, in *pb.SandboxGetTaskIdRequest, opts ...grpc.CallOption) (*pb.SandboxGetTaskIdResponse, error) {
+	return pb.SandboxGetTaskIdResponse_builder{TaskId: proto.String("ta-123")}.Build(), nil
+}
+
+func (f *contextTestModalClient) ContainerExec(ctx context.Context, in *pb.ContainerExecRequest, opts ...grpc.CallOption) (*pb.ContainerExecResponse, error) {
+	return pb.ContainerExecResponse_builder{ExecId: "ex-123"}.Build(), nil
+}
+
+func (f *contextTestModalClient) SandboxGetLogs(ctx context.Context, in *pb.SandboxGetLogsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[pb.TaskLogsBatch], error) {
+	batch := pb.TaskLogsBatch_builder{Items: []*pb.TaskLogs{pb.TaskLogs_builder{Data: "logs"}.Build()}, Eof: true}.Build()
+	return &releasedStream[pb.TaskLogsBatch]{ctx: ctx, release: f.release, items: []*pb.TaskLogsBatch{batch}}, nil
+}
+
+func (f *contextTestModalClient) ContainerExecGetOutput(ctx context.Context, in *pb.ContainerExecGetOutputRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[pb.RuntimeOutputBatch], error) {
+	batch := pb.RuntimeOutputBatch_builder{
+		Items:    []*pb.RuntimeOutputMessage{pb.RuntimeOutputMessage_builder{MessageBytes: []byte("output")}.Build()},
+		ExitCode: proto.Int32(0),
+	}.Build()
+	return &releasedStream[pb.RuntimeOutputBatch]{ctx: ctx, release: f.release, items: []*pb.RuntimeOutputBatch{batch}}, nil
+}
+
+// releasedStream is a server stream that sends items once release is closed,
+// unless its context is done by then.
+type releasedStream[T any] struct {
+	grpc.ClientStream
+	ctx     context.Context
+	release <-chan struct{}
+	items   []*T
+}
+
+func (s *releasedStream[T]) Recv() (*T, error) {
+	<-s.release
+	if err := s.ctx.Err(); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	if len(s.items) == 0 {
+		return nil, io.EOF
+	}
+	item := s.items[0]
+	s.items = s.items[1:]
+	return item, nil
+}
+
+func TestSandboxStreamsOutliveCallContext(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	release := make(chan struct{})
+	c := newTestClient(g, &contextTestModalClient{release: release}, ClientOptions{})
+
+	// The streams of Sandboxes and processes aren't bound to the context of the
+	// call that created them.
+	lookupCtx, cancel := context.WithCancel(context.Background())
+	sb, err := c.SandboxFromId(lookupCtx, "sb-123")
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	execCtx, execCancel := context.WithCancel(context.Background())
+	cp, err := sb.ExecContext(execCtx, []string{"echo"}, ExecOptions{})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	cancel()
+	execCancel()
+	close(release)
+
+	stdout, err := io.ReadAll(sb.Stdout)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(string(stdout)).To(gomega.Equal("logs"))
+	stdout, err = io.ReadAll(cp.Stdout)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(string(stdout)).To(gomega.Equal("output"))
+}
+
+func TestFunctionMethodsOutliveLookupContext(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	c := newTestClient(g, &contextTestModalClient{}, ClientOptions{})
+
+	// Functions and FunctionCalls don't keep the context they were looked up with.
+	lookupCtx, cancel := context.WithCancel(context.Background())
+	f, err := c.FunctionLookup(lookupCtx, "my-app", "my-function", nil)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	fc, err := c.FunctionCallFromId(lookupCtx, "fc-123")
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	cancel()
+
+	stats, err := f.GetCurrentStats()
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(stats.Backlog).To(gomega.Equal(2))
+	g.Expect(fc.Cancel(nil)).To(gomega.Succeed())
+}
+
+func TestContextMethodsUseCallerContext(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	c := newTestClient(g, &contextTestModalClient{}, ClientOptions{})
+
+	lookupCtx, cancel := context.WithCancel(context.Background())
+	q, err := c.QueueLookup(lookupCtx, "my-queue", nil)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	cancel()
+
+	// Methods without a context don't use the lookup context...
+	n, err := q.Len(nil)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(n).To(gomega.Equal(3))
+
+	// ...and the context variants only depend on the context they are given.
+	n, err = q.LenContext(context.Background(), nil)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(n).To(gomega.Equal(3))
+
+	reqCtx, reqCancel := context.WithCancel(context.Background())
+	reqCancel()
+	_, err = q.LenContext(reqCtx, nil)
+	g.Expect(status.Code(err)).To(gomega.Equal(codes.Canceled))
+}
@@ -8,23 +8,27 @@ import (
 )
 
 // SetClientFactoryForTesting overrides the gRPC client factory for tests.
-// It resets global client state and returns a restore function to undo changes.
+// It replaces the default client and returns a restore function to undo changes.
 func SetClientFactoryForTesting(testClientFactory func(Profile) (grpc.ClientConnInterface, pb.ModalClientClient, error)) (restore func()) {
+	defaultClientMu.Lock()
+	defer defaultClientMu.Unlock()
+
 	origClientFactory := clientFactory
-	clientFactory = testClientFactory
+	origDefaultClient := defaultClient
+	clientFactory = func(profile Profile, _ *Client) (grpc.ClientConnInterface, pb.ModalClientClient, error) {
+		return testClientFactory(profile)
+	}
 
-	// Recreate client using the overridden clientFactory, and reset the rest
-	_, client, _ = clientFactory(clientProfile)
-	inputPlaneClients = make(map[string]pb.ModalClientClient)
-	authToken = ""
+	// Recreate the default client on next use with the overridden clientFactory.
+	defaultClient = nil
 
 	var once sync.Once
 	return func() {
 		once.Do(func() {
+			defaultClientMu.Lock()
+			defer defaultClientMu.Unlock()
 			clientFactory = origClientFactory
-			_, client, _ = clientFactory(clientProfile)
-			inputPlaneClients = map[string]pb.ModalClientClient{}
-			authToken = ""
+			defaultClient = origDefaultClient
 		})
 	}
 }
@@ -16,40 +16,49 @@ import (
 // Cls represents a Modal class definition that can be instantiated with parameters.
 // It contains metadata about the class and its methods.
 type Cls struct {
-	ctx               context.Context
+	client            *Client
 	serviceFunctionId string
 	schema            []*pb.ClassParameterSpec
 	methodNames       []string
 	inputPlaneUrl     string // if empty, use control plane
 }
 
-// ClsLookup looks up an existing Cls on a deployed App.
+// ClsLookup looks up an existing Cls on a deployed App, using the default client.
 func ClsLookup(ctx context.Context, appName string, name string, options *LookupOptions) (*Cls, error) {
+	c, err := getDefaultClient()
+	if err != nil {
+		return nil, err
+	}
+	return c.ClsLookup(ctx, appName, name, options)
+}
+
+// ClsLookup looks up an existing Cls on a deployed App.
+func (c *Client) ClsLookup(ctx context.Context, appName string, name string, options *LookupOptions) (*Cls, error) {
 	if options == nil {
 		options = &LookupOptions{}
 	}
 	var err error
-	ctx, err = clientContext(ctx)
+	ctx, err = c.clientContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	cls := Cls{
 		methodNames: []string{},
-		ctx:         ctx,
+		client:      c,
 	}
 
 	// Find class service function metadata. Service functions are used to implement class methods,
 	// which are invoked using a combination of service function ID and the method name.
 	serviceFunctionName := fmt.Sprintf("%s.*", name)
-	serviceFunction, err := client.FunctionGet(ctx, pb.FunctionGetRequest_builder{
+	serviceFunction, err := c.cpClient.FunctionGet(ctx, pb.FunctionGetRequest_builder{
 		AppName:         appName,
 		ObjectTag:       serviceFunctionName,
-		EnvironmentName: environmentName(options.Environment),
+		EnvironmentName: c.environmentName(options.Environment),
 	}.Build())
 
-	if status, ok := status.FromError(err); ok && status.Code() == codes.NotFound {
-		return nil, NotFoundError{fmt.Sprintf("class '%s/%s' not found", appName, name)}
+	if status.Code(err) == codes.NotFound {
+		return nil, withNotFoundMessage(err, fmt.Sprintf("class '%s/%s' not found", appName, name))
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to look up class service function: %w", err)
@@ -84,7 +93,15 @@ func ClsLookup(ctx context.Context, appName string, name string, options *Lookup
 }
 
 // Instance creates a new instance of the class with the provided parameters.
+// It is InstanceContext with context.Background().
 func (c *Cls) Instance(params map[string]any) (*ClsInstance, error) {
+	return c.InstanceContext(context.Background(), params)
+}
+
+// InstanceContext creates a new instance of the class with the provided parameters.
+// Only binding the parameters is bound to ctx, and the methods of the instance can
+// outlive it.
+func (c *Cls) InstanceContext(ctx context.Context, params map[string]any) (*ClsInstance, error) {
 	var functionId string
 	if len(c.schema) == 0 {
 		// Class isn't parametrized, return a simple instance.
@@ -92,7 +109,7 @@ func (c *Cls) Instance(params map[string]any) (*ClsInstance, error) {
 	} else {
 		// Class has parameters, bind the parameters to service function
 		// and update method references.
-		boundFunctionId, err := c.bindParameters(params)
+		boundFunctionId, err := c.bindParameters(ctx, params)
 		if err != nil {
 			return nil, err
 		}
@@ -105,21 +122,25 @@ func (c *Cls) Instance(params map[string]any) (*ClsInstance, error) {
 			FunctionId:    functionId,
 			MethodName:    &name,
 			inputPlaneUrl: c.inputPlaneUrl,
-			ctx:           c.ctx,
+			client:        c.client,
 		}
 	}
 	return &ClsInstance{methods: methods}, nil
 }
 
 // bindParameters processes the parameters and binds them to the class function.
-func (c *Cls) bindParameters(params map[string]any) (string, error) {
+func (c *Cls) bindParameters(ctx context.Context, params map[string]any) (string, error) {
 	serializedParams, err := encodeParameterSet(c.schema, params)
 	if err != nil {
 		return "", fmt.Errorf("failed to serialize parameters: %w", err)
 	}
+	ctx, err = c.client.clientContext(ctx)
+	if err != nil {
+		return "", err
+	}
 
 	// Bind parameters to create a parameterized function
-	bindResp, err := client.FunctionBindParams(c.ctx, pb.FunctionBindParamsRequest_builder{
+	bindResp, err := c.client.cpClient.FunctionBindParams(ctx, pb.FunctionBindParamsRequest_builder{
 		FunctionId:       c.serviceFunctionId,
 		SerializedParams: serializedParams,
 	}.Build())
@@ -223,7 +244,7 @@ type ClsInstance struct {
 func (c *ClsInstance) Method(name string) (*Function, error) {
 	method, ok := c.methods[name]
 	if !ok {
-		return nil, NotFoundError{fmt.Sprintf("method '%s' not found on class", name)}
+		return nil, NotFoundError{Exception: fmt.Sprintf("method '%s' not found on class", name)}
 	}
 	return method, nil
 }
@@ -0,0 +1,212 @@
+package modal
+
+// Codecs for the payloads exchanged with Python: the arguments and results of
+// Function calls, and Queue items.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	pickle "github.com/kisielk/og-rek"
+	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+)
+
+// Codec encodes and decodes the payloads of Function calls and Queue items.
+// The codec of a Function or Queue is set with WithCodec, and defaults to
+// ClientOptions.Codec, or PickleCodec if that isn't set.
+//
+// Function inputs can only be encoded by codecs with DataFormatPickle for now,
+// since Modal has no data format for other inputs and would unpickle them.
+// Function calls with other codecs fail with an InvalidError. Function results
+// are decoded according to their DataFormat: with the codec if its DataFormat
+// matches, and otherwise with the built-in codec for the format. Decoded values
+// can be decoded further into Go types with Decode.
+//
+// Codecs name the fields of Go structs in their own way: PickleCodec and Decode
+// by their `modal` tags, and JSONCodec by their `json` tags. A struct that is
+// passed with several codecs needs both tags to keep the same field names.
+type Codec interface {
+	// DataFormat returns the format of the payloads of the codec.
+	DataFormat() DataFormat
+	// Encode returns the payload of a value. The arguments of a Function call
+	// are encoded as pickle.Tuple{args, kwargs}.
+	Encode(v any) ([]byte, error)
+	// Decode returns the value of a payload.
+	Decode(data []byte) (any, error)
+}
+
+// DataFormat is the format of a payload, which tells the other side how to
+// decode it.
+type DataFormat int32
+
+const (
+	// DataFormatRaw is the format of payloads that Modal doesn't know how to
+	// decode, such as JSON. The Python code must decode them itself. It can't be
+	// used for Function inputs yet.
+	DataFormatRaw = DataFormat(pb.DataFormat_DATA_FORMAT_UNSPECIFIED)
+	// DataFormatPickle is the format of Python pickles.
+	DataFormatPickle = DataFormat(pb.DataFormat_DATA_FORMAT_PICKLE)
+)
+
+// PickleCodec encodes payloads as Python pickles, the format that Python
+// Functions use by default. See "Python values" in the package documentation.
+type PickleCodec struct{}
+
+func (PickleCodec) DataFormat() DataFormat { return DataFormatPickle }
+
+func (PickleCodec) Encode(v any) ([]byte, error) {
+	b, err := pickleSerialize(v)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (PickleCodec) Decode(data []byte) (any, error) {
+	return pickleDeserialize(data)
+}
+
+// JSONCodec encodes payloads as JSON, with encoding/json. The arguments of a
+// Function call are encoded as the array [args, kwargs], and []byte and
+// json.RawMessage values as their contents, which must be valid JSON. Structs
+// are encoded by encoding/json too, so their fields are named by their `json`
+// tags, not their `modal` tags, e.g. `json:"user_id" modal:"user_id"`.
+//
+// JSON is decoded into the same values as pickles: objects into map[any]any,
+// arrays into []any, numbers into int64, *big.Int or float64, and null into
+// pickle.None.
+type JSONCodec struct{}
+
+func (JSONCodec) DataFormat() DataFormat { return DataFormatRaw }
+
+func (JSONCodec) Encode(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return validJSON(v)
+	case json.RawMessage:
+		return validJSON(v)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding JSON: %w", err)
+	}
+	return b, nil
+}
+
+func (JSONCodec) Decode(data []byte) (any, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, fmt.Errorf("error decoding JSON: %w", err)
+	}
+	if d.More() {
+		return nil, fmt.Errorf("error decoding JSON: unexpected data after the value")
+	}
+	return jsonToGo(v)
+}
+
+// validJSON returns data if it is valid JSON.
+func validJSON(data []byte) ([]byte, error) {
+	if !json.Valid(data) {
+		return nil, InvalidError{Exception: "JSONCodec payload is not valid JSON"}
+	}
+	return data, nil
+}
+
+// jsonToGo converts a value decoded by encoding/json with UseNumber to the
+// types of values decoded from pickles.
+func jsonToGo(v any) (any, error) {
+	switch v := v.(type) {
+	case nil:
+		return pickle.None{}, nil
+	case json.Number:
+		s := string(v)
+		if !strings.ContainsAny(s, ".eE") {
+			if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return n, nil
+			}
+			if n, ok := new(big.Int).SetString(s, 10); ok {
+				return n, nil
+			}
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding JSON: %w", err)
+		}
+		return f, nil
+	case []any:
+		for i, item := range v {
+			item, err := jsonToGo(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = item
+		}
+		return v, nil
+	case map[string]any:
+		m := make(map[any]any, len(v))
+		for k, item := range v {
+			item, err := jsonToGo(item)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = item
+		}
+		return m, nil
+	}
+	return v, nil
+}
+
+// RawCodec sends payloads as they are. Values must be []byte or string, and
+// Function calls must have a single such argument and no keyword arguments.
+// Payloads are decoded as []byte.
+type RawCodec struct{}
+
+func (RawCodec) DataFormat() DataFormat { return DataFormatRaw }
+
+func (RawCodec) Encode(v any) ([]byte, error) {
+	if call, ok := v.(pickle.Tuple); ok && len(call) == 2 {
+		args, _ := call[0].([]any)
+		kwargs, _ := call[1].(map[string]any)
+		if len(args) != 1 || len(kwargs) != 0 {
+			return nil, InvalidError{Exception: "RawCodec requires Function calls to have a single argument and no keyword arguments"}
+		}
+		v = args[0]
+	}
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, InvalidError{Exception: fmt.Sprintf("RawCodec can't encode %T, only []byte and string", v)}
+}
+
+func (RawCodec) Decode(data []byte) (any, error) {
+	return data, nil
+}
+
+// decodePayload decodes a payload of a Function result, with codec if it
+// encodes dataFormat and with the built-in codec of the format otherwise.
+func decodePayload(codec Codec, data []byte, dataFormat pb.DataFormat) (any, error) {
+	if codec != nil && pb.DataFormat(codec.DataFormat()) == dataFormat {
+		return codec.Decode(data)
+	}
+	return deserializeDataFormat(data, dataFormat)
+}
+
+// codecOrDefault returns codec, or the default codec of the client if it is nil.
+func codecOrDefault(codec Codec, client *Client) Codec {
+	switch {
+	case codec != nil:
+		return codec
+	case client != nil && client.codec != nil:
+		return client.codec
+	}
+	return PickleCodec{}
+}
@@ -0,0 +1,100 @@
+package modal
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	pickle "github.com/kisielk/og-rek"
+	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+	"github.com/onsi/gomega"
+)
+
+func TestJSONCodec(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	codec := JSONCodec{}
+	b, err := codec.Encode(pickle.Tuple{[]any{"a", 1}, map[string]any{"k": true}})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(string(b)).To(gomega.Equal(`[["a",1],{"k":true}]`))
+	b, err = codec.Encode(json.RawMessage(`{"raw": 1}`))
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(string(b)).To(gomega.Equal(`{"raw": 1}`))
+	_, err = codec.Encode([]byte("{"))
+	g.Expect(err).To(gomega.MatchError(ErrInvalid))
+
+	// Structs follow their `json` tags, not their `modal` tags.
+	b, err = codec.Encode(struct {
+		UserId int64  `json:"id" modal:"user_id"`
+		Name   string `modal:"name"`
+	}{UserId: 42, Name: "Ada"})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(string(b)).To(gomega.Equal(`{"id":42,"Name":"Ada"}`))
+
+	v, err := codec.Decode([]byte(`{"user_id": 42, "name": "Ada", "scores": [1.5, 2], "big": 123456789012345678901234567890, "address": null}`))
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	big1, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	g.Expect(v).To(gomega.Equal(map[any]any{
+		"user_id": int64(42),
+		"name":    "Ada",
+		"scores":  []any{1.5, int64(2)},
+		"big":     big1,
+		"address": pickle.None{},
+	}))
+
+	// Decoded JSON decodes into Go types like pickles do.
+	var user testUser
+	g.Expect(Decode(v, &user)).To(gomega.Succeed())
+	g.Expect(user).To(gomega.Equal(testUser{UserId: 42, Name: "Ada", Scores: []float64{1.5, 2}, Big: big1}))
+
+	_, err = codec.Decode([]byte(`{} {}`))
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestRawCodec(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	codec := RawCodec{}
+	b, err := codec.Encode(pickle.Tuple{[]any{[]byte{1, 2}}, map[string]any{}})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(b).To(gomega.Equal([]byte{1, 2}))
+	b, err = codec.Encode("text")
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(b).To(gomega.Equal([]byte("text")))
+	_, err = codec.Encode(pickle.Tuple{[]any{"a", "b"}, map[string]any{}})
+	g.Expect(err).To(gomega.MatchError(ErrInvalid))
+	_, err = codec.Encode(1)
+	g.Expect(err).To(gomega.MatchError(ErrInvalid))
+
+	v, err := codec.Decode([]byte("out"))
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(v).To(gomega.Equal([]byte("out")))
+}
+
+func TestDecodePayload(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	pickled, err := PickleCodec{}.Encode("pickled")
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+	// Payloads in the format of the codec are decoded by the codec, and others
+	// by the built-in codec of their format.
+	v, err := decodePayload(JSONCodec{}, []byte(`"json"`), pb.DataFormat_DATA_FORMAT_UNSPECIFIED)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(v).To(gomega.Equal("json"))
+	v, err = decodePayload(JSONCodec{}, pickled, pb.DataFormat_DATA_FORMAT_PICKLE)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(v).To(gomega.Equal("pickled"))
+	v, err = decodePayload(RawCodec{}, pickled, pb.DataFormat_DATA_FORMAT_UNSPECIFIED)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(v).To(gomega.Equal(pickled))
+	_, err = decodePayload(PickleCodec{}, []byte(`"json"`), pb.DataFormat_DATA_FORMAT_UNSPECIFIED)
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("unsupported data format")))
+
+	g.Expect(codecOrDefault(nil, nil)).To(gomega.Equal(PickleCodec{}))
+	g.Expect(codecOrDefault(nil, &Client{codec: RawCodec{}})).To(gomega.Equal(RawCodec{}))
+	g.Expect(codecOrDefault(JSONCodec{}, &Client{codec: RawCodec{}})).To(gomega.Equal(JSONCodec{}))
+}
@@ -1,13 +1,15 @@
 package modal
 
-// config.go houses the logic for loading and resolving Modal profiles
-// from ~/.modal.toml or environment variables.
+// config.go houses the logic for loading, resolving and editing Modal profiles
+// from ~/.modal.toml (or MODAL_CONFIG_PATH) or environment variables.
 
 import (
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/pelletier/go-toml/v2"
 )
@@ -21,6 +23,34 @@ type Profile struct {
 	ImageBuilderVersion string // optional
 }
 
+// ProfileValueSource describes where a resolved profile value came from.
+type ProfileValueSource string
+
+const (
+	ProfileValueUnset   ProfileValueSource = ""        // No value was found.
+	ProfileValueDefault ProfileValueSource = "default" // Built-in default value.
+	ProfileValueEnv     ProfileValueSource = "env"     // Environment variable, e.g. MODAL_TOKEN_ID.
+	ProfileValueFile    ProfileValueSource = "file"    // Profile in the config file.
+	ProfileValueOption  ProfileValueSource = "option"  // ClientOptions passed to NewClient or InitializeClient.
+)
+
+// ProfileSources records where each field of a resolved Profile came from.
+type ProfileSources struct {
+	ServerURL           ProfileValueSource
+	TokenId             ProfileValueSource
+	TokenSecret         ProfileValueSource
+	Environment         ProfileValueSource
+	ImageBuilderVersion ProfileValueSource
+}
+
+// ResolvedProfile is a Profile together with information about how it was resolved.
+type ResolvedProfile struct {
+	Profile
+	Name       string // name of the profile in the config file, empty if none was selected
+	ConfigPath string // path of the config file that was consulted
+	Sources    ProfileSources
+}
+
 // rawProfile mirrors the TOML structure on disk.
 type rawProfile struct {
 	ServerURL           string `toml:"server_url"`
@@ -33,14 +63,22 @@ type rawProfile struct {
 
 type config map[string]rawProfile
 
-// readConfigFile loads ~/.modal.toml, returning an empty config if the file
-// does not exist.
-func readConfigFile() (config, error) {
+// configFilePath returns the location of the Modal config file, which is
+// MODAL_CONFIG_PATH if set, or ~/.modal.toml.
+func configFilePath() (string, error) {
+	if path := os.Getenv("MODAL_CONFIG_PATH"); path != "" {
+		return path, nil
+	}
 	home, err := os.UserHomeDir()
 	if err != nil {
-		return nil, fmt.Errorf("cannot locate homedir: %w", err)
+		return "", fmt.Errorf("cannot locate homedir: %w", err)
 	}
-	path := filepath.Join(home, ".modal.toml")
+	return filepath.Join(home, ".modal.toml"), nil
+}
+
+// readConfigFile loads the config file at path, returning an empty config if
+// the file does not exist.
+func readConfigFile(path string) (config, error) {
 	content, err := os.ReadFile(path)
 	if errors.Is(err, os.ErrNotExist) {
 		return config{}, nil // silent absence is fine
@@ -55,41 +93,277 @@ func readConfigFile() (config, error) {
 	return cfg, nil
 }
 
-// getProfile resolves a profile by name. Pass an empty string to instead return
-// the first profile in the configuration file with `active = true`.
+// updateConfigFile applies update to the raw contents of the config file at path
+// and writes the result back, keeping keys that this package does not know about.
+// The file is created if it does not exist, and replaced atomically.
+func updateConfigFile(path string, update func(doc map[string]any) error) error {
+	doc := map[string]any{}
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	if err == nil {
+		if err := toml.Unmarshal(content, &doc); err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+
+	if err := update(doc); err != nil {
+		return err
+	}
+
+	content, err = toml.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", path, err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	defer os.Remove(f.Name()) // no-op after a successful rename
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
+
+// profileTable returns the table of the named profile in a raw config document,
+// adding it if missing.
+func profileTable(doc map[string]any, name string) (map[string]any, error) {
+	switch t := doc[name].(type) {
+	case nil:
+		table := map[string]any{}
+		doc[name] = table
+		return table, nil
+	case map[string]any:
+		return t, nil
+	default:
+		return nil, fmt.Errorf("config key '%s' is not a profile table", name)
+	}
+}
+
+// activateProfile marks the named profile as active in a raw config document,
+// and all other profiles as inactive.
+func activateProfile(doc map[string]any, name string) {
+	for n, v := range doc {
+		if table, ok := v.(map[string]any); ok {
+			if n == name {
+				table["active"] = true
+			} else {
+				delete(table, "active")
+			}
+		}
+	}
+}
+
+// ResolveProfile resolves the profile that NewClient would use for the given
+// options, without creating a client. Values are taken from the options first,
+// then from environment variables, and then from the selected profile in the
+// config file. The returned ResolvedProfile records the source of each value.
 //
-// Returned Profile is ready for use; error describes what is missing.
-func getProfile(name string) Profile {
+// An error is returned if the config file cannot be read or parsed, or if
+// options.Profile names a profile that does not exist.
+func ResolveProfile(options ClientOptions) (ResolvedProfile, error) {
+	path, err := configFilePath()
+	if err != nil {
+		return ResolvedProfile{}, err
+	}
+	cfg, err := readConfigFile(path)
+	if err != nil {
+		return ResolvedProfile{}, err
+	}
+
+	name := firstNonEmpty(options.Profile, os.Getenv("MODAL_PROFILE"))
 	if name == "" {
-		for n, p := range defaultConfig {
-			if p.Active {
-				name = n
-				break
-			}
+		name, err = cfg.activeProfile(path)
+		if err != nil {
+			return ResolvedProfile{}, err
 		}
 	}
 
 	var raw rawProfile
 	if name != "" {
-		raw = defaultConfig[name]
+		var ok bool
+		raw, ok = cfg[name]
+		if !ok && options.Profile != "" {
+			return ResolvedProfile{}, fmt.Errorf("profile '%s' not found in %s", name, path)
+		}
 	}
 
-	// Env-vars override file values.
-	serverURL := firstNonEmpty(os.Getenv("MODAL_SERVER_URL"), raw.ServerURL, "https://api.modal.com:443")
-	tokenId := firstNonEmpty(os.Getenv("MODAL_TOKEN_ID"), raw.TokenId)
-	tokenSecret := firstNonEmpty(os.Getenv("MODAL_TOKEN_SECRET"), raw.TokenSecret)
-	environment := firstNonEmpty(os.Getenv("MODAL_ENVIRONMENT"), raw.Environment)
-	imageBuilderVersion := firstNonEmpty(os.Getenv("MODAL_IMAGE_BUILDER_VERSION"), raw.ImageBuilderVersion)
+	r := ResolvedProfile{Name: name, ConfigPath: path}
+	r.ServerURL, r.Sources.ServerURL = resolveValue("", os.Getenv("MODAL_SERVER_URL"), raw.ServerURL, "https://api.modal.com:443")
+	r.TokenId, r.Sources.TokenId = resolveValue(options.TokenId, os.Getenv("MODAL_TOKEN_ID"), raw.TokenId, "")
+	r.TokenSecret, r.Sources.TokenSecret = resolveValue(options.TokenSecret, os.Getenv("MODAL_TOKEN_SECRET"), raw.TokenSecret, "")
+	r.Environment, r.Sources.Environment = resolveValue(options.Environment, os.Getenv("MODAL_ENVIRONMENT"), raw.Environment, "")
+	r.ImageBuilderVersion, r.Sources.ImageBuilderVersion = resolveValue("", os.Getenv("MODAL_IMAGE_BUILDER_VERSION"), raw.ImageBuilderVersion, "")
+	return r, nil
+}
 
-	return Profile{
-		ServerURL:           serverURL,
-		TokenId:             tokenId,
-		TokenSecret:         tokenSecret,
-		Environment:         environment,
-		ImageBuilderVersion: imageBuilderVersion,
+// activeProfile returns the name of the active profile, or "" if there is none.
+// It is an error for several profiles to be active.
+func (cfg config) activeProfile(path string) (string, error) {
+	var active []string
+	for name, p := range cfg {
+		if p.Active {
+			active = append(active, name)
+		}
+	}
+	switch len(active) {
+	case 0:
+		return "", nil
+	case 1:
+		return active[0], nil
+	default:
+		slices.Sort(active)
+		return "", fmt.Errorf("multiple profiles are active in %s: %s; set active = true on only one of them, or select one with MODAL_PROFILE",
+			path, strings.Join(active, ", "))
 	}
 }
 
+// ConfigProfile is a profile as stored in the config file.
+type ConfigProfile struct {
+	Profile
+	Name   string
+	Active bool
+}
+
+// ListProfiles returns the profiles in the config file, sorted by name.
+func ListProfiles() ([]ConfigProfile, error) {
+	path, err := configFilePath()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	profiles := make([]ConfigProfile, 0, len(cfg))
+	for name, raw := range cfg {
+		profiles = append(profiles, raw.configProfile(name))
+	}
+	slices.SortFunc(profiles, func(a, b ConfigProfile) int { return strings.Compare(a.Name, b.Name) })
+	return profiles, nil
+}
+
+// GetProfile returns the named profile from the config file, or a NotFoundError
+// if it does not exist. Unlike ResolveProfile, environment variables are ignored.
+func GetProfile(name string) (ConfigProfile, error) {
+	path, err := configFilePath()
+	if err != nil {
+		return ConfigProfile{}, err
+	}
+	cfg, err := readConfigFile(path)
+	if err != nil {
+		return ConfigProfile{}, err
+	}
+	raw, ok := cfg[name]
+	if !ok {
+		return ConfigProfile{}, NotFoundError{Exception: fmt.Sprintf("profile '%s' not found in %s", name, path)}
+	}
+	return raw.configProfile(name), nil
+}
+
+// SaveProfile creates or updates the named profile in the config file, creating
+// the file if needed. Empty fields of profile are removed from the stored profile.
+// Other settings in the file, including keys unknown to this package and the
+// active flag, are kept.
+func SaveProfile(name string, profile Profile) error {
+	if name == "" {
+		return InvalidError{Exception: "profile name must be non-empty"}
+	}
+	path, err := configFilePath()
+	if err != nil {
+		return err
+	}
+	return updateConfigFile(path, func(doc map[string]any) error {
+		table, err := profileTable(doc, name)
+		if err != nil {
+			return err
+		}
+		for key, value := range map[string]string{
+			"server_url":            profile.ServerURL,
+			"token_id":              profile.TokenId,
+			"token_secret":          profile.TokenSecret,
+			"environment":           profile.Environment,
+			"image_builder_version": profile.ImageBuilderVersion,
+		} {
+			if value == "" {
+				delete(table, key)
+			} else {
+				table[key] = value
+			}
+		}
+		return nil
+	})
+}
+
+// SetActiveProfile makes the named profile the active one in the config file,
+// which is used when neither ClientOptions.Profile nor MODAL_PROFILE is set.
+func SetActiveProfile(name string) error {
+	path, err := configFilePath()
+	if err != nil {
+		return err
+	}
+	return updateConfigFile(path, func(doc map[string]any) error {
+		if _, ok := doc[name].(map[string]any); !ok {
+			return NotFoundError{Exception: fmt.Sprintf("profile '%s' not found in %s", name, path)}
+		}
+		activateProfile(doc, name)
+		return nil
+	})
+}
+
+// DeleteProfile removes the named profile from the config file.
+func DeleteProfile(name string) error {
+	path, err := configFilePath()
+	if err != nil {
+		return err
+	}
+	return updateConfigFile(path, func(doc map[string]any) error {
+		if _, ok := doc[name].(map[string]any); !ok {
+			return NotFoundError{Exception: fmt.Sprintf("profile '%s' not found in %s", name, path)}
+		}
+		delete(doc, name)
+		return nil
+	})
+}
+
+func (raw rawProfile) configProfile(name string) ConfigProfile {
+	return ConfigProfile{
+		Profile: Profile{
+			ServerURL:           raw.ServerURL,
+			TokenId:             raw.TokenId,
+			TokenSecret:         raw.TokenSecret,
+			Environment:         raw.Environment,
+			ImageBuilderVersion: raw.ImageBuilderVersion,
+		},
+		Name:   name,
+		Active: raw.Active,
+	}
+}
+
+// resolveValue returns the first non-empty value in precedence order, with its source.
+func resolveValue(option, env, file, def string) (string, ProfileValueSource) {
+	switch {
+	case option != "":
+		return option, ProfileValueOption
+	case env != "":
+		return env, ProfileValueEnv
+	case file != "":
+		return file, ProfileValueFile
+	case def != "":
+		return def, ProfileValueDefault
+	}
+	return "", ProfileValueUnset
+}
+
 func firstNonEmpty(values ...string) string {
 	for _, v := range values {
 		if v != "" {
@@ -98,11 +372,3 @@ func firstNonEmpty(values ...string) string {
 	}
 	return ""
 }
-
-func environmentName(environment string) string {
-	return firstNonEmpty(environment, clientProfile.Environment)
-}
-
-func imageBuilderVersion(version string) string {
-	return firstNonEmpty(version, clientProfile.ImageBuilderVersion, "2024.10")
-}
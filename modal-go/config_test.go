@@ -0,0 +1,214 @@
+package modal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/onsi/gomega"
+)
+
+// setupConfigFile points the home directory at a temporary directory containing
+// the given ~/.modal.toml, and clears Modal environment variables.
+func setupConfigFile(t *testing.T, content string) string {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	for _, key := range []string{"MODAL_CONFIG_PATH", "MODAL_PROFILE", "MODAL_SERVER_URL", "MODAL_TOKEN_ID", "MODAL_TOKEN_SECRET", "MODAL_ENVIRONMENT", "MODAL_IMAGE_BUILDER_VERSION"} {
+		t.Setenv(key, "")
+	}
+	path := filepath.Join(home, ".modal.toml")
+	if content != "" {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestResolveProfileSources(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	path := setupConfigFile(t, `
+[default]
+token_id = "ak-file"
+token_secret = "as-file"
+environment = "file-env"
+
+[other]
+token_id = "ak-other"
+token_secret = "as-other"
+active = true
+`)
+	t.Setenv("MODAL_ENVIRONMENT", "env-env")
+
+	r, err := ResolveProfile(ClientOptions{TokenSecret: "as-option"})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(r.Name).To(gomega.Equal("other"))
+	g.Expect(r.ConfigPath).To(gomega.Equal(path))
+	g.Expect(r.Profile).To(gomega.Equal(Profile{
+		ServerURL:   "https://api.modal.com:443",
+		TokenId:     "ak-other",
+		TokenSecret: "as-option",
+		Environment: "env-env",
+	}))
+	g.Expect(r.Sources).To(gomega.Equal(ProfileSources{
+		ServerURL:           ProfileValueDefault,
+		TokenId:             ProfileValueFile,
+		TokenSecret:         ProfileValueOption,
+		Environment:         ProfileValueEnv,
+		ImageBuilderVersion: ProfileValueUnset,
+	}))
+
+	// An explicitly requested profile wins over the active one.
+	r, err = ResolveProfile(ClientOptions{Profile: "default"})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(r.Name).To(gomega.Equal("default"))
+	g.Expect(r.TokenId).To(gomega.Equal("ak-file"))
+
+	_, err = ResolveProfile(ClientOptions{Profile: "missing"})
+	g.Expect(err).Should(gomega.MatchError(gomega.ContainSubstring("profile 'missing' not found")))
+}
+
+func TestResolveProfileWithoutConfigFile(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	setupConfigFile(t, "")
+	t.Setenv("MODAL_TOKEN_ID", "ak-env")
+
+	r, err := ResolveProfile(ClientOptions{})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(r.Name).To(gomega.BeEmpty())
+	g.Expect(r.TokenId).To(gomega.Equal("ak-env"))
+	g.Expect(r.Sources.TokenId).To(gomega.Equal(ProfileValueEnv))
+	g.Expect(r.Sources.TokenSecret).To(gomega.Equal(ProfileValueUnset))
+}
+
+func TestMalformedConfigFile(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	setupConfigFile(t, "[default\ntoken_id = ")
+
+	_, err := ResolveProfile(ClientOptions{})
+	g.Expect(err).Should(gomega.MatchError(gomega.ContainSubstring("parsing")))
+
+	_, err = NewClient(ClientOptions{})
+	g.Expect(err).Should(gomega.MatchError(gomega.ContainSubstring("parsing")))
+
+	// The default client is created lazily, so the error surfaces from Init.
+	defaultClientMu.Lock()
+	origDefaultClient := defaultClient
+	defaultClient = nil
+	defaultClientMu.Unlock()
+	t.Cleanup(func() {
+		defaultClientMu.Lock()
+		defaultClient = origDefaultClient
+		defaultClientMu.Unlock()
+	})
+
+	err = Init()
+	g.Expect(err).Should(gomega.MatchError(gomega.ContainSubstring("parsing")))
+}
+
+func TestResolveProfileMultipleActive(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	setupConfigFile(t, `
+[b]
+token_id = "ak-b"
+active = true
+
+[a]
+token_id = "ak-a"
+active = true
+`)
+
+	_, err := ResolveProfile(ClientOptions{})
+	g.Expect(err).Should(gomega.MatchError(gomega.ContainSubstring("multiple profiles are active")))
+	g.Expect(err).Should(gomega.MatchError(gomega.ContainSubstring(": a, b;")))
+
+	// Selecting a profile explicitly avoids the ambiguity.
+	t.Setenv("MODAL_PROFILE", "b")
+	r, err := ResolveProfile(ClientOptions{})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(r.TokenId).To(gomega.Equal("ak-b"))
+}
+
+func TestConfigPathFromEnv(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	setupConfigFile(t, "")
+	path := filepath.Join(t.TempDir(), "custom.toml")
+	t.Setenv("MODAL_CONFIG_PATH", path)
+	g.Expect(os.WriteFile(path, []byte("[custom]\ntoken_id = \"ak-custom\"\nactive = true\n"), 0o600)).To(gomega.Succeed())
+
+	r, err := ResolveProfile(ClientOptions{})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(r.ConfigPath).To(gomega.Equal(path))
+	g.Expect(r.Name).To(gomega.Equal("custom"))
+	g.Expect(r.TokenId).To(gomega.Equal("ak-custom"))
+}
+
+func TestProfileManagement(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	path := setupConfigFile(t, `
+[default]
+token_id = "ak-default"
+token_secret = "as-default"
+active = true
+custom_setting = "keep me"
+`)
+
+	g.Expect(SaveProfile("staging", Profile{
+		ServerURL:   "https://staging.modal.com:443",
+		TokenId:     "ak-staging",
+		TokenSecret: "as-staging",
+	})).To(gomega.Succeed())
+
+	profiles, err := ListProfiles()
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(profiles).To(gomega.Equal([]ConfigProfile{
+		{Name: "default", Active: true, Profile: Profile{TokenId: "ak-default", TokenSecret: "as-default"}},
+		{Name: "staging", Profile: Profile{ServerURL: "https://staging.modal.com:443", TokenId: "ak-staging", TokenSecret: "as-staging"}},
+	}))
+
+	g.Expect(SetActiveProfile("staging")).To(gomega.Succeed())
+	p, err := GetProfile("default")
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(p.Active).To(gomega.BeFalse())
+	r, err := ResolveProfile(ClientOptions{})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(r.Name).To(gomega.Equal("staging"))
+
+	// Saving keeps the active flag, and removes fields that are now empty.
+	g.Expect(SaveProfile("staging", Profile{TokenId: "ak-staging2", TokenSecret: "as-staging2"})).To(gomega.Succeed())
+	p, err = GetProfile("staging")
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(p).To(gomega.Equal(ConfigProfile{Name: "staging", Active: true, Profile: Profile{TokenId: "ak-staging2", TokenSecret: "as-staging2"}}))
+
+	g.Expect(DeleteProfile("staging")).To(gomega.Succeed())
+	_, err = GetProfile("staging")
+	g.Expect(err).Should(gomega.BeAssignableToTypeOf(NotFoundError{}))
+	g.Expect(DeleteProfile("staging")).Should(gomega.BeAssignableToTypeOf(NotFoundError{}))
+	g.Expect(SetActiveProfile("missing")).Should(gomega.BeAssignableToTypeOf(NotFoundError{}))
+
+	// Keys that this package doesn't know about are kept.
+	content, err := os.ReadFile(path)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(string(content)).To(gomega.ContainSubstring(`custom_setting = 'keep me'`))
+	info, err := os.Stat(path)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(info.Mode().Perm()).To(gomega.Equal(os.FileMode(0o600)))
+}
+
+func TestSaveProfileCreatesConfigFile(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	path := setupConfigFile(t, "")
+	g.Expect(SaveProfile("default", Profile{TokenId: "ak-123", TokenSecret: "as-123"})).To(gomega.Succeed())
+	g.Expect(SetActiveProfile("default")).To(gomega.Succeed())
+
+	cfg, err := readConfigFile(path)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(cfg).To(gomega.Equal(config{"default": {TokenId: "ak-123", TokenSecret: "as-123", Active: true}}))
+}
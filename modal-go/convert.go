@@ -0,0 +1,600 @@
+package modal
+
+// Conversion between Go values and the values of the pickle codec: decoding of
+// results into typed Go values, and encoding of Go structs as Python dicts.
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	pickle "github.com/kisielk/og-rek"
+)
+
+// Decode decodes a value returned by the SDK, e.g. by Function.Remote or
+// Queue.Get, into out, which must be a non-nil pointer.
+//
+// Python values are decoded into Go values of a compatible type: ints into any
+// integer type that holds them, or *big.Int, floats, ints and Decimals into
+// floats, str and Decimals into string, bytes and bytearrays into []byte, lists,
+// tuples and sets into slices and arrays, dicts into maps and structs,
+// datetimes into time.Time, and NumPy arrays into NDArray. None decodes into
+// the zero value of pointers, slices, maps and interfaces. Values also decode
+// into their own type, such as Date, and any interface type that they
+// implement, such as any.
+//
+// Dict keys are matched with struct fields by the name in the field's `modal`
+// tag, e.g. `modal:"user_id"`, or else by the field name, ignoring case. Fields
+// with the tag `modal:"-"` are skipped, and so are dict keys without a field. As
+// with encoding/json, the tag `modal:"-,"` names a field "-".
+func Decode(value any, out any) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return InvalidError{Exception: fmt.Sprintf("Decode requires a non-nil pointer, got %T", out)}
+	}
+	return decodeValue(value, rv.Elem(), "result")
+}
+
+// RemoteInto executes a single input on a remote Function, like RemoteContext,
+// and decodes the result into out as described by Decode.
+func (f *Function) RemoteInto(ctx context.Context, out any, args []any, kwargs map[string]any) error {
+	result, err := f.RemoteContext(ctx, args, kwargs)
+	if err != nil {
+		return err
+	}
+	return Decode(result, out)
+}
+
+// Call executes a single input on a remote Function with positional arguments,
+// and returns its result decoded into a T as described by Decode.
+func Call[T any](ctx context.Context, fn *Function, args ...any) (T, error) {
+	var out T
+	err := fn.RemoteInto(ctx, &out, args, nil)
+	return out, err
+}
+
+// QueueGet removes and returns the next object from a Queue, like
+// Queue.GetContext, decoded into a T as described by Decode.
+func QueueGet[T any](ctx context.Context, q *Queue, options *QueueGetOptions) (T, error) {
+	var out T
+	v, err := q.GetContext(ctx, options)
+	if err != nil {
+		return out, err
+	}
+	err = Decode(v, &out)
+	return out, err
+}
+
+// QueueIterate iterates through the items of a Queue, like Queue.IterateContext,
+// and decodes each of them into a T as described by Decode. An item that can't
+// be decoded is yielded with its DecodeError.
+func QueueIterate[T any](ctx context.Context, q *Queue, options *QueueIterateOptions) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for v, err := range q.IterateContext(ctx, options) {
+			var out T
+			if err == nil {
+				err = Decode(v, &out)
+			}
+			if !yield(out, err) {
+				return
+			}
+		}
+	}
+}
+
+var (
+	bigIntType     = reflect.TypeFor[big.Int]()
+	pickleNoneType = reflect.TypeFor[pickle.None]()
+)
+
+// pythonTypeName returns the name of the Python type of a decoded value.
+func pythonTypeName(v any) string {
+	switch v := v.(type) {
+	case nil, pickle.None:
+		return "NoneType"
+	case bool:
+		return "bool"
+	case int64, *big.Int, big.Int:
+		return "int"
+	case float64:
+		return "float"
+	case string, pickle.ByteString:
+		return "str"
+	case []byte, pickle.Bytes:
+		return "bytes"
+	case ByteArray:
+		return "bytearray"
+	case []any:
+		return "list"
+	case pickle.Tuple:
+		return "tuple"
+	case Set:
+		return "set"
+	case FrozenSet:
+		return "frozenset"
+	case time.Time, NaiveDateTime:
+		return "datetime"
+	case Date:
+		return "date"
+	case Decimal:
+		return "Decimal"
+	case map[any]any, pickle.Dict:
+		return "dict"
+	case pickle.Call:
+		if _, ok, _ := ndarrayFromPython(v); ok {
+			return "numpy.ndarray"
+		}
+		return pythonClassName(v.Callable)
+	case PythonObject:
+		if _, ok, _ := ndarrayFromPython(v); ok {
+			return "numpy.ndarray"
+		}
+		return pythonClassName(v.Class)
+	case pickle.Class:
+		return "type"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func decodeValue(v any, out reflect.Value, path string) error {
+	mismatch := func(reason string) error {
+		return DecodeError{Path: path, PythonType: pythonTypeName(v), GoType: out.Type(), Reason: reason}
+	}
+	if _, ok := v.(pickle.None); ok {
+		v = nil
+	}
+
+	switch out.Kind() {
+	case reflect.Interface:
+		if v == nil {
+			out.SetZero()
+			return nil
+		}
+		if !reflect.TypeOf(v).AssignableTo(out.Type()) {
+			return mismatch("")
+		}
+		out.Set(reflect.ValueOf(v))
+		return nil
+	case reflect.Pointer:
+		if v == nil {
+			out.SetZero()
+			return nil
+		}
+		if out.IsNil() {
+			out.Set(reflect.New(out.Type().Elem()))
+		}
+		return decodeValue(v, out.Elem(), path)
+	case reflect.Slice, reflect.Map:
+		if v == nil {
+			out.SetZero()
+			return nil
+		}
+	}
+	if v == nil {
+		return mismatch("")
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Type().AssignableTo(out.Type()) {
+		out.Set(rv)
+		return nil
+	}
+	switch out.Type() {
+	case timeType:
+		if v, ok := v.(NaiveDateTime); ok {
+			out.Set(reflect.ValueOf(v.Time))
+			return nil
+		}
+		return mismatch("")
+	case naiveDateTimeType, dateType:
+		return mismatch("")
+	case ndarrayType:
+		a, ok, err := ndarrayFromPython(v)
+		if !ok {
+			return mismatch("")
+		} else if err != nil {
+			return mismatch(err.Error())
+		}
+		out.Set(reflect.ValueOf(a))
+		return nil
+	}
+
+	if out.Type() == bigIntType {
+		switch v := v.(type) {
+		case int64:
+			out.Set(reflect.ValueOf(*big.NewInt(v)))
+		case *big.Int:
+			out.Set(reflect.ValueOf(*new(big.Int).Set(v)))
+		default:
+			return mismatch("")
+		}
+		return nil
+	}
+
+	switch out.Kind() {
+	case reflect.Bool:
+		b, ok := v.(bool)
+		if !ok {
+			return mismatch("")
+		}
+		out.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch v := v.(type) {
+		case int64:
+			if out.OverflowInt(v) {
+				return mismatch(fmt.Sprintf("value %d overflows %s", v, out.Type()))
+			}
+			out.SetInt(v)
+		case *big.Int:
+			return mismatch(fmt.Sprintf("value %s overflows %s", v, out.Type()))
+		default:
+			return mismatch("")
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		switch v := v.(type) {
+		case int64:
+			if v < 0 || out.OverflowUint(uint64(v)) {
+				return mismatch(fmt.Sprintf("value %d overflows %s", v, out.Type()))
+			}
+			out.SetUint(uint64(v))
+		case *big.Int:
+			if !v.IsUint64() || out.OverflowUint(v.Uint64()) {
+				return mismatch(fmt.Sprintf("value %s overflows %s", v, out.Type()))
+			}
+			out.SetUint(v.Uint64())
+		default:
+			return mismatch("")
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch v := v.(type) {
+		case float64:
+			f = v
+		case int64:
+			f = float64(v)
+		case Decimal:
+			var err error
+			if f, err = strconv.ParseFloat(string(v), 64); err != nil {
+				return mismatch("")
+			}
+		default:
+			return mismatch("")
+		}
+		if out.Kind() == reflect.Float32 && !math.IsInf(f, 0) && out.OverflowFloat(f) {
+			return mismatch(fmt.Sprintf("value %g overflows %s", f, out.Type()))
+		}
+		out.SetFloat(f)
+	case reflect.String:
+		switch v := v.(type) {
+		case string:
+			out.SetString(v)
+		case pickle.ByteString:
+			out.SetString(string(v))
+		case Decimal:
+			out.SetString(string(v))
+		default:
+			return mismatch("")
+		}
+	case reflect.Slice:
+		if out.Type().Elem().Kind() == reflect.Uint8 {
+			switch v := v.(type) {
+			case pickle.Bytes:
+				out.SetBytes([]byte(v))
+				return nil
+			case []byte:
+				out.SetBytes(append([]byte(nil), v...))
+				return nil
+			case ByteArray:
+				out.SetBytes(append([]byte(nil), v...))
+				return nil
+			}
+		}
+		items, ok := sequenceItems(v)
+		if !ok {
+			return mismatch("")
+		}
+		s := reflect.MakeSlice(out.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := decodeValue(item, s.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		out.Set(s)
+	case reflect.Array:
+		items, ok := sequenceItems(v)
+		if !ok {
+			return mismatch("")
+		}
+		if len(items) != out.Len() {
+			return mismatch(fmt.Sprintf("length %d doesn't match %d", len(items), out.Len()))
+		}
+		for i, item := range items {
+			if err := decodeValue(item, out.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		m, ok := v.(map[any]any)
+		if !ok {
+			return mismatch("")
+		}
+		result := reflect.MakeMapWithSize(out.Type(), len(m))
+		for k, item := range m {
+			key := reflect.New(out.Type().Key()).Elem()
+			if err := decodeValue(k, key, fmt.Sprintf("%s[%v]", path, k)); err != nil {
+				return err
+			}
+			elem := reflect.New(out.Type().Elem()).Elem()
+			if err := decodeValue(item, elem, fmt.Sprintf("%s[%v]", path, k)); err != nil {
+				return err
+			}
+			result.SetMapIndex(key, elem)
+		}
+		out.Set(result)
+	case reflect.Struct:
+		m, ok := v.(map[any]any)
+		if !ok {
+			return mismatch("")
+		}
+		fields := structFields(out.Type())
+		for k, item := range m {
+			name, ok := k.(string)
+			if !ok {
+				continue
+			}
+			i, ok := fields.lookup(name)
+			if !ok {
+				continue
+			}
+			if err := decodeValue(item, out.Field(i), path+"."+name); err != nil {
+				return err
+			}
+		}
+	default:
+		return mismatch("")
+	}
+	return nil
+}
+
+// sequenceItems returns the items of a Python list, tuple, set or frozenset.
+func sequenceItems(v any) ([]any, bool) {
+	switch v := v.(type) {
+	case []any:
+		return v, true
+	case pickle.Tuple:
+		return v, true
+	case Set:
+		return v, true
+	case FrozenSet:
+		return v, true
+	}
+	return nil, false
+}
+
+// structField is an exported field of a struct that is encoded as a dict key.
+type structField struct {
+	index     int
+	name      string // dict key
+	omitEmpty bool
+}
+
+type structFieldList []structField
+
+// structFields returns the fields of a struct type that are encoded as dict keys,
+// named by their `modal` tag, or else by the field name. The `pickle` tag of the
+// pickle codec is used if there's no `modal` tag.
+func structFields(t reflect.Type) structFieldList {
+	var fields structFieldList
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag, ok := f.Tag.Lookup("modal")
+		if !ok {
+			tag = f.Tag.Get("pickle")
+		}
+		if tag == "-" {
+			continue // but `modal:"-,"` names the field "-"
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, structField{index: i, name: name, omitEmpty: opts == "omitempty"})
+	}
+	return fields
+}
+
+// lookup returns the index of the field for a dict key, preferring an exact
+// match of its name.
+func (fields structFieldList) lookup(key string) (int, bool) {
+	for _, f := range fields {
+		if f.name == key {
+			return f.index, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, key) {
+			return f.index, true
+		}
+	}
+	return 0, false
+}
+
+// encodeValue converts Go structs in v to dicts keyed by their `modal` tags,
+// and Go values that stand for Python types to their pickle form, so that v
+// can be pickled. Values of og-rek's own types, such as pickle.Tuple and
+// big.Int, are left as they are.
+func encodeValue(v any) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	rv := reflect.ValueOf(v)
+	if !needsEncoding(rv.Type(), map[reflect.Type]bool{}) {
+		return v, nil
+	}
+	return encodeReflect(rv, map[visitKey]bool{})
+}
+
+// encodeReflect encodes rv for encodeValue. visiting holds the pointers, maps
+// and slices that rv is nested in, to detect values that contain themselves.
+func encodeReflect(rv reflect.Value, visiting map[visitKey]bool) (any, error) {
+	if rv.IsValid() && rv.CanInterface() {
+		if v, ok, err := goToPython(rv.Interface()); ok {
+			return v, err
+		}
+	}
+	switch rv.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if rv.IsNil() {
+			return pickle.None{}, nil
+		}
+		if rv.Kind() == reflect.Pointer {
+			if isPickleType(rv.Type().Elem()) {
+				return rv.Interface(), nil
+			}
+			leave, err := visit(rv, visiting)
+			if err != nil {
+				return nil, err
+			}
+			defer leave()
+		}
+		return encodeReflect(rv.Elem(), visiting)
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(b), rv)
+			if rv.Type() == byteArrayType {
+				return b, nil // og-rek encodes []byte as a bytearray
+			}
+			return pickle.Bytes(b), nil
+		}
+		if rv.Kind() == reflect.Slice && rv.Len() > 0 {
+			leave, err := visit(rv, visiting)
+			if err != nil {
+				return nil, err
+			}
+			defer leave()
+		}
+		items := make([]any, rv.Len())
+		for i := range items {
+			item, err := encodeReflect(rv.Index(i), visiting)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		switch rv.Type() {
+		case reflect.TypeFor[pickle.Tuple]():
+			return pickle.Tuple(items), nil
+		case setType:
+			return pickle.Call{Callable: pySet, Args: pickle.Tuple{items}}, nil
+		case frozenSetType:
+			return pickle.Call{Callable: pyFrozenSet, Args: pickle.Tuple{items}}, nil
+		}
+		return items, nil
+	case reflect.Map:
+		if rv.IsNil() {
+			return map[any]any{}, nil
+		}
+		leave, err := visit(rv, visiting)
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+		m := make(map[any]any, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			k, err := encodeReflect(iter.Key(), visiting)
+			if err != nil {
+				return nil, err
+			}
+			item, err := encodeReflect(iter.Value(), visiting)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = item
+		}
+		return m, nil
+	case reflect.Struct:
+		if isPickleType(rv.Type()) {
+			return rv.Interface(), nil
+		}
+		m := map[any]any{}
+		for _, f := range structFields(rv.Type()) {
+			field := rv.Field(f.index)
+			if f.omitEmpty && field.IsZero() {
+				continue
+			}
+			item, err := encodeReflect(field, visiting)
+			if err != nil {
+				return nil, err
+			}
+			m[f.name] = item
+		}
+		return m, nil
+	}
+	return rv.Interface(), nil
+}
+
+// visitKey identifies a pointer, map or slice for visit. Values of different
+// types, or slices of different lengths, may share an address, e.g. a struct
+// and its first field.
+type visitKey struct {
+	typ reflect.Type
+	ptr uintptr
+	len int
+}
+
+// visit marks the pointer, map or slice rv as being encoded, and returns a
+// function that unmarks it. Like encoding/json, it returns an error if rv is
+// already being encoded, as the value then contains itself.
+func visit(rv reflect.Value, visiting map[visitKey]bool) (func(), error) {
+	key := visitKey{rv.Type(), rv.Pointer(), 0}
+	if rv.Kind() == reflect.Slice {
+		key.len = rv.Len()
+	}
+	if visiting[key] {
+		return nil, InvalidError{Exception: fmt.Sprintf("can't encode a cyclic value of type %s", rv.Type())}
+	}
+	visiting[key] = true
+	return func() { delete(visiting, key) }, nil
+}
+
+// isPickleType reports whether t is a struct type with a meaning in the pickle
+// codec, which is encoded by the codec itself.
+func isPickleType(t reflect.Type) bool {
+	return t.PkgPath() == pickleNoneType.PkgPath() || t == bigIntType
+}
+
+// needsEncoding reports whether values of type t may contain structs that
+// encodeValue converts to dicts.
+func needsEncoding(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Slice, reflect.Array:
+		return t.Elem().Kind() == reflect.Uint8 || needsEncoding(t.Elem(), seen)
+	case reflect.Pointer:
+		return needsEncoding(t.Elem(), seen)
+	case reflect.String:
+		return t == decimalType
+	case reflect.Map:
+		return needsEncoding(t.Key(), seen) || needsEncoding(t.Elem(), seen)
+	case reflect.Struct:
+		return !isPickleType(t)
+	}
+	return false
+}
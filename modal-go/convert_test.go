@@ -0,0 +1,246 @@
+package modal
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	pickle "github.com/kisielk/og-rek"
+	"github.com/onsi/gomega"
+)
+
+type testAddress struct {
+	City string `modal:"city"`
+	Zip  *int   `modal:"zip"`
+}
+
+type testUser struct {
+	UserId    int64             `modal:"user_id"`
+	Name      string            // matched by field name, ignoring case
+	Scores    []float64         `modal:"scores"`
+	Tags      map[string]bool   `modal:"tags"`
+	Address   *testAddress      `modal:"address"`
+	Addresses []testAddress     `modal:"addresses,omitempty"`
+	Extra     map[string]any    `modal:"extra"`
+	Secret    string            `modal:"-"`
+	Pair      [2]string         `modal:"pair"`
+	Raw       []byte            `modal:"raw"`
+	Big       *big.Int          `modal:"big"`
+	Nested    map[string][]int8 `modal:"nested"`
+	Dash      string            `modal:"-,"`
+}
+
+type testNode struct {
+	Next *testNode      `modal:"next"`
+	Meta map[string]any `modal:"meta"`
+}
+
+func TestDecode(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	big1, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	value := map[any]any{
+		"user_id":  int64(42),
+		"name":     "Ada",
+		"scores":   []any{1.5, int64(2)},
+		"tags":     map[any]any{"admin": true},
+		"address":  map[any]any{"city": "London", "zip": pickle.None{}},
+		"extra":    map[any]any{"k": pickle.Tuple{int64(1), "x"}},
+		"-":        "dash",
+		"Secret":   "ignored",
+		"pair":     pickle.Tuple{"a", "b"},
+		"raw":      pickle.Bytes("\x00\x01"),
+		"big":      big1,
+		"nested":   map[any]any{"n": []any{int64(-1), int64(127)}},
+		"unknown":  "ignored",
+		"nickname": pickle.None{},
+	}
+
+	var user testUser
+	g.Expect(Decode(value, &user)).To(gomega.Succeed())
+	g.Expect(user).To(gomega.Equal(testUser{
+		UserId:  42,
+		Name:    "Ada",
+		Scores:  []float64{1.5, 2},
+		Tags:    map[string]bool{"admin": true},
+		Address: &testAddress{City: "London"},
+		Extra:   map[string]any{"k": pickle.Tuple{int64(1), "x"}},
+		Pair:    [2]string{"a", "b"},
+		Raw:     []byte{0, 1},
+		Big:     big1,
+		Nested:  map[string][]int8{"n": {-1, 127}},
+		Dash:    "dash",
+	}))
+
+	var n int
+	g.Expect(Decode(int64(7), &n)).To(gomega.Succeed())
+	g.Expect(n).To(gomega.Equal(7))
+	var p *string
+	g.Expect(Decode(pickle.None{}, &p)).To(gomega.Succeed())
+	g.Expect(p).To(gomega.BeNil())
+	var v any
+	g.Expect(Decode([]any{"x"}, &v)).To(gomega.Succeed())
+	g.Expect(v).To(gomega.Equal([]any{"x"}))
+
+	var tm time.Time
+	g.Expect(Decode(NaiveDateTime{time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}, &tm)).To(gomega.Succeed())
+	g.Expect(tm).To(gomega.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)))
+	var d Date
+	g.Expect(Decode(Date{Year: 2024, Month: time.May, Day: 1}, &d)).To(gomega.Succeed())
+	g.Expect(d.String()).To(gomega.Equal("2024-05-01"))
+	g.Expect(Decode(map[any]any{}, &d)).To(gomega.MatchError(ErrDecode))
+	var f float64
+	g.Expect(Decode(Decimal("1.25"), &f)).To(gomega.Succeed())
+	g.Expect(f).To(gomega.Equal(1.25))
+	var ints []int
+	g.Expect(Decode(Set{int64(1)}, &ints)).To(gomega.Succeed())
+	g.Expect(ints).To(gomega.Equal([]int{1}))
+	var b []byte
+	g.Expect(Decode(ByteArray("ab"), &b)).To(gomega.Succeed())
+	g.Expect(b).To(gomega.Equal([]byte("ab")))
+
+	g.Expect(Decode(int64(1), user)).To(gomega.MatchError(ErrInvalid))
+}
+
+func TestDecodeErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name  string
+		value any
+		out   any
+		err   DecodeError
+	}{
+		{
+			name:  "str into int",
+			value: map[any]any{"user_id": "42"},
+			out:   &testUser{},
+			err:   DecodeError{Path: "result.user_id", PythonType: "str", GoType: reflect.TypeFor[int64]()},
+		},
+		{
+			name:  "nested",
+			value: map[any]any{"addresses": []any{map[any]any{"city": "Paris"}, map[any]any{"city": int64(1)}}},
+			out:   &testUser{},
+			err:   DecodeError{Path: "result.addresses[1].city", PythonType: "int", GoType: reflect.TypeFor[string]()},
+		},
+		{
+			name:  "overflow",
+			value: map[any]any{"nested": map[any]any{"n": []any{int64(300)}}},
+			out:   &testUser{},
+			err:   DecodeError{Path: "result.nested[n][0]", PythonType: "int", GoType: reflect.TypeFor[int8](), Reason: "value 300 overflows int8"},
+		},
+		{
+			name:  "None into struct",
+			value: pickle.None{},
+			out:   &testUser{},
+			err:   DecodeError{Path: "result", PythonType: "NoneType", GoType: reflect.TypeFor[testUser]()},
+		},
+		{
+			name:  "tuple length",
+			value: map[any]any{"pair": pickle.Tuple{"a"}},
+			out:   &testUser{},
+			err:   DecodeError{Path: "result.pair", PythonType: "tuple", GoType: reflect.TypeFor[[2]string](), Reason: "length 1 doesn't match 2"},
+		},
+		{
+			name:  "exception instance",
+			value: pickle.Call{Callable: pickle.Class{Module: "builtins", Name: "ValueError"}},
+			out:   new(string),
+			err:   DecodeError{Path: "result", PythonType: "ValueError", GoType: reflect.TypeFor[string]()},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			g := gomega.NewWithT(t)
+
+			err := Decode(tc.value, tc.out)
+			g.Expect(err).To(gomega.Equal(tc.err))
+			g.Expect(errors.Is(err, ErrDecode)).To(gomega.BeTrue())
+		})
+	}
+
+	g := gomega.NewWithT(t)
+	err := Decode(map[any]any{"user_id": "42"}, &testUser{})
+	g.Expect(err.Error()).To(gomega.Equal("DecodeError: cannot decode Python str into Go int64 at result.user_id"))
+}
+
+func TestEncodeStructs(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	zip := 12345
+	user := testUser{
+		UserId:  42,
+		Name:    "Ada",
+		Scores:  []float64{0.5},
+		Tags:    map[string]bool{"admin": false},
+		Address: &testAddress{City: "London", Zip: &zip},
+		Extra:   map[string]any{"t": pickle.Tuple{"x", int64(1)}},
+		Secret:  "hidden",
+		Pair:    [2]string{"a", "b"},
+		Raw:     []byte("raw"),
+		Big:     new(big.Int).Lsh(big.NewInt(1), 100),
+		Nested:  map[string][]int8{"n": {1}},
+		Dash:    "dash",
+	}
+	buf, err := pickleSerialize(pickle.Tuple{[]any{user}, map[string]any{"opt": &testAddress{City: "Paris"}}})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	v, err := pickleDeserialize(buf.Bytes())
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+	args := v.(pickle.Tuple)
+	g.Expect(args).To(gomega.HaveLen(2))
+	encoded := args[0].([]any)[0].(map[any]any)
+	g.Expect(encoded).To(gomega.HaveKeyWithValue("user_id", int64(42)))
+	g.Expect(encoded).To(gomega.HaveKeyWithValue("Name", "Ada"))
+	g.Expect(encoded).To(gomega.HaveKeyWithValue("address", map[any]any{"city": "London", "zip": int64(12345)}))
+	g.Expect(encoded).To(gomega.HaveKeyWithValue("raw", []byte("raw")))
+	g.Expect(encoded).To(gomega.HaveKeyWithValue("big", user.Big))
+	g.Expect(encoded).NotTo(gomega.HaveKey("Secret"))
+	g.Expect(encoded).To(gomega.HaveKeyWithValue("-", "dash"))
+	g.Expect(encoded).NotTo(gomega.HaveKey("addresses")) // omitempty
+	g.Expect(args[1]).To(gomega.Equal(map[any]any{"opt": map[any]any{"city": "Paris", "zip": pickle.None{}}}))
+
+	// The encoded struct decodes back into an equal struct.
+	var decoded testUser
+	g.Expect(Decode(encoded, &decoded)).To(gomega.Succeed())
+	user.Secret = ""
+	g.Expect(decoded).To(gomega.Equal(user))
+
+	// Values without structs are pickled as before.
+	buf, err = pickleSerialize(pickle.Tuple{[]any(nil), map[string]any(nil)})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	v, err = pickleDeserialize(buf.Bytes())
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(v).To(gomega.Equal(pickle.Tuple{[]any{}, map[any]any{}}))
+}
+
+func TestEncodeCycles(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	node := &testNode{}
+	node.Next = node
+	m := map[string]any{}
+	m["self"] = m
+	s := []any{nil}
+	s[0] = s
+	meta := &testNode{Meta: map[string]any{}}
+	meta.Meta["node"] = meta
+	for _, v := range []any{node, m, s, meta} {
+		_, err := pickleSerialize(pickle.Tuple{[]any{v}, map[string]any{}})
+		g.Expect(err).To(gomega.MatchError(ErrInvalid))
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("cyclic value")))
+	}
+
+	// Values that are shared but not cyclic are encoded each time.
+	shared := &testNode{Meta: map[string]any{"k": "v"}}
+	buf, err := pickleSerialize([]any{shared, shared, &testNode{Next: shared}})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	v, err := pickleDeserialize(buf.Bytes())
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	leaf := map[any]any{"next": pickle.None{}, "meta": map[any]any{"k": "v"}}
+	g.Expect(v).To(gomega.Equal([]any{leaf, leaf, map[any]any{"next": leaf, "meta": map[any]any{}}}))
+}
@@ -0,0 +1,275 @@
+package modal
+
+// Credential providers, which supply the token used to authenticate RPCs and
+// allow it to be rotated while a client is in use.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Credentials are a Modal token, which authenticates a client with a workspace.
+type Credentials struct {
+	TokenId     string
+	TokenSecret string
+	// Expiry is when the credentials stop being valid. CachedCredentials fetch
+	// new ones after it. The zero value means they don't expire.
+	Expiry time.Time
+}
+
+// CredentialsProvider supplies the credentials of a client. Credentials is called
+// before every RPC, including retries, so providers that are slow to call, such as
+// CommandCredentials, should be wrapped with CachedCredentials.
+//
+// Implementations must be safe for concurrent use.
+type CredentialsProvider interface {
+	Credentials(ctx context.Context) (Credentials, error)
+}
+
+// credentialsRefresher is implemented by providers that cache credentials, such as
+// CachedCredentials. The client calls Refresh when the server rejects the
+// credentials, so that the next call to Credentials fetches new ones.
+type credentialsRefresher interface {
+	Refresh()
+}
+
+// CredentialsProviderFunc adapts a function to a CredentialsProvider.
+type CredentialsProviderFunc func(ctx context.Context) (Credentials, error)
+
+func (f CredentialsProviderFunc) Credentials(ctx context.Context) (Credentials, error) {
+	return f(ctx)
+}
+
+// StaticCredentials returns a provider of a fixed token.
+func StaticCredentials(tokenId, tokenSecret string) CredentialsProvider {
+	return CredentialsProviderFunc(func(ctx context.Context) (Credentials, error) {
+		return Credentials{TokenId: tokenId, TokenSecret: tokenSecret}, nil
+	})
+}
+
+// EnvCredentials returns a provider that reads the MODAL_TOKEN_ID and
+// MODAL_TOKEN_SECRET environment variables on every call.
+func EnvCredentials() CredentialsProvider {
+	return CredentialsProviderFunc(func(ctx context.Context) (Credentials, error) {
+		creds := Credentials{TokenId: os.Getenv("MODAL_TOKEN_ID"), TokenSecret: os.Getenv("MODAL_TOKEN_SECRET")}
+		if creds.TokenId == "" || creds.TokenSecret == "" {
+			return Credentials{}, fmt.Errorf("MODAL_TOKEN_ID and MODAL_TOKEN_SECRET must be set")
+		}
+		return creds, nil
+	})
+}
+
+// ConfigFileCredentials returns a provider that reads the token of a profile in
+// the config file on every call, so that changes to the file are picked up. If
+// profile is empty, MODAL_PROFILE or the active profile is used.
+func ConfigFileCredentials(profile string) CredentialsProvider {
+	return CredentialsProviderFunc(func(ctx context.Context) (Credentials, error) {
+		path, err := configFilePath()
+		if err != nil {
+			return Credentials{}, err
+		}
+		cfg, err := readConfigFile(path)
+		if err != nil {
+			return Credentials{}, err
+		}
+		name := firstNonEmpty(profile, os.Getenv("MODAL_PROFILE"))
+		if name == "" {
+			if name, err = cfg.activeProfile(path); err != nil {
+				return Credentials{}, err
+			}
+		}
+		raw, ok := cfg[name]
+		if name == "" || !ok {
+			return Credentials{}, NotFoundError{Exception: fmt.Sprintf("profile '%s' not found in %s", name, path)}
+		}
+		if raw.TokenId == "" || raw.TokenSecret == "" {
+			return Credentials{}, fmt.Errorf("profile '%s' in %s has no token_id or token_secret", name, path)
+		}
+		return Credentials{TokenId: raw.TokenId, TokenSecret: raw.TokenSecret}, nil
+	})
+}
+
+// commandCredentialsOutput is the JSON printed by the command of CommandCredentials.
+type commandCredentialsOutput struct {
+	TokenId     string    `json:"token_id"`
+	TokenSecret string    `json:"token_secret"`
+	Expiry      time.Time `json:"expiry"`
+}
+
+// CommandCredentials returns a provider that runs an external command, such as a
+// secrets manager CLI, which prints the token as a JSON object to stdout:
+//
+//	{"token_id": "ak-...", "token_secret": "as-...", "expiry": "2025-01-02T15:04:05Z"}
+//
+// The expiry is optional, in RFC 3339 format. The command runs on every call,
+// so the provider is usually wrapped with CachedCredentials.
+func CommandCredentials(name string, args ...string) CredentialsProvider {
+	return CredentialsProviderFunc(func(ctx context.Context) (Credentials, error) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return Credentials{}, fmt.Errorf("credentials command %s failed: %w: %s", name, err, msg)
+			}
+			return Credentials{}, fmt.Errorf("credentials command %s failed: %w", name, err)
+		}
+
+		// Don't include the output in errors, since it may contain the secret.
+		var out commandCredentialsOutput
+		if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+			return Credentials{}, fmt.Errorf("credentials command %s printed invalid JSON", name)
+		}
+		if out.TokenId == "" || out.TokenSecret == "" {
+			return Credentials{}, fmt.Errorf("credentials command %s printed no token_id or token_secret", name)
+		}
+		return Credentials(out), nil
+	})
+}
+
+// CachedCredentials returns a provider that caches the credentials of provider,
+// and fetches new ones when they expire, when ttl has passed since they were
+// fetched, or after the server rejects them. A ttl of 0 caches them until they
+// expire or are rejected.
+func CachedCredentials(provider CredentialsProvider, ttl time.Duration) CredentialsProvider {
+	return &cachedCredentials{provider: provider, ttl: ttl}
+}
+
+type cachedCredentials struct {
+	provider CredentialsProvider
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	creds   Credentials
+	fetched time.Time // zero if there are no cached credentials
+}
+
+// expiryMargin is how long before their expiry cached credentials are refreshed,
+// so that they don't expire while an RPC is in flight.
+const expiryMargin = 30 * time.Second
+
+func (c *cachedCredentials) Credentials(ctx context.Context) (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.fetched.IsZero() && !c.expired(time.Now()) {
+		return c.creds, nil
+	}
+	creds, err := c.provider.Credentials(ctx)
+	if err != nil {
+		return Credentials{}, err
+	}
+	c.creds, c.fetched = creds, time.Now()
+	return creds, nil
+}
+
+func (c *cachedCredentials) expired(now time.Time) bool {
+	if c.ttl > 0 && now.Sub(c.fetched) >= c.ttl {
+		return true
+	}
+	return !c.creds.Expiry.IsZero() && now.Add(expiryMargin).After(c.creds.Expiry)
+}
+
+func (c *cachedCredentials) Refresh() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fetched = time.Time{}
+	if r, ok := c.provider.(credentialsRefresher); ok {
+		r.Refresh()
+	}
+}
+
+// authenticatedKey marks contexts returned by clientContext, whose RPCs are sent
+// with the client's credentials.
+type authenticatedKey struct{}
+
+// credentialsContext returns ctx with the client's current credentials added to
+// the outgoing metadata, if ctx comes from clientContext.
+func (c *Client) credentialsContext(ctx context.Context) (context.Context, error) {
+	if ctx.Value(authenticatedKey{}) == nil {
+		return ctx, nil
+	}
+	if c.credentials == nil {
+		return nil, fmt.Errorf("missing token_id or token_secret, please set in .modal.toml, environment variables, or via InitializeClient()")
+	}
+	creds, err := c.credentials.Credentials(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials: %w", err)
+	}
+	if creds.TokenId == "" || creds.TokenSecret == "" {
+		return nil, fmt.Errorf("credentials provider returned an empty token_id or token_secret")
+	}
+	return metadata.AppendToOutgoingContext(ctx,
+		"x-modal-token-id", creds.TokenId,
+		"x-modal-token-secret", creds.TokenSecret,
+	), nil
+}
+
+// refreshCredentials discards the cached credentials and auth token of the client
+// after the server rejected them.
+func (c *Client) refreshCredentials() {
+	if r, ok := c.credentials.(credentialsRefresher); ok {
+		r.Refresh()
+	}
+	c.setAuthToken("")
+}
+
+// credentialsInterceptor adds the client's credentials to each attempt of a unary
+// RPC. If the server rejects them, the credentials are refreshed, the auth token
+// is cleared, and the attempt is made once more.
+func credentialsInterceptor(c *Client) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply any,
+		cc *grpc.ClientConn,
+		inv grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		aCtx, err := c.credentialsContext(ctx)
+		if err != nil {
+			return err
+		}
+		err = inv(aCtx, method, req, reply, cc, opts...)
+		if status.Code(err) != codes.Unauthenticated || ctx.Value(authenticatedKey{}) == nil {
+			return err
+		}
+
+		c.logger.DebugContext(ctx, "refreshing credentials", "method", method)
+		c.refreshCredentials()
+		if aCtx, err = c.credentialsContext(ctx); err != nil {
+			return err
+		}
+		return inv(aCtx, method, req, reply, cc, opts...)
+	}
+}
+
+// credentialsStreamInterceptor adds the client's credentials to streaming RPCs.
+func credentialsStreamInterceptor(c *Client) grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		ctx, err := c.credentialsContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
@@ -0,0 +1,183 @@
+package modal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestEnvAndConfigFileCredentials(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+
+	path := setupConfigFile(t, `
+[default]
+token_id = "ak-1"
+token_secret = "as-1"
+active = true
+`)
+
+	_, err := EnvCredentials().Credentials(ctx)
+	g.Expect(err).Should(gomega.HaveOccurred())
+	t.Setenv("MODAL_TOKEN_ID", "ak-env")
+	t.Setenv("MODAL_TOKEN_SECRET", "as-env")
+	creds, err := EnvCredentials().Credentials(ctx)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(creds).To(gomega.Equal(Credentials{TokenId: "ak-env", TokenSecret: "as-env"}))
+
+	provider := ConfigFileCredentials("")
+	creds, err = provider.Credentials(ctx)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(creds).To(gomega.Equal(Credentials{TokenId: "ak-1", TokenSecret: "as-1"}))
+
+	// The file is read again on every call.
+	g.Expect(os.WriteFile(path, []byte("[default]\ntoken_id = \"ak-2\"\ntoken_secret = \"as-2\"\nactive = true\n"), 0o600)).To(gomega.Succeed())
+	creds, err = provider.Credentials(ctx)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(creds.TokenId).To(gomega.Equal("ak-2"))
+
+	_, err = ConfigFileCredentials("missing").Credentials(ctx)
+	g.Expect(err).Should(gomega.BeAssignableToTypeOf(NotFoundError{}))
+}
+
+func TestCommandCredentials(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+
+	script := filepath.Join(t.TempDir(), "creds.sh")
+	g.Expect(os.WriteFile(script, []byte(`#!/bin/sh
+echo '{"token_id": "ak-cmd", "token_secret": "as-cmd", "expiry": "2030-01-02T15:04:05Z"}'
+`), 0o700)).To(gomega.Succeed())
+
+	creds, err := CommandCredentials("sh", script).Credentials(ctx)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(creds).To(gomega.Equal(Credentials{
+		TokenId:     "ak-cmd",
+		TokenSecret: "as-cmd",
+		Expiry:      time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC),
+	}))
+
+	_, err = CommandCredentials("sh", "-c", "echo 'as-secret'").Credentials(ctx)
+	g.Expect(err).Should(gomega.MatchError(gomega.ContainSubstring("printed invalid JSON")))
+	g.Expect(err.Error()).ShouldNot(gomega.ContainSubstring("as-secret"))
+
+	_, err = CommandCredentials("sh", "-c", "echo denied >&2; exit 3").Credentials(ctx)
+	g.Expect(err).Should(gomega.MatchError(gomega.ContainSubstring("exit status 3: denied")))
+}
+
+func TestCachedCredentials(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+
+	var calls atomic.Int32
+	var expiry time.Time
+	provider := CachedCredentials(CredentialsProviderFunc(func(ctx context.Context) (Credentials, error) {
+		calls.Add(1)
+		return Credentials{TokenId: "ak-123", TokenSecret: "as-123", Expiry: expiry}, nil
+	}), 0)
+
+	for range 3 {
+		_, err := provider.Credentials(ctx)
+		g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	}
+	g.Expect(calls.Load()).To(gomega.Equal(int32(1)))
+
+	provider.(credentialsRefresher).Refresh()
+	_, err := provider.Credentials(ctx)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(calls.Load()).To(gomega.Equal(int32(2)))
+
+	// Credentials that are about to expire are fetched again.
+	expiry = time.Now().Add(time.Second)
+	provider.(credentialsRefresher).Refresh()
+	for range 2 {
+		_, err = provider.Credentials(ctx)
+		g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	}
+	g.Expect(calls.Load()).To(gomega.Equal(int32(4)))
+
+	// A TTL bounds how long credentials without an expiry are cached.
+	calls.Store(0)
+	expiry = time.Time{}
+	provider = CachedCredentials(provider.(*cachedCredentials).provider, time.Millisecond)
+	_, err = provider.Credentials(ctx)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	time.Sleep(2 * time.Millisecond)
+	_, err = provider.Credentials(ctx)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(calls.Load()).To(gomega.Equal(int32(2)))
+}
+
+func TestCredentialsInterceptorRefreshesOnUnauthenticated(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	var fetches atomic.Int32
+	provider := CachedCredentials(CredentialsProviderFunc(func(ctx context.Context) (Credentials, error) {
+		n := fetches.Add(1)
+		if n == 1 {
+			return Credentials{TokenId: "ak-old", TokenSecret: "as-old"}, nil
+		}
+		return Credentials{TokenId: "ak-new", TokenSecret: "as-new"}, nil
+	}), 0)
+	c := newTestClient(g, nil, ClientOptions{
+		Credentials: provider,
+	})
+	c.setAuthToken("stale-auth-token")
+	ctx, err := c.clientContext(context.Background())
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+	// The auth token interceptor runs inside the credentials interceptor.
+	interceptor := credentialsInterceptor(c)
+	authToken := authTokenInterceptor(c)
+	var sent []metadata.MD
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return authToken(ctx, method, req, reply, cc, func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			sent = append(sent, md)
+			if ids := md.Get("x-modal-token-id"); len(ids) == 0 || ids[0] == "ak-old" {
+				return status.Error(codes.Unauthenticated, "token expired")
+			}
+			return nil
+		}, opts...)
+	}
+	g.Expect(interceptor(ctx, "/modal.client.ModalClient/AppGetOrCreate", nil, nil, nil, invoker)).To(gomega.Succeed())
+
+	g.Expect(sent).To(gomega.HaveLen(2))
+	g.Expect(sent[0].Get("x-modal-auth-token")).To(gomega.Equal([]string{"stale-auth-token"}))
+	g.Expect(sent[1].Get("x-modal-token-id")).To(gomega.Equal([]string{"ak-new"}))
+	g.Expect(sent[1].Get("x-modal-token-secret")).To(gomega.Equal([]string{"as-new"}))
+	g.Expect(sent[1].Get("x-modal-auth-token")).To(gomega.BeEmpty())
+	g.Expect(fetches.Load()).To(gomega.Equal(int32(2)))
+
+	// Contexts that are not authenticated are sent without credentials.
+	sent = nil
+	g.Expect(interceptor(c.anonymousContext(context.Background()), "/modal.client.ModalClient/TokenFlowCreate", nil, nil, nil, invoker)).ShouldNot(gomega.Succeed())
+	g.Expect(sent).To(gomega.HaveLen(1))
+}
+
+func TestClientWithoutCredentials(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	c := newTestClient(g, nil, ClientOptions{})
+	c.credentials = nil // as if the profile had no token
+	ctx, err := c.clientContext(context.Background())
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	}
+	err = credentialsInterceptor(c)(ctx, "/modal.client.ModalClient/AppGetOrCreate", nil, nil, nil, invoker)
+	g.Expect(err).Should(gomega.MatchError(gomega.ContainSubstring("missing token_id or token_secret")))
+}
@@ -16,13 +16,67 @@
 //
 // At runtime the client resolves credentials in this order:
 //
-//  1. Environment variables
+//  1. [ClientOptions] passed to [NewClient] or [InitializeClient]
+//  2. Environment variables
 //     MODAL_TOKEN_ID, MODAL_TOKEN_SECRET, MODAL_ENVIRONMENT (optional)
-//  2. A profile explicitly requested via `MODAL_PROFILE`
-//  3. A profile marked `active = true` in `~/.modal.toml`
+//  3. A profile explicitly requested via `MODAL_PROFILE`
+//  4. A profile marked `active = true` in `~/.modal.toml`
 //
+// The default client is created on first use, so errors such as a malformed
+// config file are returned from the first call. Call [Init] to surface them at
+// startup instead, and [ResolveProfile] to see where each value came from.
 // See `config.go` for the resolution logic.
 //
+// # Clients
+//
+// Package-level functions such as [FunctionLookup] use a default [Client].
+// To talk to several workspaces or environments from one process, create
+// additional clients with [NewClient] and call the same methods on them:
+//
+//	c, err := modal.NewClient(modal.ClientOptions{Profile: "staging"})
+//	fn, err := c.FunctionLookup(ctx, "my-app", "my-function", nil)
+//
+// # Python values
+//
+// Arguments, results and Queue items are exchanged with Python as pickles, by
+// default, which map Go and Python values as follows:
+//
+//	Python                         Go result          Go argument
+//	None                           pickle.None        nil
+//	bool                           bool               bool
+//	int                            int64, or *big.Int integer types, big.Int
+//	                               if it doesn't fit
+//	float                          float64            float32, float64
+//	str                            string             string
+//	bytes                          []byte             []byte, pickle.Bytes
+//	bytearray                      [ByteArray]        [ByteArray]
+//	tuple                          pickle.Tuple       pickle.Tuple
+//	list                           []any              slices, arrays
+//	dict                           map[any]any        maps, structs
+//	set, frozenset                 [Set], [FrozenSet] [Set], [FrozenSet]
+//	datetime.datetime with tzinfo  time.Time          time.Time
+//	datetime.datetime, naive       [NaiveDateTime]    [NaiveDateTime]
+//	datetime.date                  [Date]             [Date]
+//	decimal.Decimal                [Decimal]          [Decimal]
+//	numpy.ndarray                  see below          [NDArray]
+//
+// Python time zones are decoded as fixed offsets, or as the time.Location of a
+// zoneinfo.ZoneInfo key, and time.Time is encoded with a fixed offset. bytes
+// dict keys are left as pickle.Bytes, since []byte can't be a map key. Other
+// Python objects are decoded as a pickle.Call of their class, or as a
+// [PythonObject] if they were pickled with state. Use [Decode] to decode
+// results into specific Go types, including NumPy arrays of bools, ints and
+// floats into an [NDArray].
+//
+// Queues can use another [Codec] instead, such as [JSONCodec] for Python code
+// that accepts JSON, with [Queue.WithCodec] or [ClientOptions].Codec. Function
+// arguments are always pickled for now, since Modal has no data format for
+// other Function inputs.
+//
+// Large Function arguments are uploaded to blob storage. An argument that
+// shouldn't be held in memory, such as the contents of a large file, can be
+// passed as a [ReaderArg], which Python receives as bytes.
+//
 // # Stability
 //
 // `libmodal` is **alpha** software; the API may change without notice until
@@ -1,6 +1,50 @@
 package modal
 
 // errors.go defines common error types for the public API.
+//
+// Each error type matches a sentinel with errors.Is, e.g. errors.Is(err, ErrNotFound),
+// and errors.As can be used to get its details. Errors created from a failed RPC
+// also record its method and the ID of the object it was about, and unwrap to the
+// gRPC status error, so status.Code(err) still returns its code.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ErrClientClosed is returned when a Client is used after Close.
+var ErrClientClosed = errors.New("client is closed")
+
+// Sentinels matched by the error types of this package with errors.Is.
+var (
+	ErrFunctionTimeout    = errors.New("function timeout")
+	ErrRemote             = errors.New("remote error")
+	ErrInternalFailure    = errors.New("internal failure")
+	ErrExecution          = errors.New("execution error")
+	ErrNotFound           = errors.New("not found")
+	ErrInvalid            = errors.New("invalid")
+	ErrQueueEmpty         = errors.New("queue empty")
+	ErrQueueFull          = errors.New("queue full")
+	ErrSandboxFilesystem  = errors.New("sandbox filesystem error")
+	ErrSandboxTimeout     = errors.New("sandbox timeout")
+	ErrAuthentication     = errors.New("authentication failed")
+	ErrPermissionDenied   = errors.New("permission denied")
+	ErrRateLimited        = errors.New("rate limited")
+	ErrAlreadyExists      = errors.New("already exists")
+	ErrConflict           = errors.New("conflict")
+	ErrServiceUnavailable = errors.New("service unavailable")
+	ErrDecode             = errors.New("decode error")
+)
 
 // FunctionTimeoutError is returned when a function execution exceeds the allowed time limit.
 type FunctionTimeoutError struct {
@@ -11,15 +55,43 @@ func (e FunctionTimeoutError) Error() string {
 	return "FunctionTimeoutError: " + e.Exception
 }
 
+func (e FunctionTimeoutError) Is(target error) bool { return target == ErrFunctionTimeout }
+
 // RemoteError represents an error on the Modal server, or a Python exception.
+//
+// For an exception raised by a Function, the exception type, arguments and
+// traceback are decoded as far as possible. Test for a Python exception type with
+// errors.Is(err, RemoteException("ValueError")), and use errors.As to get the
+// RemoteError with its details.
 type RemoteError struct {
-	Exception string
+	Exception     string           // repr of the exception, e.g. "ValueError('bad input')"
+	ExceptionType string           // name of the exception class, e.g. "ValueError" or "mymodule.MyError"
+	Message       string           // str of the exception, e.g. "bad input"
+	Args          []any            // args of the exception, if it could be unpickled
+	Traceback     string           // formatted Python traceback
+	Frames        []TracebackFrame // frames of Traceback, outermost first
 }
 
 func (e RemoteError) Error() string {
 	return "RemoteError: " + e.Exception
 }
 
+func (e RemoteError) Is(target error) bool {
+	if t, ok := target.(RemoteException); ok {
+		return e.ExceptionType != "" && e.ExceptionType == string(t)
+	}
+	return target == ErrRemote
+}
+
+// RemoteException is the name of a Python exception class, which matches a
+// RemoteError of that type with errors.Is. Classes outside of builtins are
+// qualified by their module, e.g. RemoteException("mymodule.MyError").
+type RemoteException string
+
+func (e RemoteException) Error() string {
+	return "remote exception " + string(e)
+}
+
 // InternalFailure is a retryable internal error from Modal.
 type InternalFailure struct {
 	Exception string
@@ -29,6 +101,8 @@ func (e InternalFailure) Error() string {
 	return "InternalFailure: " + e.Exception
 }
 
+func (e InternalFailure) Is(target error) bool { return target == ErrInternalFailure }
+
 // ExecutionError is returned when something unexpected happened during runtime.
 type ExecutionError struct {
 	Exception string
@@ -38,24 +112,38 @@ func (e ExecutionError) Error() string {
 	return "ExecutionError: " + e.Exception
 }
 
+func (e ExecutionError) Is(target error) bool { return target == ErrExecution }
+
 // NotFoundError is returned when a resource is not found.
 type NotFoundError struct {
 	Exception string
+	Method    string // gRPC method of the failed RPC, if any
+	ObjectId  string // ID of the object that the RPC was about, if known
+	err       error
 }
 
 func (e NotFoundError) Error() string {
 	return "NotFoundError: " + e.Exception
 }
 
+func (e NotFoundError) Is(target error) bool { return target == ErrNotFound }
+func (e NotFoundError) Unwrap() error        { return e.err }
+
 // InvalidError represents an invalid request or operation.
 type InvalidError struct {
 	Exception string
+	Method    string // gRPC method of the failed RPC, if any
+	ObjectId  string // ID of the object that the RPC was about, if known
+	err       error
 }
 
 func (e InvalidError) Error() string {
 	return "InvalidError: " + e.Exception
 }
 
+func (e InvalidError) Is(target error) bool { return target == ErrInvalid }
+func (e InvalidError) Unwrap() error        { return e.err }
+
 // QueueEmptyError is returned when an operation is attempted on an empty queue.
 type QueueEmptyError struct {
 	Exception string
@@ -65,6 +153,8 @@ func (e QueueEmptyError) Error() string {
 	return "QueueEmptyError: " + e.Exception
 }
 
+func (e QueueEmptyError) Is(target error) bool { return target == ErrQueueEmpty }
+
 // QueueFullError is returned when an operation is attempted on a full queue.
 type QueueFullError struct {
 	Exception string
@@ -74,6 +164,8 @@ func (e QueueFullError) Error() string {
 	return "QueueFullError: " + e.Exception
 }
 
+func (e QueueFullError) Is(target error) bool { return target == ErrQueueFull }
+
 // SandboxFilesystemError is returned when an operation is attempted on a full queue.
 type SandboxFilesystemError struct {
 	Exception string
@@ -83,6 +175,8 @@ func (e SandboxFilesystemError) Error() string {
 	return "SandboxFilesystemError: " + e.Exception
 }
 
+func (e SandboxFilesystemError) Is(target error) bool { return target == ErrSandboxFilesystem }
+
 // SandboxTimeoutError is returned when sandbox operations exceed the allowed time limit.
 type SandboxTimeoutError struct {
 	Exception string
@@ -91,3 +185,270 @@ type SandboxTimeoutError struct {
 func (e SandboxTimeoutError) Error() string {
 	return "SandboxTimeoutError: " + e.Exception
 }
+
+func (e SandboxTimeoutError) Is(target error) bool { return target == ErrSandboxTimeout }
+
+// AuthenticationError is returned when the client's credentials are missing,
+// invalid or expired.
+type AuthenticationError struct {
+	Exception string
+	Method    string // gRPC method of the failed RPC
+	ObjectId  string // ID of the object that the RPC was about, if known
+	err       error
+}
+
+func (e AuthenticationError) Error() string {
+	return "AuthenticationError: " + e.Exception
+}
+
+func (e AuthenticationError) Is(target error) bool { return target == ErrAuthentication }
+func (e AuthenticationError) Unwrap() error        { return e.err }
+
+// PermissionDeniedError is returned when the client's credentials don't allow an operation.
+type PermissionDeniedError struct {
+	Exception string
+	Method    string // gRPC method of the failed RPC
+	ObjectId  string // ID of the object that the RPC was about, if known
+	err       error
+}
+
+func (e PermissionDeniedError) Error() string {
+	return "PermissionDeniedError: " + e.Exception
+}
+
+func (e PermissionDeniedError) Is(target error) bool { return target == ErrPermissionDenied }
+func (e PermissionDeniedError) Unwrap() error        { return e.err }
+
+// RateLimitError is returned when a rate limit or quota of the workspace is exceeded.
+type RateLimitError struct {
+	Exception string
+	Method    string // gRPC method of the failed RPC
+	ObjectId  string // ID of the object that the RPC was about, if known
+	err       error
+}
+
+func (e RateLimitError) Error() string {
+	return "RateLimitError: " + e.Exception
+}
+
+func (e RateLimitError) Is(target error) bool { return target == ErrRateLimited }
+func (e RateLimitError) Unwrap() error        { return e.err }
+
+// AlreadyExistsError is returned when creating an object that already exists.
+type AlreadyExistsError struct {
+	Exception string
+	Method    string // gRPC method of the failed RPC
+	ObjectId  string // ID of the object that the RPC was about, if known
+	err       error
+}
+
+func (e AlreadyExistsError) Error() string {
+	return "AlreadyExistsError: " + e.Exception
+}
+
+func (e AlreadyExistsError) Is(target error) bool { return target == ErrAlreadyExists }
+func (e AlreadyExistsError) Unwrap() error        { return e.err }
+
+// ConflictError is returned when an operation conflicts with the current state of
+// an object, e.g. a concurrent modification, or a Sandbox that has already finished.
+type ConflictError struct {
+	Exception string
+	Method    string // gRPC method of the failed RPC
+	ObjectId  string // ID of the object that the RPC was about, if known
+	err       error
+}
+
+func (e ConflictError) Error() string {
+	return "ConflictError: " + e.Exception
+}
+
+func (e ConflictError) Is(target error) bool { return target == ErrConflict }
+func (e ConflictError) Unwrap() error        { return e.err }
+
+// ServiceUnavailableError is returned when Modal can't be reached, after retries.
+type ServiceUnavailableError struct {
+	Exception string
+	Method    string // gRPC method of the failed RPC
+	ObjectId  string // ID of the object that the RPC was about, if known
+	err       error
+}
+
+func (e ServiceUnavailableError) Error() string {
+	return "ServiceUnavailableError: " + e.Exception
+}
+
+func (e ServiceUnavailableError) Is(target error) bool { return target == ErrServiceUnavailable }
+func (e ServiceUnavailableError) Unwrap() error        { return e.err }
+
+// DecodeError is returned when a Python value can't be decoded into a Go type.
+type DecodeError struct {
+	Path       string       // location of the value, e.g. "result.items[2].name"
+	PythonType string       // e.g. "str", or "dict"
+	GoType     reflect.Type // type that the value was decoded into
+	Reason     string       // optional explanation, e.g. "value 300 overflows int8"
+}
+
+func (e DecodeError) Error() string {
+	msg := fmt.Sprintf("DecodeError: cannot decode Python %s into Go %s at %s", e.PythonType, e.GoType, e.Path)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+func (e DecodeError) Is(target error) bool { return target == ErrDecode }
+
+// withNotFoundMessage returns a NotFoundError for err, which has code NotFound,
+// with msg describing the missing object.
+func withNotFoundMessage(err error, msg string) NotFoundError {
+	var e NotFoundError
+	if !errors.As(err, &e) {
+		e.err = err // e.g. from a client without interceptors
+	}
+	e.Exception = msg
+	return e
+}
+
+// IsRetryable reports whether the operation that returned err may succeed if it is
+// tried again later: if Modal was unavailable, rate limited the request, or had an
+// internal failure. Errors caused by the caller's context are not retryable.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, ErrServiceUnavailable) || errors.Is(err, ErrRateLimited) || errors.Is(err, ErrInternalFailure) {
+		return true
+	}
+	if st, ok := status.FromError(err); ok {
+		switch st.Code() {
+		case codes.Unavailable, codes.ResourceExhausted, codes.Internal, codes.Unknown:
+			return true
+		}
+	}
+	return false
+}
+
+// newStatusError returns the typed error for a gRPC status error from method, or
+// err itself if its code has no corresponding type.
+func newStatusError(err error, method string, req any) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	msg := st.Message()
+	objectId := requestObjectId(req)
+	switch st.Code() {
+	case codes.NotFound:
+		return NotFoundError{Exception: msg, Method: method, ObjectId: objectId, err: err}
+	case codes.InvalidArgument, codes.OutOfRange:
+		return InvalidError{Exception: msg, Method: method, ObjectId: objectId, err: err}
+	case codes.Unauthenticated:
+		return AuthenticationError{Exception: msg, Method: method, ObjectId: objectId, err: err}
+	case codes.PermissionDenied:
+		return PermissionDeniedError{Exception: msg, Method: method, ObjectId: objectId, err: err}
+	case codes.ResourceExhausted:
+		return RateLimitError{Exception: msg, Method: method, ObjectId: objectId, err: err}
+	case codes.AlreadyExists:
+		return AlreadyExistsError{Exception: msg, Method: method, ObjectId: objectId, err: err}
+	case codes.Aborted, codes.FailedPrecondition:
+		return ConflictError{Exception: msg, Method: method, ObjectId: objectId, err: err}
+	case codes.Unavailable:
+		return ServiceUnavailableError{Exception: msg, Method: method, ObjectId: objectId, err: err}
+	default:
+		return err
+	}
+}
+
+// requestObjectId returns the first ID field that is set in a request, such as
+// the function_id of a FunctionMapRequest.
+func requestObjectId(req any) string {
+	m, ok := req.(proto.Message)
+	if !ok {
+		return ""
+	}
+	r := m.ProtoReflect()
+	fields := r.Descriptor().Fields()
+	for i := range fields.Len() {
+		fd := fields.Get(i)
+		if fd.Kind() != protoreflect.StringKind || fd.IsList() || !strings.HasSuffix(string(fd.Name()), "_id") {
+			continue
+		}
+		if r.Has(fd) {
+			return r.Get(fd).String()
+		}
+	}
+	return ""
+}
+
+// errorsInterceptor converts the gRPC status errors of unary RPCs into the typed
+// errors of this package, after all retries.
+func errorsInterceptor() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply any,
+		cc *grpc.ClientConn,
+		inv grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		err := inv(ctx, method, req, reply, cc, opts...)
+		if err != nil {
+			return newStatusError(err, method, req)
+		}
+		return nil
+	}
+}
+
+// errorsStreamInterceptor converts the gRPC status errors of streaming RPCs into
+// the typed errors of this package, like errorsInterceptor does for unary RPCs.
+func errorsStreamInterceptor() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, newStatusError(err, method, nil)
+		}
+		return &errorsClientStream{ClientStream: stream, method: method}, nil
+	}
+}
+
+// errorsClientStream converts the errors of a stream into typed errors, which
+// identify the object of the first message sent.
+type errorsClientStream struct {
+	grpc.ClientStream
+	method string
+
+	mu  sync.Mutex // protects req, since messages can be sent while others are received
+	req any
+}
+
+func (s *errorsClientStream) request() any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.req
+}
+
+func (s *errorsClientStream) SendMsg(m any) error {
+	s.mu.Lock()
+	if s.req == nil {
+		s.req = m
+	}
+	s.mu.Unlock()
+	if err := s.ClientStream.SendMsg(m); err != nil {
+		return newStatusError(err, s.method, s.request())
+	}
+	return nil
+}
+
+func (s *errorsClientStream) RecvMsg(m any) error {
+	if err := s.ClientStream.RecvMsg(m); err != nil {
+		return newStatusError(err, s.method, s.request())
+	}
+	return nil
+}
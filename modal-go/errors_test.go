@@ -0,0 +1,163 @@
+package modal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatusErrorTypes(t *testing.T) {
+	t.Parallel()
+
+	const method = "/modal.client.ModalClient/FunctionMap"
+	req := pb.FunctionMapRequest_builder{FunctionId: "fu-123"}.Build()
+
+	for _, tc := range []struct {
+		code     codes.Code
+		sentinel error
+		target   any
+	}{
+		{codes.NotFound, ErrNotFound, &NotFoundError{}},
+		{codes.InvalidArgument, ErrInvalid, &InvalidError{}},
+		{codes.Unauthenticated, ErrAuthentication, &AuthenticationError{}},
+		{codes.PermissionDenied, ErrPermissionDenied, &PermissionDeniedError{}},
+		{codes.ResourceExhausted, ErrRateLimited, &RateLimitError{}},
+		{codes.AlreadyExists, ErrAlreadyExists, &AlreadyExistsError{}},
+		{codes.FailedPrecondition, ErrConflict, &ConflictError{}},
+		{codes.Aborted, ErrConflict, &ConflictError{}},
+		{codes.Unavailable, ErrServiceUnavailable, &ServiceUnavailableError{}},
+	} {
+		t.Run(tc.code.String(), func(t *testing.T) {
+			t.Parallel()
+			g := gomega.NewWithT(t)
+
+			orig := status.Error(tc.code, "something went wrong")
+			err := newStatusError(orig, method, req)
+			g.Expect(errors.Is(err, tc.sentinel)).To(gomega.BeTrue())
+			g.Expect(errors.As(err, tc.target)).To(gomega.BeTrue())
+			g.Expect(errors.Is(err, orig)).To(gomega.BeTrue())
+			g.Expect(status.Code(err)).To(gomega.Equal(tc.code))
+			g.Expect(err.Error()).To(gomega.HaveSuffix(": something went wrong"))
+
+			// Wrapping keeps the error matchable.
+			wrapped := fmt.Errorf("calling function: %w", err)
+			g.Expect(errors.Is(wrapped, tc.sentinel)).To(gomega.BeTrue())
+		})
+	}
+}
+
+func TestStatusErrorDetails(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	req := pb.SandboxWaitRequest_builder{SandboxId: "sb-123", Timeout: 10}.Build()
+	err := newStatusError(status.Error(codes.PermissionDenied, "not allowed"), "/modal.client.ModalClient/SandboxWait", req)
+
+	var permErr PermissionDeniedError
+	g.Expect(errors.As(err, &permErr)).To(gomega.BeTrue())
+	g.Expect(permErr.Exception).To(gomega.Equal("not allowed"))
+	g.Expect(permErr.Method).To(gomega.Equal("/modal.client.ModalClient/SandboxWait"))
+	g.Expect(permErr.ObjectId).To(gomega.Equal("sb-123"))
+
+	// Codes without a type, and errors that are not from gRPC, are returned as they are.
+	canceled := status.Error(codes.Canceled, "canceled")
+	g.Expect(newStatusError(canceled, "/modal.client.ModalClient/SandboxWait", req)).To(gomega.BeIdenticalTo(canceled))
+	g.Expect(newStatusError(ErrClientClosed, "/modal.client.ModalClient/SandboxWait", req)).To(gomega.BeIdenticalTo(ErrClientClosed))
+
+	// Requests without an ID have no object ID.
+	err = newStatusError(status.Error(codes.NotFound, "missing"), "/modal.client.ModalClient/AppGetOrCreate", pb.AppGetOrCreateRequest_builder{AppName: "my-app"}.Build())
+	var notFound NotFoundError
+	g.Expect(errors.As(err, &notFound)).To(gomega.BeTrue())
+	g.Expect(notFound.ObjectId).To(gomega.BeEmpty())
+
+	// Lookups replace the message, but keep the details.
+	notFound = withNotFoundMessage(err, "app 'my-app' not found")
+	g.Expect(notFound.Error()).To(gomega.Equal("NotFoundError: app 'my-app' not found"))
+	g.Expect(notFound.Method).To(gomega.Equal("/modal.client.ModalClient/AppGetOrCreate"))
+	g.Expect(status.Code(notFound)).To(gomega.Equal(codes.NotFound))
+}
+
+func TestErrorsInterceptor(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return status.Error(codes.Unauthenticated, "invalid token")
+	}
+	err := errorsInterceptor()(context.Background(), "/modal.client.ModalClient/QueuePut", pb.QueuePutRequest_builder{QueueId: "qu-123"}.Build(), nil, nil, invoker)
+	g.Expect(err).To(gomega.BeAssignableToTypeOf(AuthenticationError{}))
+	g.Expect(err.(AuthenticationError).ObjectId).To(gomega.Equal("qu-123"))
+}
+
+// errorsStreamTestConn is a connection whose streams fail with NotFound, through
+// errorsStreamInterceptor.
+type errorsStreamTestConn struct {
+	grpc.ClientConnInterface
+}
+
+func (c errorsStreamTestConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return notFoundStream{}, nil
+	}
+	return errorsStreamInterceptor()(ctx, desc, nil, method, streamer, opts...)
+}
+
+type notFoundStream struct {
+	grpc.ClientStream
+}
+
+func (notFoundStream) SendMsg(m any) error { return nil }
+func (notFoundStream) CloseSend() error    { return nil }
+func (notFoundStream) RecvMsg(m any) error { return status.Error(codes.NotFound, "sandbox not found") }
+
+func TestErrorsStreamInterceptor(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	c := newTestClient(g, pb.NewModalClientClient(errorsStreamTestConn{}), ClientOptions{})
+
+	_, err := io.ReadAll(outputStreamSb(context.Background(), c, "sb-123", pb.FileDescriptor_FILE_DESCRIPTOR_STDOUT))
+	g.Expect(err).To(gomega.MatchError(ErrNotFound))
+	var notFound NotFoundError
+	g.Expect(errors.As(err, &notFound)).To(gomega.BeTrue())
+	g.Expect(notFound.Method).To(gomega.Equal("/modal.client.ModalClient/SandboxGetLogs"))
+	g.Expect(notFound.ObjectId).To(gomega.Equal("sb-123"))
+	g.Expect(status.Code(notFound)).To(gomega.Equal(codes.NotFound))
+}
+
+func TestIsRetryable(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	req := pb.QueueLenRequest_builder{QueueId: "qu-123"}.Build()
+	g.Expect(IsRetryable(newStatusError(status.Error(codes.Unavailable, ""), "", req))).To(gomega.BeTrue())
+	g.Expect(IsRetryable(newStatusError(status.Error(codes.ResourceExhausted, ""), "", req))).To(gomega.BeTrue())
+	g.Expect(IsRetryable(status.Error(codes.Internal, ""))).To(gomega.BeTrue())
+	g.Expect(IsRetryable(InternalFailure{Exception: "worker crashed"})).To(gomega.BeTrue())
+
+	g.Expect(IsRetryable(nil)).To(gomega.BeFalse())
+	g.Expect(IsRetryable(newStatusError(status.Error(codes.NotFound, ""), "", req))).To(gomega.BeFalse())
+	g.Expect(IsRetryable(newStatusError(status.Error(codes.Unauthenticated, ""), "", req))).To(gomega.BeFalse())
+	g.Expect(IsRetryable(context.Canceled)).To(gomega.BeFalse())
+	g.Expect(IsRetryable(errors.New("unexpected"))).To(gomega.BeFalse())
+	g.Expect(IsRetryable(QueueFullError{Exception: "full"})).To(gomega.BeFalse())
+}
+
+func TestErrorSentinels(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	g.Expect(errors.Is(QueueEmptyError{Exception: "empty"}, ErrQueueEmpty)).To(gomega.BeTrue())
+	g.Expect(errors.Is(QueueEmptyError{Exception: "empty"}, ErrQueueFull)).To(gomega.BeFalse())
+	g.Expect(errors.Is(FunctionTimeoutError{Exception: "timeout"}, ErrFunctionTimeout)).To(gomega.BeTrue())
+	g.Expect(errors.Is(fmt.Errorf("wrapped: %w", SandboxTimeoutError{}), ErrSandboxTimeout)).To(gomega.BeTrue())
+	g.Expect(errors.Is(NotFoundError{Exception: "missing"}, ErrNotFound)).To(gomega.BeTrue())
+}
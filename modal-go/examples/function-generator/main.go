@@ -0,0 +1,27 @@
+// This example calls a generator function defined in `libmodal_test_support.py`,
+// and prints each value as it is produced.
+
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/modal-labs/libmodal/modal-go"
+)
+
+func main() {
+	ctx := context.Background()
+
+	countTo, err := modal.FunctionLookup(ctx, "libmodal-test-support", "count_to", nil)
+	if err != nil {
+		log.Fatalf("Failed to lookup function: %v", err)
+	}
+
+	for value, err := range countTo.RemoteGen(ctx, []any{10}, nil) {
+		if err != nil {
+			log.Fatalf("Failed to call generator: %v", err)
+		}
+		log.Println("Value:", value)
+	}
+}
@@ -0,0 +1,35 @@
+// This example maps a function defined in `libmodal_test_support.py` over many
+// inputs, and prints the outputs in the order of the inputs.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/modal-labs/libmodal/modal-go"
+)
+
+func main() {
+	ctx := context.Background()
+
+	echo, err := modal.FunctionLookup(ctx, "libmodal-test-support", "echo_string", nil)
+	if err != nil {
+		log.Fatalf("Failed to lookup function: %v", err)
+	}
+
+	inputs := func(yield func([]any) bool) {
+		for i := range 100 {
+			if !yield([]any{fmt.Sprintf("input %d", i)}) {
+				return
+			}
+		}
+	}
+	for ret, err := range echo.Map(ctx, inputs, modal.MapOptions{}) {
+		if err != nil {
+			log.Fatalf("Failed to map function: %v", err)
+		}
+		log.Println("Response:", ret)
+	}
+}
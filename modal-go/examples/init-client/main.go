@@ -23,12 +23,16 @@ func main() {
 		log.Fatal("CUSTOM_MODAL_SECRET environment variable not set")
 	}
 
-	modal.InitializeClient(modal.ClientOptions{
+	mc, err := modal.NewClient(modal.ClientOptions{
 		TokenId:     modal_id,
 		TokenSecret: modal_secret,
 	})
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
+	defer mc.Close(ctx)
 
-	echo, err := modal.FunctionLookup(ctx, "libmodal-test-support", "echo_string", nil)
+	echo, err := mc.FunctionLookup(ctx, "libmodal-test-support", "echo_string", nil)
 	if err != nil {
 		log.Fatalf("Failed to lookup function: %v", err)
 	}
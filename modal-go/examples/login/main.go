@@ -0,0 +1,27 @@
+// This example creates a new token in the browser and saves it as the active profile.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/modal-labs/libmodal/modal-go"
+)
+
+func main() {
+	ctx := context.Background()
+
+	result, err := modal.Login(ctx, modal.LoginOptions{
+		OnURL: func(url, code string) {
+			fmt.Printf("Open %s in your browser, and check that the code is %s.\n", url, code)
+		},
+		Profile:  "default",
+		Activate: true,
+	})
+	if err != nil {
+		log.Fatalf("Failed to log in: %v", err)
+	}
+	fmt.Printf("Logged in to workspace %s, token saved to %s\n", result.WorkspaceUsername, result.ConfigPath)
+}
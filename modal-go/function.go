@@ -3,18 +3,13 @@ package modal
 // Function calls and invocations, to be used with Modal Functions.
 
 import (
-	"bytes"
 	"context"
-	"crypto/md5"
-	"crypto/sha256"
-	"encoding/base64"
-	"errors"
 	"fmt"
-	"net/http"
+	"io"
 	"time"
 
-	pickle "github.com/kisielk/og-rek"
 	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -52,28 +47,39 @@ type Function struct {
 	MethodName    *string // used for class methods
 	inputPlaneUrl string  // if empty, use control plane
 	webURL        string  // web URL if this function is a web endpoint
-	ctx           context.Context
+	client        *Client
+	retryPolicy   *RetryPolicy // overrides the client's retry policy for calls, if set
+	codec         Codec        // overrides the client's codec, if set
 }
 
-// FunctionLookup looks up an existing Function.
+// FunctionLookup looks up an existing Function, using the default client.
 func FunctionLookup(ctx context.Context, appName string, name string, options *LookupOptions) (*Function, error) {
+	c, err := getDefaultClient()
+	if err != nil {
+		return nil, err
+	}
+	return c.FunctionLookup(ctx, appName, name, options)
+}
+
+// FunctionLookup looks up an existing Function.
+func (c *Client) FunctionLookup(ctx context.Context, appName string, name string, options *LookupOptions) (*Function, error) {
 	if options == nil {
 		options = &LookupOptions{}
 	}
 	var err error
-	ctx, err = clientContext(ctx)
+	ctx, err = c.clientContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := client.FunctionGet(ctx, pb.FunctionGetRequest_builder{
+	resp, err := c.cpClient.FunctionGet(ctx, pb.FunctionGetRequest_builder{
 		AppName:         appName,
 		ObjectTag:       name,
-		EnvironmentName: environmentName(options.Environment),
+		EnvironmentName: c.environmentName(options.Environment),
 	}.Build())
 
-	if status, ok := status.FromError(err); ok && status.Code() == codes.NotFound {
-		return nil, NotFoundError{fmt.Sprintf("function '%s/%s' not found", appName, name)}
+	if status.Code(err) == codes.NotFound {
+		return nil, withNotFoundMessage(err, fmt.Sprintf("function '%s/%s' not found", appName, name))
 	}
 	if err != nil {
 		return nil, err
@@ -87,114 +93,183 @@ func FunctionLookup(ctx context.Context, appName string, name string, options *L
 		}
 		webURL = meta.GetWebUrl()
 	}
-	return &Function{FunctionId: resp.GetFunctionId(), inputPlaneUrl: inputPlaneUrl, webURL: webURL, ctx: ctx}, nil
+	return &Function{FunctionId: resp.GetFunctionId(), inputPlaneUrl: inputPlaneUrl, webURL: webURL, client: c}, nil
 }
 
-// Serialize Go data types to the Python pickle format.
-func pickleSerialize(v any) (bytes.Buffer, error) {
-	var inputBuffer bytes.Buffer
-
-	e := pickle.NewEncoder(&inputBuffer)
-	err := e.Encode(v)
-
-	if err != nil {
-		return bytes.Buffer{}, fmt.Errorf("error pickling data: %w", err)
-	}
-	return inputBuffer, nil
+// WithRetryPolicy returns a copy of the Function whose Remote and Spawn calls
+// retry failed RPCs according to policy, instead of the client's RetryPolicy.
+// Zero-valued fields of policy are inherited from the client's policy.
+func (f *Function) WithRetryPolicy(policy RetryPolicy) *Function {
+	fc := *f
+	fc.retryPolicy = &policy
+	return &fc
 }
 
-// Deserialize from Python pickle into Go basic types.
-func pickleDeserialize(buffer []byte) (any, error) {
-	decoder := pickle.NewDecoder(bytes.NewReader(buffer))
-	result, err := decoder.Decode()
-	if err != nil {
-		return nil, fmt.Errorf("error unpickling data: %w", err)
-	}
-	return result, nil
+// WithCodec returns a copy of the Function that encodes its arguments and
+// decodes its results with codec, instead of the client's Codec. Arguments can
+// only be encoded by codecs with DataFormatPickle for now: calls with other
+// codecs fail with an InvalidError.
+func (f *Function) WithCodec(codec Codec) *Function {
+	fc := *f
+	fc.codec = codec
+	return &fc
 }
 
 // Serializes inputs, make a function call and return its ID
-func (f *Function) createInput(args []any, kwargs map[string]any) (*pb.FunctionInput, error) {
-	payload, err := pickleSerialize(pickle.Tuple{args, kwargs})
+func (f *Function) createInput(ctx context.Context, client *Client, args []any, kwargs map[string]any) (*pb.FunctionInput, error) {
+	if args == nil {
+		args = []any{}
+	}
+	if kwargs == nil {
+		kwargs = map[string]any{}
+	}
+	codec := codecOrDefault(f.codec, client)
+	payload, closePayload, err := encodeInput(codec, args, kwargs)
 	if err != nil {
 		return nil, err
 	}
+	defer closePayload()
 
-	argsBytes := payload.Bytes()
+	var argsBytes []byte
 	var argsBlobId *string
-	if payload.Len() > maxObjectSizeBytes {
-		blobId, err := blobUpload(f.ctx, argsBytes)
+	if payload.Size() > int64(maxObjectSizeBytes) {
+		blobId, err := blobUpload(ctx, client, payload)
 		if err != nil {
 			return nil, err
 		}
-		argsBytes = nil
 		argsBlobId = &blobId
+	} else if argsBytes, err = io.ReadAll(payload); err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
 	}
 
 	return pb.FunctionInput_builder{
 		Args:       argsBytes,
 		ArgsBlobId: argsBlobId,
-		DataFormat: pb.DataFormat_DATA_FORMAT_PICKLE,
+		DataFormat: pb.DataFormat(codec.DataFormat()),
 		MethodName: f.MethodName,
 	}.Build(), nil
 }
 
-// Remote executes a single input on a remote Function.
+// spanAttributes returns the tracing attributes identifying the Function.
+func (f *Function) spanAttributes() []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attribute.String("modal.function_id", f.FunctionId)}
+	if f.MethodName != nil {
+		attrs = append(attrs, attribute.String("modal.method_name", *f.MethodName))
+	}
+	return attrs
+}
+
+// Remote executes a single input on a remote Function. It is RemoteContext with
+// context.Background().
 func (f *Function) Remote(args []any, kwargs map[string]any) (any, error) {
-	input, err := f.createInput(args, kwargs)
+	return f.RemoteContext(context.Background(), args, kwargs)
+}
+
+// RemoteContext executes a single input on a remote Function. The call is
+// cancelled when ctx is done.
+func (f *Function) RemoteContext(ctx context.Context, args []any, kwargs map[string]any) (any, error) {
+	client, err := clientOrDefault(f.client)
 	if err != nil {
 		return nil, err
 	}
-	invocation, err := f.createRemoteInvocation(input)
+	ctx, span := client.startSpan(ctx, "Function.Remote", f.spanAttributes()...)
+	output, err := f.remote(ctx, client, args, kwargs)
+	endSpan(span, err)
+	return output, err
+}
+
+func (f *Function) remote(ctx context.Context, client *Client, args []any, kwargs map[string]any) (any, error) {
+	ctx, err := client.clientContext(ctx)
 	if err != nil {
 		return nil, err
 	}
-	// TODO(ryan): Add tests for retries.
-	retryCount := uint32(0)
-	for {
-		output, err := invocation.awaitOutput(nil)
-		if err == nil {
-			return output, nil
-		}
-		if errors.As(err, &InternalFailure{}) && retryCount <= maxSystemRetries {
-			if retryErr := invocation.retry(retryCount); retryErr != nil {
-				return nil, retryErr
-			}
-			retryCount++
-			continue
-		}
+	input, err := f.createInput(ctx, client, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	invocation, err := f.createRemoteInvocation(ctx, client, input)
+	if err != nil {
 		return nil, err
 	}
+	return awaitOutputWithRetries(invocation)
 }
 
 // createRemoteInvocation creates an Invocation using either the input plane or control plane.
-func (f *Function) createRemoteInvocation(input *pb.FunctionInput) (invocation, error) {
+func (f *Function) createRemoteInvocation(ctx context.Context, client *Client, input *pb.FunctionInput) (invocation, error) {
+	callOpts := retryOptions(f.retryPolicy)
+	codec := codecOrDefault(f.codec, client)
 	if f.inputPlaneUrl != "" {
-		return createInputPlaneInvocation(f.ctx, f.inputPlaneUrl, f.FunctionId, input)
+		return createInputPlaneInvocation(ctx, client, f.inputPlaneUrl, f.FunctionId, input, codec, callOpts...)
 	}
-	return createControlPlaneInvocation(f.ctx, f.FunctionId, input, pb.FunctionCallInvocationType_FUNCTION_CALL_INVOCATION_TYPE_SYNC)
+	return createControlPlaneInvocation(ctx, client, f.FunctionId, input, pb.FunctionCallInvocationType_FUNCTION_CALL_INVOCATION_TYPE_SYNC, codec, callOpts...)
 }
 
-// Spawn starts running a single input on a remote function.
+// Spawn starts running a single input on a remote function. It is SpawnContext
+// with context.Background().
 func (f *Function) Spawn(args []any, kwargs map[string]any) (*FunctionCall, error) {
-	input, err := f.createInput(args, kwargs)
+	return f.SpawnContext(context.Background(), args, kwargs)
+}
+
+// SpawnContext starts running a single input on a remote function. Only starting
+// the call is bound to ctx, and the returned FunctionCall can outlive it.
+func (f *Function) SpawnContext(ctx context.Context, args []any, kwargs map[string]any) (*FunctionCall, error) {
+	client, err := clientOrDefault(f.client)
 	if err != nil {
 		return nil, err
 	}
-	invocation, err := createControlPlaneInvocation(f.ctx, f.FunctionId, input, pb.FunctionCallInvocationType_FUNCTION_CALL_INVOCATION_TYPE_SYNC)
+	ctx, span := client.startSpan(ctx, "Function.Spawn", f.spanAttributes()...)
+	functionCall, err := f.spawn(ctx, client, args, kwargs)
+	endSpan(span, err)
+	return functionCall, err
+}
+
+func (f *Function) spawn(ctx context.Context, client *Client, args []any, kwargs map[string]any) (*FunctionCall, error) {
+	ctx, err := client.clientContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	input, err := f.createInput(ctx, client, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	codec := codecOrDefault(f.codec, client)
+	if f.inputPlaneUrl != "" {
+		invocation, err := createInputPlaneInvocation(ctx, client, f.inputPlaneUrl, f.FunctionId, input, codec, retryOptions(f.retryPolicy)...)
+		if err != nil {
+			return nil, err
+		}
+		call := &inputPlaneCall{InputPlaneUrl: f.inputPlaneUrl, FunctionId: f.FunctionId, AttemptToken: invocation.attemptToken}
+		return &FunctionCall{FunctionCallId: call.id(), inputPlane: call, client: client, codec: codec}, nil
+	}
+	invocation, err := createControlPlaneInvocation(ctx, client, f.FunctionId, input, pb.FunctionCallInvocationType_FUNCTION_CALL_INVOCATION_TYPE_SYNC, codec, retryOptions(f.retryPolicy)...)
 	if err != nil {
 		return nil, err
 	}
 	functionCall := FunctionCall{
 		FunctionCallId: invocation.FunctionCallId,
-		ctx:            f.ctx,
+		client:         client,
+		codec:          codec,
 	}
 	return &functionCall, nil
 }
 
 // GetCurrentStats returns a FunctionStats object with statistics about the Function.
+// It is GetCurrentStatsContext with context.Background().
 func (f *Function) GetCurrentStats() (*FunctionStats, error) {
-	resp, err := client.FunctionGetCurrentStats(f.ctx, pb.FunctionGetCurrentStatsRequest_builder{
+	return f.GetCurrentStatsContext(context.Background())
+}
+
+// GetCurrentStatsContext returns a FunctionStats object with statistics about the Function.
+func (f *Function) GetCurrentStatsContext(ctx context.Context) (*FunctionStats, error) {
+	client, err := clientOrDefault(f.client)
+	if err != nil {
+		return nil, err
+	}
+	ctx, err = client.clientContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.cpClient.FunctionGetCurrentStats(ctx, pb.FunctionGetCurrentStatsRequest_builder{
 		FunctionId: f.FunctionId,
 	}.Build())
 	if err != nil {
@@ -208,7 +283,21 @@ func (f *Function) GetCurrentStats() (*FunctionStats, error) {
 }
 
 // UpdateAutoscaler overrides the current autoscaler behavior for this Function.
+// It is UpdateAutoscalerContext with context.Background().
 func (f *Function) UpdateAutoscaler(opts UpdateAutoscalerOptions) error {
+	return f.UpdateAutoscalerContext(context.Background(), opts)
+}
+
+// UpdateAutoscalerContext overrides the current autoscaler behavior for this Function.
+func (f *Function) UpdateAutoscalerContext(ctx context.Context, opts UpdateAutoscalerOptions) error {
+	client, err := clientOrDefault(f.client)
+	if err != nil {
+		return err
+	}
+	ctx, err = client.clientContext(ctx)
+	if err != nil {
+		return err
+	}
 	settings := pb.AutoscalerSettings_builder{
 		MinContainers:    opts.MinContainers,
 		MaxContainers:    opts.MaxContainers,
@@ -216,7 +305,7 @@ func (f *Function) UpdateAutoscaler(opts UpdateAutoscalerOptions) error {
 		ScaledownWindow:  opts.ScaledownWindow,
 	}.Build()
 
-	_, err := client.FunctionUpdateSchedulingParams(f.ctx, pb.FunctionUpdateSchedulingParamsRequest_builder{
+	_, err = client.cpClient.FunctionUpdateSchedulingParams(ctx, pb.FunctionUpdateSchedulingParamsRequest_builder{
 		FunctionId:           f.FunctionId,
 		WarmPoolSizeOverride: 0, // Deprecated field, always set to 0
 		Settings:             settings,
@@ -230,46 +319,3 @@ func (f *Function) UpdateAutoscaler(opts UpdateAutoscalerOptions) error {
 func (f *Function) GetWebURL() string {
 	return f.webURL
 }
-
-// blobUpload uploads a blob to storage and returns its ID.
-func blobUpload(ctx context.Context, data []byte) (string, error) {
-	md5sum := md5.Sum(data)
-	sha256sum := sha256.Sum256(data)
-	contentMd5 := base64.StdEncoding.EncodeToString(md5sum[:])
-	contentSha256 := base64.StdEncoding.EncodeToString(sha256sum[:])
-
-	resp, err := client.BlobCreate(ctx, pb.BlobCreateRequest_builder{
-		ContentMd5:          contentMd5,
-		ContentSha256Base64: contentSha256,
-		ContentLength:       int64(len(data)),
-	}.Build())
-	if err != nil {
-		return "", fmt.Errorf("failed to create blob: %w", err)
-	}
-
-	switch resp.WhichUploadTypeOneof() {
-	case pb.BlobCreateResponse_Multipart_case:
-		return "", fmt.Errorf("function input size exceeds multipart upload threshold, unsupported by this SDK version")
-
-	case pb.BlobCreateResponse_UploadUrl_case:
-		req, err := http.NewRequest("PUT", resp.GetUploadUrl(), bytes.NewReader(data))
-		if err != nil {
-			return "", fmt.Errorf("failed to create upload request: %w", err)
-		}
-		req.Header.Set("Content-Type", "application/octet-stream")
-		req.Header.Set("Content-MD5", contentMd5)
-		uploadResp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return "", fmt.Errorf("failed to upload blob: %w", err)
-		}
-		defer uploadResp.Body.Close()
-		if uploadResp.StatusCode < 200 || uploadResp.StatusCode >= 300 {
-			return "", fmt.Errorf("failed blob upload: %s", uploadResp.Status)
-		}
-		// Skip client-side ETag header validation for now (MD5 checksum).
-		return resp.GetBlobId(), nil
-
-	default:
-		return "", fmt.Errorf("missing upload URL in BlobCreate response")
-	}
-}
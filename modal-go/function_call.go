@@ -2,7 +2,10 @@ package modal
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
@@ -11,21 +14,70 @@ import (
 // FunctionCall references a Modal Function Call. Function Calls are
 // Function invocations with a given input. They can be consumed
 // asynchronously (see Get()) or cancelled (see Cancel()).
+//
+// Calls of Functions on the input plane don't have an ID of their own, so their
+// FunctionCallId encodes the input plane and attempt that they belong to.
 type FunctionCall struct {
 	FunctionCallId string
-	ctx            context.Context
+	inputPlane     *inputPlaneCall // set if the call was started on the input plane
+	client         *Client
+	codec          Codec // decodes the output, the client's codec if nil
 }
 
-// FunctionCallFromId looks up a FunctionCall by ID.
+// inputPlaneCallIdPrefix is the prefix of the ID of a FunctionCall on the input
+// plane, which is followed by its encoded inputPlaneCall.
+const inputPlaneCallIdPrefix = "ip-"
+
+// inputPlaneCall identifies an attempt of a Function call on the input plane.
+type inputPlaneCall struct {
+	InputPlaneUrl string `json:"input_plane_url"`
+	FunctionId    string `json:"function_id"`
+	AttemptToken  string `json:"attempt_token"`
+}
+
+// id returns the FunctionCallId of the call.
+func (c *inputPlaneCall) id() string {
+	data, _ := json.Marshal(c) // can't fail for a struct of strings
+	return inputPlaneCallIdPrefix + base64.RawURLEncoding.EncodeToString(data)
+}
+
+// parseInputPlaneCallId decodes the ID of a FunctionCall on the input plane.
+func parseInputPlaneCallId(functionCallId string) (*inputPlaneCall, error) {
+	invalid := InvalidError{Exception: fmt.Sprintf("invalid input plane function call ID '%s'", functionCallId)}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(functionCallId, inputPlaneCallIdPrefix))
+	if err != nil {
+		return nil, invalid
+	}
+	var c inputPlaneCall
+	if err := json.Unmarshal(data, &c); err != nil || c.InputPlaneUrl == "" || c.FunctionId == "" || c.AttemptToken == "" {
+		return nil, invalid
+	}
+	return &c, nil
+}
+
+// FunctionCallFromId looks up a FunctionCall by ID, using the default client.
 func FunctionCallFromId(ctx context.Context, functionCallId string) (*FunctionCall, error) {
-	var err error
-	ctx, err = clientContext(ctx)
+	c, err := getDefaultClient()
 	if err != nil {
 		return nil, err
 	}
+	return c.FunctionCallFromId(ctx, functionCallId)
+}
+
+// FunctionCallFromId looks up a FunctionCall by ID.
+func (c *Client) FunctionCallFromId(ctx context.Context, functionCallId string) (*FunctionCall, error) {
+	if c.isClosed() {
+		return nil, ErrClientClosed
+	}
 	functionCall := FunctionCall{
 		FunctionCallId: functionCallId,
-		ctx:            ctx,
+		client:         c,
+	}
+	if strings.HasPrefix(functionCallId, inputPlaneCallIdPrefix) {
+		var err error
+		if functionCall.inputPlane, err = parseInputPlaneCallId(functionCallId); err != nil {
+			return nil, err
+		}
 	}
 	return &functionCall, nil
 }
@@ -40,12 +92,32 @@ type FunctionCallGetOptions struct {
 
 // Get waits for the output of a FunctionCall.
 // If timeout > 0, the operation will be cancelled after the specified duration.
+// It is GetContext with context.Background().
 func (fc *FunctionCall) Get(options *FunctionCallGetOptions) (any, error) {
+	return fc.GetContext(context.Background(), options)
+}
+
+// GetContext waits for the output of a FunctionCall, until ctx is done.
+func (fc *FunctionCall) GetContext(ctx context.Context, options *FunctionCallGetOptions) (any, error) {
 	if options == nil {
 		options = &FunctionCallGetOptions{}
 	}
-	ctx := fc.ctx
-	invocation := controlPlaneInvocationFromFunctionCallId(ctx, fc.FunctionCallId)
+	client, err := clientOrDefault(fc.client)
+	if err != nil {
+		return nil, err
+	}
+	ctx, err = client.clientContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if fc.inputPlane != nil {
+		invocation, err := inputPlaneInvocationFromCall(ctx, client, fc.inputPlane, codecOrDefault(fc.codec, client))
+		if err != nil {
+			return nil, err
+		}
+		return invocation.awaitOutput(options.Timeout)
+	}
+	invocation := controlPlaneInvocationFromFunctionCallId(ctx, client, fc.FunctionCallId, codecOrDefault(fc.codec, client))
 	return invocation.awaitOutput(options.Timeout)
 }
 
@@ -54,12 +126,32 @@ type FunctionCallCancelOptions struct {
 	TerminateContainers bool
 }
 
-// Cancel cancels a FunctionCall.
+// Cancel cancels a FunctionCall. It is CancelContext with context.Background(),
+// and returns an InvalidError for calls on the input plane.
 func (fc *FunctionCall) Cancel(options *FunctionCallCancelOptions) error {
+	return fc.CancelContext(context.Background(), options)
+}
+
+// CancelContext cancels a FunctionCall.
+//
+// Calls on the input plane can't be cancelled, since the input plane API has no
+// way to cancel an attempt, and an InvalidError is returned for them.
+func (fc *FunctionCall) CancelContext(ctx context.Context, options *FunctionCallCancelOptions) error {
 	if options == nil {
 		options = &FunctionCallCancelOptions{}
 	}
-	_, err := client.FunctionCallCancel(fc.ctx, pb.FunctionCallCancelRequest_builder{
+	if fc.inputPlane != nil {
+		return InvalidError{Exception: fmt.Sprintf("function call '%s' of Function %s is on the input plane, which doesn't support cancellation", fc.FunctionCallId, fc.inputPlane.FunctionId)}
+	}
+	client, err := clientOrDefault(fc.client)
+	if err != nil {
+		return err
+	}
+	ctx, err = client.clientContext(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.cpClient.FunctionCallCancel(ctx, pb.FunctionCallCancelRequest_builder{
 		FunctionCallId:      fc.FunctionCallId,
 		TerminateContainers: options.TerminateContainers,
 	}.Build())
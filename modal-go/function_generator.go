@@ -0,0 +1,205 @@
+package modal
+
+// Calls of generator Functions, whose values are streamed as they are produced.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"iter"
+
+	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+)
+
+// generatorItem is a value produced by a remote generator, or the error that
+// stopped the stream of values. idle items report that a stream of values, which
+// started after the generator was done, ended without new values.
+type generatorItem struct {
+	value any
+	err   error
+	idle  bool
+}
+
+// RemoteGen calls a generator Function, and yields each value as the remote
+// generator produces it. Iteration stops after the generator returns, or at the
+// first error, which is yielded. If the caller stops iterating early, or ctx is
+// cancelled, the call is cancelled.
+func (f *Function) RemoteGen(ctx context.Context, args []any, kwargs map[string]any) iter.Seq2[any, error] {
+	return func(yield func(any, error) bool) {
+		client, err := clientOrDefault(f.client)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		ctx, span := client.startSpan(ctx, "Function.RemoteGen", f.spanAttributes()...)
+		err = f.remoteGen(ctx, client, args, kwargs, yield)
+		endSpan(span, err)
+	}
+}
+
+// remoteGen runs a generator call, and returns the error that stopped it, if any.
+func (f *Function) remoteGen(ctx context.Context, client *Client, args []any, kwargs map[string]any, yield func(any, error) bool) error {
+	fail := func(err error) error {
+		yield(nil, err)
+		return err
+	}
+	ctx, err := client.clientContext(ctx)
+	if err != nil {
+		return fail(err)
+	}
+	input, err := f.createInput(ctx, client, args, kwargs)
+	if err != nil {
+		return fail(err)
+	}
+	codec := codecOrDefault(f.codec, client)
+	invocation, err := createControlPlaneInvocation(ctx, client, f.FunctionId, input, pb.FunctionCallInvocationType_FUNCTION_CALL_INVOCATION_TYPE_SYNC, codec, retryOptions(f.retryPolicy)...)
+	if err != nil {
+		return fail(err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	finished := false
+	defer func() {
+		if !finished {
+			cancelFunctionCall(ctx, client, invocation.FunctionCallId)
+		}
+	}()
+
+	// The values are streamed while the output of the call, a GeneratorDone with
+	// the number of values, is awaited.
+	items := make(chan generatorItem)
+	generatorDone := make(chan struct{})
+	client.goBackground(ctx, func(ctx context.Context) {
+		defer close(items)
+		streamGeneratorData(ctx, client, invocation.FunctionCallId, codec, generatorDone, items)
+	})
+	outputs := make(chan generatorItem, 1)
+	client.goBackground(ctx, func(ctx context.Context) {
+		invocation.ctx = ctx
+		output, err := awaitOutputWithRetries(invocation)
+		outputs <- generatorItem{value: output, err: err}
+	})
+
+	var received, total uint64
+	haveTotal := false
+	for !haveTotal || received < total {
+		var item generatorItem
+		var ok bool
+		select {
+		case item, ok = <-items:
+			if !ok {
+				if err := ctx.Err(); err != nil {
+					return fail(err)
+				}
+				return fail(fmt.Errorf("generator data stream ended after %d values", received))
+			}
+			// Once the generator is done, a stream without new values means that the
+			// missing values will never arrive.
+			if item.idle {
+				return fail(ExecutionError{Exception: fmt.Sprintf("generator produced %d values, but only %d were received", total, received)})
+			}
+		case item = <-outputs:
+			outputs = nil
+			if item.err == nil {
+				if _, ok := item.value.(*pb.GeneratorDone); !ok {
+					item.err = InvalidError{Exception: fmt.Sprintf("Function %s is not a generator, use Remote instead", f.FunctionId)}
+				}
+			}
+		case <-ctx.Done():
+			return fail(ctx.Err())
+		}
+
+		if item.err != nil {
+			return fail(item.err)
+		}
+		if done, ok := item.value.(*pb.GeneratorDone); ok {
+			total, haveTotal = done.GetItemsTotal(), true
+			close(generatorDone)
+			continue
+		}
+		received++
+		if !yield(item.value, nil) {
+			return nil
+		}
+	}
+	finished = true
+	return nil
+}
+
+// streamGeneratorData sends the values of a generator call to items, as they are
+// produced. The server ends streams routinely, so they are reconnected from the
+// last value received until ctx is done, or an error is sent. generatorDone is
+// closed once the generator is done, after which all its values are available.
+func streamGeneratorData(ctx context.Context, client *Client, functionCallId string, codec Codec, generatorDone <-chan struct{}, items chan<- generatorItem) {
+	send := func(item generatorItem) bool {
+		select {
+		case items <- item:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	var lastIndex uint64
+	retrier := client.newStreamRetrier(ctx, "FunctionCallGetDataOut", "function_call_id", functionCallId)
+	idleDelay := client.streamRetryPolicy.BaseDelay
+	for {
+		afterDone := false
+		select {
+		case <-generatorDone:
+			afterDone = true
+		default:
+		}
+		stream, err := client.cpClient.FunctionCallGetDataOut(ctx, pb.FunctionCallGetDataRequest_builder{
+			FunctionCallId: functionCallId,
+			LastIndex:      lastIndex,
+		}.Build())
+		received := false
+		if err == nil {
+			for {
+				var chunk *pb.DataChunk
+				chunk, err = stream.Recv()
+				if err != nil {
+					break
+				}
+				if chunk.GetIndex() <= lastIndex {
+					continue // already received before reconnecting
+				}
+				received = true
+				data := chunk.GetData()
+				if chunk.HasDataBlobId() {
+					if data, err = blobDownload(ctx, client, chunk.GetDataBlobId()); err != nil {
+						break
+					}
+				}
+				value, err := decodePayload(codec, data, chunk.GetDataFormat())
+				if !send(generatorItem{value: value, err: err}) || err != nil {
+					return
+				}
+				lastIndex = chunk.GetIndex()
+			}
+		}
+		if err == io.EOF {
+			// Streams that end without new values are reconnected after a delay
+			// that backs off up to the policy's MaxDelay, so that an idle generator
+			// doesn't make the client spin.
+			if received {
+				idleDelay = client.streamRetryPolicy.BaseDelay
+				continue
+			}
+			if afterDone && !send(generatorItem{idle: true}) {
+				return
+			}
+			if sleepCtx(ctx, idleDelay) != nil {
+				return
+			}
+			idleDelay = client.streamRetryPolicy.nextDelay(idleDelay)
+			continue
+		}
+		if !retrier.retry(err) {
+			send(generatorItem{err: fmt.Errorf("error getting generator data: %w", err)})
+			return
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package modal
+
+import (
+	"context"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// generatorTestModalClient runs a generator call whose values are the chunks of
+// streams, one stream for each connection to FunctionCallGetDataOut.
+type generatorTestModalClient struct {
+	pb.ModalClientClient
+	streams [][]*pb.DataChunk
+	ended   bool // streams other than the last one end, instead of being interrupted
+	idle    bool // connections after the last stream end at once, instead of blocking
+	output  *pb.FunctionGetOutputsItem
+	done    chan struct{} // closed when the output can be returned
+
+	mu        sync.Mutex
+	lastIdxs  []uint64
+	cancelled []string
+}
+
+func (f *generatorTestModalClient) FunctionMap(ctx context.Context, in *pb.FunctionMapRequest, opts ...grpc.CallOption) (*pb.FunctionMapResponse, error) {
+	return pb.FunctionMapResponse_builder{
+		FunctionCallId:  "fc-gen",
+		PipelinedInputs: []*pb.FunctionPutInputsResponseItem{pb.FunctionPutInputsResponseItem_builder{InputJwt: "jwt"}.Build()},
+	}.Build(), nil
+}
+
+func (f *generatorTestModalClient) FunctionGetOutputs(ctx context.Context, in *pb.FunctionGetOutputsRequest, opts ...grpc.CallOption) (*pb.FunctionGetOutputsResponse, error) {
+	select {
+	case <-f.done:
+		return pb.FunctionGetOutputsResponse_builder{Outputs: []*pb.FunctionGetOutputsItem{f.output}}.Build(), nil
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+func (f *generatorTestModalClient) FunctionCallGetDataOut(ctx context.Context, in *pb.FunctionCallGetDataRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[pb.DataChunk], error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastIdxs = append(f.lastIdxs, in.GetLastIndex())
+	if len(f.streams) == 0 {
+		return &chunkStream{ctx: ctx, block: !f.idle}, nil
+	}
+	chunks := f.streams[0]
+	f.streams = f.streams[1:]
+	return &chunkStream{ctx: ctx, chunks: chunks, interrupted: !f.ended && len(f.streams) > 0}, nil
+}
+
+// lastIndexes returns the LastIndex of each connection to FunctionCallGetDataOut.
+func (f *generatorTestModalClient) lastIndexes() []uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.lastIdxs)
+}
+
+func (f *generatorTestModalClient) FunctionCallCancel(ctx context.Context, in *pb.FunctionCallCancelRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cancelled = append(f.cancelled, in.GetFunctionCallId())
+	return &emptypb.Empty{}, nil
+}
+
+type chunkStream struct {
+	grpc.ClientStream
+	ctx         context.Context
+	chunks      []*pb.DataChunk
+	interrupted bool // ends with an Unavailable error instead of EOF
+	block       bool // blocks until ctx is done, instead of ending
+}
+
+func (s *chunkStream) Recv() (*pb.DataChunk, error) {
+	if len(s.chunks) > 0 {
+		chunk := s.chunks[0]
+		s.chunks = s.chunks[1:]
+		return chunk, nil
+	}
+	if s.block {
+		<-s.ctx.Done()
+		return nil, status.FromContextError(s.ctx.Err()).Err()
+	}
+	if s.interrupted {
+		return nil, status.Error(codes.Unavailable, "stream reset")
+	}
+	return nil, io.EOF
+}
+
+func pickledChunk(g *gomega.WithT, index uint64, v any) *pb.DataChunk {
+	buf, err := pickleSerialize(v)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	return pb.DataChunk_builder{Index: index, Data: buf.Bytes(), DataFormat: pb.DataFormat_DATA_FORMAT_PICKLE}.Build()
+}
+
+func generatorDoneOutput(g *gomega.WithT, itemsTotal uint64) *pb.FunctionGetOutputsItem {
+	data, err := proto.Marshal(pb.GeneratorDone_builder{ItemsTotal: itemsTotal}.Build())
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	return pb.FunctionGetOutputsItem_builder{
+		Result:     pb.GenericResult_builder{Status: pb.GenericResult_GENERIC_STATUS_SUCCESS, Data: data}.Build(),
+		DataFormat: pb.DataFormat_DATA_FORMAT_GENERATOR_DONE,
+	}.Build()
+}
+
+func newGeneratorTestFunction(g *gomega.WithT, fake *generatorTestModalClient) *Function {
+	c := newTestClient(g, fake, ClientOptions{StreamRetryPolicy: &RetryPolicy{MaxAttempts: 3}})
+	return &Function{FunctionId: "fu-gen", client: c}
+}
+
+func TestFunctionRemoteGen(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	// The first stream is interrupted after a value, and the stream that replaces
+	// it repeats the value before the next ones.
+	fake := &generatorTestModalClient{
+		streams: [][]*pb.DataChunk{
+			{pickledChunk(g, 1, "a")},
+			{pickledChunk(g, 1, "a"), pickledChunk(g, 2, "b"), pickledChunk(g, 3, "c")},
+		},
+		output: generatorDoneOutput(g, 3),
+		done:   make(chan struct{}),
+	}
+	close(fake.done)
+	f := newGeneratorTestFunction(g, fake)
+
+	var values []any
+	for v, err := range f.RemoteGen(context.Background(), nil, nil) {
+		g.Expect(err).ShouldNot(gomega.HaveOccurred())
+		values = append(values, v)
+	}
+	g.Expect(values).To(gomega.Equal([]any{"a", "b", "c"}))
+	// The stream may be reconnected once more after the last value, until the
+	// call is over.
+	g.Expect(fake.lastIndexes()).To(gomega.Or(gomega.Equal([]uint64{0, 1}), gomega.Equal([]uint64{0, 1, 3})))
+	g.Expect(fake.cancelled).To(gomega.BeEmpty())
+}
+
+func TestFunctionRemoteGenReconnectsAfterEnd(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	// The server ends streams routinely, and the values after the end of a stream
+	// are received by reconnecting.
+	fake := &generatorTestModalClient{
+		streams: [][]*pb.DataChunk{
+			{pickledChunk(g, 1, "a")},
+			{pickledChunk(g, 2, "b"), pickledChunk(g, 3, "c")},
+		},
+		ended:  true,
+		output: generatorDoneOutput(g, 3),
+		done:   make(chan struct{}),
+	}
+	close(fake.done)
+	f := newGeneratorTestFunction(g, fake)
+
+	var values []any
+	for v, err := range f.RemoteGen(context.Background(), nil, nil) {
+		g.Expect(err).ShouldNot(gomega.HaveOccurred())
+		values = append(values, v)
+	}
+	g.Expect(values).To(gomega.Equal([]any{"a", "b", "c"}))
+	// The stream may be reconnected once more after the last value, until the
+	// call is over.
+	g.Expect(fake.lastIndexes()).To(gomega.Or(gomega.Equal([]uint64{0, 1}), gomega.Equal([]uint64{0, 1, 3})))
+}
+
+func TestFunctionRemoteGenMissingValues(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	// The generator is done after 3 values, but the server only has 2.
+	fake := &generatorTestModalClient{
+		streams: [][]*pb.DataChunk{{pickledChunk(g, 1, "a"), pickledChunk(g, 2, "b")}},
+		idle:    true,
+		output:  generatorDoneOutput(g, 3),
+		done:    make(chan struct{}),
+	}
+	close(fake.done)
+	f := newGeneratorTestFunction(g, fake)
+
+	var values []any
+	var errs []error
+	for v, err := range f.RemoteGen(context.Background(), nil, nil) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		values = append(values, v)
+	}
+	g.Expect(values).To(gomega.Equal([]any{"a", "b"}))
+	g.Expect(errs).To(gomega.HaveLen(1))
+	g.Expect(errs[0]).To(gomega.MatchError(ErrExecution))
+	g.Expect(errs[0]).To(gomega.MatchError(gomega.ContainSubstring("generator produced 3 values, but only 2 were received")))
+}
+
+func TestFunctionRemoteGenStopsEarly(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	fake := &generatorTestModalClient{
+		streams: [][]*pb.DataChunk{{pickledChunk(g, 1, int64(1)), pickledChunk(g, 2, int64(2))}},
+		done:    make(chan struct{}), // the generator never finishes
+	}
+	f := newGeneratorTestFunction(g, fake)
+
+	for v, err := range f.RemoteGen(context.Background(), nil, nil) {
+		g.Expect(err).ShouldNot(gomega.HaveOccurred())
+		g.Expect(v).To(gomega.Equal(int64(1)))
+		break
+	}
+	g.Expect(fake.cancelled).To(gomega.Equal([]string{"fc-gen"}))
+}
+
+func TestFunctionRemoteGenError(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	fake := &generatorTestModalClient{
+		output: pb.FunctionGetOutputsItem_builder{
+			Result: pb.GenericResult_builder{
+				Status:    pb.GenericResult_GENERIC_STATUS_FAILURE,
+				Exception: "ValueError('bad input')",
+			}.Build(),
+			DataFormat: pb.DataFormat_DATA_FORMAT_PICKLE,
+		}.Build(),
+		done: make(chan struct{}),
+	}
+	close(fake.done)
+	f := newGeneratorTestFunction(g, fake)
+
+	var errs []error
+	for v, err := range f.RemoteGen(context.Background(), nil, nil) {
+		g.Expect(v).To(gomega.BeNil())
+		errs = append(errs, err)
+	}
+	g.Expect(errs).To(gomega.HaveLen(1))
+	g.Expect(errs[0]).To(gomega.MatchError(RemoteException("ValueError")))
+}
+
+func TestStreamGeneratorDataIdleBackoff(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	// Streams of an idle generator end at once, and are reconnected with a delay
+	// that backs off: 10ms, 20ms, 40ms, 80ms and 160ms over 300ms, instead of
+	// every 10ms.
+	fake := &generatorTestModalClient{idle: true}
+	c := newTestClient(g, fake, ClientOptions{})
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	streamGeneratorData(ctx, c, "fc-gen", PickleCodec{}, make(chan struct{}), make(chan generatorItem))
+	g.Expect(len(fake.lastIndexes())).To(gomega.BeNumerically("<=", 6))
+}
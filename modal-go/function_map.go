@@ -0,0 +1,450 @@
+package modal
+
+// Mapping a Function over many inputs, with batched uploads and streaming outputs.
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"sync"
+	"time"
+
+	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// From: modal/_utils/function_utils.py
+const mapInvocationChunkSize = 49
+
+// Default number of inputs of a map that are uploaded but don't have an output
+// yet, if the server doesn't set a limit.
+const defaultMaxInputsOutstanding = 1000
+
+// Maximum number of outputs fetched by each FunctionGetOutputs call of a map.
+const mapOutputsBatchSize = 1000
+
+// MapOptions are options for Function.Map.
+type MapOptions struct {
+	// Kwargs are keyword arguments passed with every input.
+	Kwargs map[string]any
+	// Unordered yields outputs as they complete, instead of in the order of
+	// their inputs.
+	Unordered bool
+	// ReturnExceptions yields the error of each failed input, and continues with
+	// the other inputs. Otherwise the map stops at the first error.
+	ReturnExceptions bool
+	// BatchSize is the maximum number of inputs uploaded by each RPC. Defaults to 49.
+	BatchSize int
+	// MaxInputsOutstanding is the maximum number of inputs that are uploaded
+	// before their outputs are received. Defaults to the limit of the server.
+	MaxInputsOutstanding int
+}
+
+// mapResult is the output of one input of a map.
+type mapResult struct {
+	idx    int32
+	output any
+	err    error
+}
+
+// mapInput is an input of a map that is waiting for its output.
+type mapInput struct {
+	input      *pb.FunctionInput
+	inputJwt   string
+	put        chan struct{} // closed once FunctionPutInputs returned inputJwt
+	retryCount uint32
+}
+
+// mapInvocation is a map over a Function, whose inputs are uploaded by sendInputs
+// while receiveOutputs fetches their outputs.
+type mapInvocation struct {
+	ctx             context.Context
+	client          *Client
+	function        *Function
+	opts            MapOptions
+	functionCallId  string
+	functionCallJwt string
+	callOpts        []grpc.CallOption
+
+	sem     chan struct{}  // a slot for each input that is outstanding
+	wake    chan struct{}  // signalled when inputs are uploaded, or all were
+	results chan mapResult // outputs, as they complete, closed after the last one
+	errc    chan error     // errors that stop the map
+
+	mu      sync.Mutex
+	pending map[int32]*mapInput // inputs that were sent, and don't have an output yet
+	allSent bool
+}
+
+// Map runs the Function on each input in parallel, where an input is the list of
+// positional arguments of a call. It returns the outputs in the order of the
+// inputs, or as they complete if opts.Unordered is set.
+//
+// Inputs are read and uploaded in batches while outputs are being received, with
+// at most opts.MaxInputsOutstanding inputs waiting for an output. Inputs that
+// fail with an internal error are retried. If the caller stops iterating early,
+// or ctx is cancelled, the remaining inputs are cancelled.
+func (f *Function) Map(ctx context.Context, inputs iter.Seq[[]any], opts MapOptions) iter.Seq2[any, error] {
+	return func(yield func(any, error) bool) {
+		client, err := clientOrDefault(f.client)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		ctx, span := client.startSpan(ctx, "Function.Map", f.spanAttributes()...)
+		err = f.runMap(ctx, client, inputs, opts, yield)
+		endSpan(span, err)
+	}
+}
+
+// runMap runs a map, and returns the error that stopped it, if any.
+func (f *Function) runMap(ctx context.Context, client *Client, inputs iter.Seq[[]any], opts MapOptions, yield func(any, error) bool) error {
+	ctx, err := client.clientContext(ctx)
+	if err != nil {
+		yield(nil, err)
+		return err
+	}
+	m, err := f.startMap(ctx, client, opts)
+	if err != nil {
+		yield(nil, err)
+		return err
+	}
+	// The map is stopped when the caller is done with it, or the client is closed.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(client.closeCtx, cancel)()
+	m.ctx = ctx
+
+	sent := make(chan struct{})
+	client.goBackground(ctx, func(context.Context) {
+		defer close(sent)
+		m.sendInputs(inputs)
+	})
+	client.goBackground(ctx, func(context.Context) { m.receiveOutputs() })
+
+	finished := false
+	defer func() {
+		// The caller's inputs aren't read after Map returns.
+		cancel()
+		<-sent
+		if !finished {
+			cancelFunctionCall(ctx, client, m.functionCallId)
+		}
+	}()
+
+	buffered := map[int32]mapResult{} // outputs that arrive before earlier ones, if ordered
+	next := int32(0)
+	for {
+		var r mapResult
+		var ok bool
+		select {
+		case r, ok = <-m.results:
+			if !ok {
+				finished = true
+				return nil
+			}
+		case err := <-m.errc:
+			yield(nil, err)
+			return err
+		case <-ctx.Done():
+			yield(nil, ctx.Err())
+			return ctx.Err()
+		}
+
+		ready := []mapResult{r}
+		if !opts.Unordered {
+			buffered[r.idx] = r
+			ready = ready[:0]
+			for {
+				r, ok := buffered[next]
+				if !ok {
+					break
+				}
+				delete(buffered, next)
+				ready = append(ready, r)
+				next++
+			}
+		}
+		for _, r := range ready {
+			if r.err != nil && !opts.ReturnExceptions {
+				yield(nil, r.err)
+				return r.err
+			}
+			if !yield(r.output, r.err) {
+				return nil
+			}
+		}
+	}
+}
+
+// startMap creates the function call of a map.
+func (f *Function) startMap(ctx context.Context, client *Client, opts MapOptions) (*mapInvocation, error) {
+	callOpts := retryOptions(f.retryPolicy)
+	resp, err := client.cpClient.FunctionMap(ctx, pb.FunctionMapRequest_builder{
+		FunctionId:                 f.FunctionId,
+		FunctionCallType:           pb.FunctionCallType_FUNCTION_CALL_TYPE_MAP,
+		FunctionCallInvocationType: pb.FunctionCallInvocationType_FUNCTION_CALL_INVOCATION_TYPE_SYNC,
+		ReturnExceptions:           opts.ReturnExceptions,
+	}.Build(), callOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = mapInvocationChunkSize
+	}
+	if opts.MaxInputsOutstanding <= 0 {
+		opts.MaxInputsOutstanding = int(resp.GetMaxInputsOutstanding())
+		if opts.MaxInputsOutstanding <= 0 {
+			opts.MaxInputsOutstanding = defaultMaxInputsOutstanding
+		}
+	}
+	return &mapInvocation{
+		ctx:             ctx,
+		client:          client,
+		function:        f,
+		opts:            opts,
+		functionCallId:  resp.GetFunctionCallId(),
+		functionCallJwt: resp.GetFunctionCallJwt(),
+		callOpts:        callOpts,
+		sem:             make(chan struct{}, opts.MaxInputsOutstanding),
+		wake:            make(chan struct{}, 1),
+		results:         make(chan mapResult),
+		errc:            make(chan error, 2),
+		pending:         map[int32]*mapInput{},
+	}, nil
+}
+
+func (m *mapInvocation) fail(err error) {
+	select {
+	case m.errc <- err:
+	default:
+	}
+}
+
+func (m *mapInvocation) signal() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// sendInputs uploads the inputs in batches. A batch is sent when it is full, or
+// before waiting for an outstanding input to complete. No more inputs are read
+// once the map is stopped.
+func (m *mapInvocation) sendInputs(inputs iter.Seq[[]any]) {
+	var batch []*pb.FunctionPutInputsItem
+	idx := int32(0)
+	if m.ctx.Err() != nil {
+		return
+	}
+	for args := range inputs {
+		if m.ctx.Err() != nil {
+			return
+		}
+		select {
+		case m.sem <- struct{}{}:
+		default:
+			if err := m.putInputs(batch); err != nil {
+				m.fail(err)
+				return
+			}
+			batch = nil
+			select {
+			case m.sem <- struct{}{}:
+			case <-m.ctx.Done():
+				return
+			}
+		}
+
+		input, err := m.function.createInput(m.ctx, m.client, args, m.opts.Kwargs)
+		if err != nil {
+			m.fail(err)
+			return
+		}
+		batch = append(batch, pb.FunctionPutInputsItem_builder{Idx: idx, Input: input}.Build())
+		idx++
+		if len(batch) == m.opts.BatchSize {
+			if err := m.putInputs(batch); err != nil {
+				m.fail(err)
+				return
+			}
+			batch = nil
+		}
+		if m.ctx.Err() != nil {
+			return
+		}
+	}
+	if err := m.putInputs(batch); err != nil {
+		m.fail(err)
+		return
+	}
+	m.mu.Lock()
+	m.allSent = true
+	m.mu.Unlock()
+	m.signal()
+}
+
+// putInputs uploads a batch of inputs, waiting while the server's queue for the
+// function call is full.
+func (m *mapInvocation) putInputs(batch []*pb.FunctionPutInputsItem) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	// Inputs are recorded before they are sent, since their outputs can arrive
+	// before the response.
+	m.mu.Lock()
+	for _, item := range batch {
+		m.pending[item.GetIdx()] = &mapInput{input: item.GetInput(), put: make(chan struct{})}
+	}
+	m.mu.Unlock()
+	m.signal()
+
+	delay := 100 * time.Millisecond
+	for {
+		resp, err := m.client.cpClient.FunctionPutInputs(m.ctx, pb.FunctionPutInputsRequest_builder{
+			FunctionId:     m.function.FunctionId,
+			FunctionCallId: m.functionCallId,
+			Inputs:         batch,
+		}.Build(), m.callOpts...)
+		if status.Code(err) == codes.ResourceExhausted {
+			select {
+			case <-time.After(delay):
+			case <-m.ctx.Done():
+				return m.ctx.Err()
+			}
+			delay = min(2*delay, 5*time.Second)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		m.mu.Lock()
+		for _, item := range resp.GetInputs() {
+			if in, ok := m.pending[item.GetIdx()]; ok {
+				in.inputJwt = item.GetInputJwt()
+			}
+		}
+		for _, item := range batch {
+			if in, ok := m.pending[item.GetIdx()]; ok {
+				close(in.put)
+			}
+		}
+		m.mu.Unlock()
+		return nil
+	}
+}
+
+// receiveOutputs fetches outputs while inputs are outstanding, retries inputs that
+// failed with an internal error, and sends the other outputs to m.results. It
+// closes m.results once all inputs were sent and have an output.
+func (m *mapInvocation) receiveOutputs() {
+	lastEntryId := "0-0"
+	for {
+		m.mu.Lock()
+		outstanding := len(m.pending)
+		allSent := m.allSent
+		m.mu.Unlock()
+		if outstanding == 0 {
+			if allSent {
+				close(m.results)
+				return
+			}
+			select {
+			case <-m.wake:
+				continue
+			case <-m.ctx.Done():
+				return
+			}
+		}
+
+		resp, err := m.client.cpClient.FunctionGetOutputs(m.ctx, pb.FunctionGetOutputsRequest_builder{
+			FunctionCallId: m.functionCallId,
+			MaxValues:      mapOutputsBatchSize,
+			Timeout:        float32(outputsTimeout.Seconds()),
+			LastEntryId:    lastEntryId,
+			ClearOnSuccess: false,
+			RequestedAt:    timeNowSeconds(),
+		}.Build(), m.callOpts...)
+		if err != nil {
+			if m.ctx.Err() == nil {
+				m.fail(fmt.Errorf("FunctionGetOutputs failed: %w", err))
+			}
+			return
+		}
+		if resp.GetLastEntryId() != "" {
+			lastEntryId = resp.GetLastEntryId()
+		}
+
+		for _, item := range resp.GetOutputs() {
+			if err := m.handleOutput(item); err != nil {
+				if m.ctx.Err() == nil {
+					m.fail(err)
+				}
+				return
+			}
+		}
+	}
+}
+
+// handleOutput retries the input of an output that failed with an internal error,
+// and otherwise sends the output to m.results.
+func (m *mapInvocation) handleOutput(item *pb.FunctionGetOutputsItem) error {
+	idx := item.GetIdx()
+	m.mu.Lock()
+	in, ok := m.pending[idx]
+	m.mu.Unlock()
+	// Outputs of earlier attempts of a retried input, and duplicates, are ignored.
+	if !ok || item.GetRetryCount() < in.retryCount {
+		return nil
+	}
+
+	if item.GetResult().GetStatus() == pb.GenericResult_GENERIC_STATUS_INTERNAL_FAILURE && in.retryCount < maxSystemRetries {
+		// The output can arrive before FunctionPutInputs returns the JWT of the
+		// input, which is needed to retry it.
+		select {
+		case <-in.put:
+		case <-m.ctx.Done():
+			return m.ctx.Err()
+		}
+		m.mu.Lock()
+		inputJwt := in.inputJwt
+		m.mu.Unlock()
+		if inputJwt == "" {
+			return fmt.Errorf("FunctionPutInputs returned no JWT for input %d", idx)
+		}
+		in.retryCount++
+		resp, err := m.client.cpClient.FunctionRetryInputs(m.ctx, pb.FunctionRetryInputsRequest_builder{
+			FunctionCallJwt: m.functionCallJwt,
+			Inputs: []*pb.FunctionRetryInputsItem{pb.FunctionRetryInputsItem_builder{
+				InputJwt:   inputJwt,
+				Input:      in.input,
+				RetryCount: in.retryCount,
+			}.Build()},
+		}.Build(), m.callOpts...)
+		if err != nil {
+			return err
+		}
+		if jwts := resp.GetInputJwts(); len(jwts) > 0 {
+			m.mu.Lock()
+			in.inputJwt = jwts[0]
+			m.mu.Unlock()
+		}
+		return nil
+	}
+
+	output, err := processResult(m.ctx, m.client, codecOrDefault(m.function.codec, m.client), item.GetResult(), item.GetDataFormat())
+	select {
+	case m.results <- mapResult{idx: idx, output: output, err: err}:
+	case <-m.ctx.Done():
+		return m.ctx.Err()
+	}
+	m.mu.Lock()
+	delete(m.pending, idx)
+	m.mu.Unlock()
+	<-m.sem
+	return nil
+}
@@ -6,6 +6,8 @@ import (
 	"io"
 
 	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc"
 )
 
 // Image represents a Modal image, which can be used to create sandboxes.
@@ -66,9 +68,9 @@ func NewImageFromGcpArtifactRegistry(tag string, secret *Secret) *Image {
 	}
 }
 
-func (image *Image) build(app *App) (*Image, error) {
+func (image *Image) build(ctx context.Context, app *App, callOpts ...grpc.CallOption) (*Image, error) {
 	if image == nil {
-		return nil, InvalidError{"image must be non-nil"}
+		return nil, InvalidError{Exception: "image must be non-nil"}
 	}
 
 	// Image is already hyrdated
@@ -76,16 +78,24 @@ func (image *Image) build(app *App) (*Image, error) {
 		return image, nil
 	}
 
-	resp, err := client.ImageGetOrCreate(
-		app.ctx,
+	ctx, span := app.client.startSpan(ctx, "Image.build", attribute.String("modal.app_id", app.AppId), attribute.String("modal.image_tag", image.tag))
+	image, err := image.getOrCreate(ctx, app, callOpts...)
+	endSpan(span, err)
+	return image, err
+}
+
+func (image *Image) getOrCreate(ctx context.Context, app *App, callOpts ...grpc.CallOption) (*Image, error) {
+	resp, err := app.client.cpClient.ImageGetOrCreate(
+		ctx,
 		pb.ImageGetOrCreateRequest_builder{
 			AppId: app.AppId,
 			Image: pb.Image_builder{
 				DockerfileCommands:  []string{`FROM ` + image.tag},
 				ImageRegistryConfig: image.imageRegistryConfig,
 			}.Build(),
-			BuilderVersion: imageBuilderVersion(""),
+			BuilderVersion: app.client.imageBuilderVersion(""),
 		}.Build(),
+		callOpts...,
 	)
 	if err != nil {
 		return nil, err
@@ -101,7 +111,7 @@ func (image *Image) build(app *App) (*Image, error) {
 		// Not built or in the process of building - wait for build
 		lastEntryId := ""
 		for result == nil {
-			stream, err := client.ImageJoinStreaming(app.ctx, pb.ImageJoinStreamingRequest_builder{
+			stream, err := app.client.cpClient.ImageJoinStreaming(ctx, pb.ImageJoinStreamingRequest_builder{
 				ImageId:     resp.GetImageId(),
 				Timeout:     55,
 				LastEntryId: lastEntryId,
@@ -134,18 +144,17 @@ func (image *Image) build(app *App) (*Image, error) {
 
 	switch result.GetStatus() {
 	case pb.GenericResult_GENERIC_STATUS_FAILURE:
-		return nil, RemoteError{fmt.Sprintf("Image build for %s failed with the exception:\n%s", resp.GetImageId(), result.GetException())}
+		return nil, RemoteError{Exception: fmt.Sprintf("Image build for %s failed with the exception:\n%s", resp.GetImageId(), result.GetException())}
 	case pb.GenericResult_GENERIC_STATUS_TERMINATED:
-		return nil, RemoteError{fmt.Sprintf("Image build for %s terminated due to external shut-down, please try again", resp.GetImageId())}
+		return nil, RemoteError{Exception: fmt.Sprintf("Image build for %s terminated due to external shut-down, please try again", resp.GetImageId())}
 	case pb.GenericResult_GENERIC_STATUS_TIMEOUT:
-		return nil, RemoteError{fmt.Sprintf("Image build for %s timed out, please try again with a larger timeout parameter", resp.GetImageId())}
+		return nil, RemoteError{Exception: fmt.Sprintf("Image build for %s timed out, please try again with a larger timeout parameter", resp.GetImageId())}
 	case pb.GenericResult_GENERIC_STATUS_SUCCESS:
 		// Success, do nothing
 	default:
-		return nil, RemoteError{fmt.Sprintf("Image build for %s failed with unknown status: %s", resp.GetImageId(), result.GetStatus())}
+		return nil, RemoteError{Exception: fmt.Sprintf("Image build for %s failed with unknown status: %s", resp.GetImageId(), result.GetStatus())}
 	}
 
 	image.ImageId = resp.GetImageId()
-	image.ctx = app.ctx
 	return image, nil
 }
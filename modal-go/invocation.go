@@ -2,12 +2,14 @@ package modal
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"time"
 
 	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+	"google.golang.org/grpc"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -23,21 +25,24 @@ type controlPlaneInvocation struct {
 	functionCallJwt string
 	inputJwt        string
 	ctx             context.Context
+	client          *Client
+	codec           Codec             // decodes outputs in its data format
+	callOpts        []grpc.CallOption // e.g. retry policy overrides, applied to every RPC
 }
 
 // createControlPlaneInvocation executes a function call and returns a new controlPlaneInvocation.
-func createControlPlaneInvocation(ctx context.Context, functionId string, input *pb.FunctionInput, invocationType pb.FunctionCallInvocationType) (*controlPlaneInvocation, error) {
+func createControlPlaneInvocation(ctx context.Context, client *Client, functionId string, input *pb.FunctionInput, invocationType pb.FunctionCallInvocationType, codec Codec, callOpts ...grpc.CallOption) (*controlPlaneInvocation, error) {
 	functionPutInputsItem := pb.FunctionPutInputsItem_builder{
 		Idx:   0,
 		Input: input,
 	}.Build()
 
-	functionMapResponse, err := client.FunctionMap(ctx, pb.FunctionMapRequest_builder{
+	functionMapResponse, err := client.cpClient.FunctionMap(ctx, pb.FunctionMapRequest_builder{
 		FunctionId:                 functionId,
 		FunctionCallType:           pb.FunctionCallType_FUNCTION_CALL_TYPE_UNARY,
 		FunctionCallInvocationType: invocationType,
 		PipelinedInputs:            []*pb.FunctionPutInputsItem{functionPutInputsItem},
-	}.Build())
+	}.Build(), callOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -48,16 +53,19 @@ func createControlPlaneInvocation(ctx context.Context, functionId string, input
 		functionCallJwt: functionMapResponse.GetFunctionCallJwt(),
 		inputJwt:        functionMapResponse.GetPipelinedInputs()[0].GetInputJwt(),
 		ctx:             ctx,
+		client:          client,
+		codec:           codec,
+		callOpts:        callOpts,
 	}, nil
 }
 
 // controlPlaneInvocationFromFunctionCallId creates a controlPlaneInvocation from a function call ID.
-func controlPlaneInvocationFromFunctionCallId(ctx context.Context, functionCallId string) *controlPlaneInvocation {
-	return &controlPlaneInvocation{FunctionCallId: functionCallId, ctx: ctx}
+func controlPlaneInvocationFromFunctionCallId(ctx context.Context, client *Client, functionCallId string, codec Codec) *controlPlaneInvocation {
+	return &controlPlaneInvocation{FunctionCallId: functionCallId, ctx: ctx, client: client, codec: codec}
 }
 
 func (c *controlPlaneInvocation) awaitOutput(timeout *time.Duration) (any, error) {
-	return pollFunctionOutput(c.ctx, c.getOutput, timeout)
+	return pollFunctionOutput(c.ctx, c.client, c.codec, c.getOutput, timeout)
 }
 
 func (c *controlPlaneInvocation) retry(retryCount uint32) error {
@@ -69,10 +77,10 @@ func (c *controlPlaneInvocation) retry(retryCount uint32) error {
 		Input:      c.input,
 		RetryCount: retryCount,
 	}.Build()
-	functionRetryResponse, err := client.FunctionRetryInputs(c.ctx, pb.FunctionRetryInputsRequest_builder{
+	functionRetryResponse, err := c.client.cpClient.FunctionRetryInputs(c.ctx, pb.FunctionRetryInputsRequest_builder{
 		FunctionCallJwt: c.functionCallJwt,
 		Inputs:          []*pb.FunctionRetryInputsItem{retryItem},
-	}.Build())
+	}.Build(), c.callOpts...)
 	if err != nil {
 		return err
 	}
@@ -82,14 +90,14 @@ func (c *controlPlaneInvocation) retry(retryCount uint32) error {
 
 // getOutput fetches the output for the current function call with a timeout in milliseconds.
 func (c *controlPlaneInvocation) getOutput(timeout time.Duration) (*pb.FunctionGetOutputsItem, error) {
-	response, err := client.FunctionGetOutputs(c.ctx, pb.FunctionGetOutputsRequest_builder{
+	response, err := c.client.cpClient.FunctionGetOutputs(c.ctx, pb.FunctionGetOutputsRequest_builder{
 		FunctionCallId: c.FunctionCallId,
 		MaxValues:      1,
 		Timeout:        float32(timeout.Seconds()),
 		LastEntryId:    "0-0",
 		ClearOnSuccess: true,
 		RequestedAt:    timeNowSeconds(),
-	}.Build())
+	}.Build(), c.callOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("FunctionGetOutputs failed: %w", err)
 	}
@@ -102,51 +110,75 @@ func (c *controlPlaneInvocation) getOutput(timeout time.Duration) (*pb.FunctionG
 
 // InputPlaneInvocation implements the Invocation interface for the input plane.
 type inputPlaneInvocation struct {
-	client       pb.ModalClientClient
+	client       *Client
+	ipClient     pb.ModalClientClient
 	functionId   string
 	input        *pb.FunctionPutInputsItem
 	attemptToken string
 	ctx          context.Context
+	codec        Codec             // decodes outputs in its data format
+	callOpts     []grpc.CallOption // e.g. retry policy overrides, applied to every RPC
 }
 
 // CreateInputPlaneInvocation creates a new InputPlaneInvocation by starting an attempt.
-func createInputPlaneInvocation(ctx context.Context, inputPlaneUrl string, functionId string, input *pb.FunctionInput) (*inputPlaneInvocation, error) {
+func createInputPlaneInvocation(ctx context.Context, client *Client, inputPlaneUrl string, functionId string, input *pb.FunctionInput, codec Codec, callOpts ...grpc.CallOption) (*inputPlaneInvocation, error) {
 	functionPutInputsItem := pb.FunctionPutInputsItem_builder{
 		Idx:   0,
 		Input: input,
 	}.Build()
-	client, err := getOrCreateInputPlaneClient(inputPlaneUrl)
+	ipClient, err := client.getOrCreateInputPlaneClient(inputPlaneUrl)
 	if err != nil {
 		return nil, err
 	}
-	attemptStartResp, err := client.AttemptStart(ctx, pb.AttemptStartRequest_builder{
+	attemptStartResp, err := ipClient.AttemptStart(ctx, pb.AttemptStartRequest_builder{
 		FunctionId: functionId,
 		Input:      functionPutInputsItem,
-	}.Build())
+	}.Build(), callOpts...)
 	if err != nil {
 		return nil, err
 	}
 	return &inputPlaneInvocation{
 		client:       client,
+		ipClient:     ipClient,
 		functionId:   functionId,
 		input:        functionPutInputsItem,
 		attemptToken: attemptStartResp.GetAttemptToken(),
 		ctx:          ctx,
+		codec:        codec,
+		callOpts:     callOpts,
+	}, nil
+}
+
+// inputPlaneInvocationFromCall creates an inputPlaneInvocation for the attempt of
+// a call that was started earlier, e.g. by Spawn. The input isn't known, so the
+// invocation can't be retried.
+func inputPlaneInvocationFromCall(ctx context.Context, client *Client, call *inputPlaneCall, codec Codec) (*inputPlaneInvocation, error) {
+	ipClient, err := client.getOrCreateInputPlaneClient(call.InputPlaneUrl)
+	if err != nil {
+		return nil, err
+	}
+	return &inputPlaneInvocation{
+		client:       client,
+		ipClient:     ipClient,
+		functionId:   call.FunctionId,
+		attemptToken: call.AttemptToken,
+		ctx:          ctx,
+		codec:        codec,
 	}, nil
 }
 
 // awaitOutput waits for the output with an optional timeout.
 func (i *inputPlaneInvocation) awaitOutput(timeout *time.Duration) (any, error) {
-	return pollFunctionOutput(i.ctx, i.getOutput, timeout)
+	return pollFunctionOutput(i.ctx, i.client, i.codec, i.getOutput, timeout)
 }
 
 // getOutput fetches the output for the current attempt.
 func (i *inputPlaneInvocation) getOutput(timeout time.Duration) (*pb.FunctionGetOutputsItem, error) {
-	resp, err := i.client.AttemptAwait(i.ctx, pb.AttemptAwaitRequest_builder{
+	resp, err := i.ipClient.AttemptAwait(i.ctx, pb.AttemptAwaitRequest_builder{
 		AttemptToken: i.attemptToken,
 		RequestedAt:  timeNowSeconds(),
 		TimeoutSecs:  float32(timeout.Seconds()),
-	}.Build())
+	}.Build(), i.callOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("AttemptAwait failed: %w", err)
 	}
@@ -155,12 +187,15 @@ func (i *inputPlaneInvocation) getOutput(timeout time.Duration) (*pb.FunctionGet
 
 // retry retries the invocation.
 func (i *inputPlaneInvocation) retry(retryCount uint32) error {
+	if i.input == nil {
+		return fmt.Errorf("cannot retry function invocation - input missing")
+	}
 	// We ignore retryCount - it is used only by controlPlaneInvocation.
-	resp, err := i.client.AttemptRetry(context.Background(), pb.AttemptRetryRequest_builder{
+	resp, err := i.ipClient.AttemptRetry(i.ctx, pb.AttemptRetryRequest_builder{
 		FunctionId:   i.functionId,
 		Input:        i.input,
 		AttemptToken: i.attemptToken,
-	}.Build())
+	}.Build(), i.callOpts...)
 	if err != nil {
 		return err
 	}
@@ -168,6 +203,41 @@ func (i *inputPlaneInvocation) retry(retryCount uint32) error {
 	return nil
 }
 
+// awaitOutputWithRetries waits for the output of an invocation, and retries the
+// input if it fails with an internal error.
+func awaitOutputWithRetries(invocation invocation) (any, error) {
+	// TODO(ryan): Add tests for retries.
+	retryCount := uint32(0)
+	for {
+		output, err := invocation.awaitOutput(nil)
+		if err == nil {
+			return output, nil
+		}
+		if errors.As(err, &InternalFailure{}) && retryCount <= maxSystemRetries {
+			if retryErr := invocation.retry(retryCount); retryErr != nil {
+				return nil, retryErr
+			}
+			retryCount++
+			continue
+		}
+		return nil, err
+	}
+}
+
+// cancelFunctionCall cancels a function call whose outputs are no longer needed,
+// such as a map or generator that the caller stopped iterating early. It is
+// called after ctx may have been cancelled, so only the values of ctx are used.
+func cancelFunctionCall(ctx context.Context, client *Client, functionCallId string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	_, err := client.cpClient.FunctionCallCancel(ctx, pb.FunctionCallCancelRequest_builder{
+		FunctionCallId: functionCallId,
+	}.Build())
+	if err != nil {
+		client.logger.DebugContext(ctx, "failed to cancel function call", "function_call_id", functionCallId, "error", err)
+	}
+}
+
 // getOutput is a function type that takes a timeout and returns a FunctionGetOutputsItem or nil, and an error.
 // Used by `pollForOutputs` to fetch from either the control plane or the input plane, depending on the implementation.
 type getOutput func(timeout time.Duration) (*pb.FunctionGetOutputsItem, error)
@@ -175,7 +245,7 @@ type getOutput func(timeout time.Duration) (*pb.FunctionGetOutputsItem, error)
 // pollFunctionOutput repeatedly tries to fetch an output using the provided `getOutput` function, and the specified
 // timeout value. We use a timeout value of 55 seconds if the caller does not specify a timeout value, or if the
 // specified timeout value is greater than 55 seconds.
-func pollFunctionOutput(ctx context.Context, getOutput getOutput, timeout *time.Duration) (any, error) {
+func pollFunctionOutput(ctx context.Context, client *Client, codec Codec, getOutput getOutput, timeout *time.Duration) (any, error) {
 	startTime := time.Now()
 	pollTimeout := outputsTimeout
 	if timeout != nil {
@@ -191,7 +261,7 @@ func pollFunctionOutput(ctx context.Context, getOutput getOutput, timeout *time.
 		// Output serialization may fail if any of the output items can't be deserialized
 		// into a supported Go type. Users are expected to serialize outputs correctly.
 		if output != nil {
-			return processResult(ctx, output.GetResult(), output.GetDataFormat())
+			return processResult(ctx, client, codec, output.GetResult(), output.GetDataFormat())
 		}
 
 		if timeout != nil {
@@ -205,10 +275,11 @@ func pollFunctionOutput(ctx context.Context, getOutput getOutput, timeout *time.
 	}
 }
 
-// processResult processes the result from an invocation.
-func processResult(ctx context.Context, result *pb.GenericResult, dataFormat pb.DataFormat) (any, error) {
+// processResult processes the result from an invocation. Successful results
+// are decoded with codec if it encodes their data format.
+func processResult(ctx context.Context, client *Client, codec Codec, result *pb.GenericResult, dataFormat pb.DataFormat) (any, error) {
 	if result == nil {
-		return nil, RemoteError{"Received null result from invocation"}
+		return nil, RemoteError{Exception: "Received null result from invocation"}
 	}
 
 	var data []byte
@@ -217,7 +288,7 @@ func processResult(ctx context.Context, result *pb.GenericResult, dataFormat pb.
 	case pb.GenericResult_Data_case:
 		data = result.GetData()
 	case pb.GenericResult_DataBlobId_case:
-		data, err = blobDownload(ctx, result.GetDataBlobId())
+		data, err = blobDownload(ctx, client, result.GetDataBlobId())
 		if err != nil {
 			return nil, err
 		}
@@ -233,23 +304,30 @@ func processResult(ctx context.Context, result *pb.GenericResult, dataFormat pb.
 	case pb.GenericResult_GENERIC_STATUS_SUCCESS:
 		// Proceed to the block below this switch statement.
 	default:
-		// In this case, `result.GetData()` may have a pickled user code exception with traceback
-		// from Python. We ignore this and only take the string representation.
-		return nil, RemoteError{result.GetException()}
+		// In this case, `data` may have the pickled user code exception, which is
+		// decoded along with the traceback as far as the pickle decoder allows.
+		if dataFormat != pb.DataFormat_DATA_FORMAT_PICKLE && dataFormat != pb.DataFormat_DATA_FORMAT_UNSPECIFIED {
+			data = nil
+		}
+		return nil, newRemoteError(result, data)
 	}
 
-	return deserializeDataFormat(data, dataFormat)
+	return decodePayload(codec, data, dataFormat)
 }
 
 // blobDownload downloads a blob by its ID.
-func blobDownload(ctx context.Context, blobId string) ([]byte, error) {
-	resp, err := client.BlobGet(ctx, pb.BlobGetRequest_builder{
+func blobDownload(ctx context.Context, client *Client, blobId string) ([]byte, error) {
+	resp, err := client.cpClient.BlobGet(ctx, pb.BlobGetRequest_builder{
 		BlobId: blobId,
 	}.Build())
 	if err != nil {
 		return nil, err
 	}
-	s3resp, err := http.Get(resp.GetDownloadUrl())
+	req, err := http.NewRequestWithContext(ctx, "GET", resp.GetDownloadUrl(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create download request: %w", err)
+	}
+	s3resp, err := client.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to download blob: %w", err)
 	}
@@ -258,6 +336,7 @@ func blobDownload(ctx context.Context, blobId string) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read blob data: %w", err)
 	}
+	client.metrics.BlobTransferred(BlobDirectionDownload, int64(len(buf)))
 	return buf, nil
 }
 
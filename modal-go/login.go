@@ -0,0 +1,136 @@
+package modal
+
+// Browser-based login, which creates a new token for the user.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+)
+
+// From: modal/token_flow.py
+const tokenFlowWaitTimeout = 40 * time.Second
+
+const defaultLoginTimeout = 10 * time.Minute
+
+// LoginOptions are options for Login.
+type LoginOptions struct {
+	// OnURL is called with the URL that the user must open in a browser to
+	// authorize the new token, and a code they should check matches the one shown
+	// there. Required.
+	OnURL func(url, code string)
+	// Timeout is the maximum time to wait for the user to authorize the token.
+	// Defaults to 10 minutes.
+	Timeout time.Duration
+	// ServerURL is the Modal server to log in to. Defaults to MODAL_SERVER_URL,
+	// the server of the active profile, or https://api.modal.com:443.
+	ServerURL string
+	// Profile is the name of a profile in the config file to save the token to.
+	// The token is not saved if empty.
+	Profile string
+	// Activate makes the saved profile the active one.
+	Activate bool
+}
+
+// LoginResult is a token created by Login.
+type LoginResult struct {
+	TokenId           string
+	TokenSecret       string
+	WorkspaceUsername string
+	ConfigPath        string // config file the token was saved to, empty if not saved
+}
+
+// Login creates a new token through the browser-based token flow. It calls
+// options.OnURL with a URL for the user to open, and waits until they authorize
+// the token there, or until options.Timeout or ctx is done.
+//
+// If options.Profile is set, the token is saved to that profile in the config
+// file, keeping all other settings. Use the returned token with NewClient, or
+// select the saved profile with ClientOptions.Profile.
+func Login(ctx context.Context, options LoginOptions) (*LoginResult, error) {
+	if options.OnURL == nil {
+		return nil, InvalidError{Exception: "LoginOptions.OnURL must be set"}
+	}
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = defaultLoginTimeout
+	}
+
+	profile, err := ResolveProfile(ClientOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if options.ServerURL != "" {
+		profile.ServerURL = options.ServerURL
+	}
+	c, err := newClientFromProfile(profile, ClientOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close(ctx)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ctx = c.anonymousContext(ctx)
+
+	createResp, err := c.cpClient.TokenFlowCreate(ctx, pb.TokenFlowCreateRequest_builder{
+		UtmSource: "modal-go",
+	}.Build())
+	if err != nil {
+		return nil, fmt.Errorf("TokenFlowCreate failed: %w", err)
+	}
+	options.OnURL(createResp.GetWebUrl(), createResp.GetCode())
+
+	var waitResp *pb.TokenFlowWaitResponse
+	for {
+		waitResp, err = c.cpClient.TokenFlowWait(ctx, pb.TokenFlowWaitRequest_builder{
+			TokenFlowId: createResp.GetTokenFlowId(),
+			WaitSecret:  createResp.GetWaitSecret(),
+			Timeout:     float32(tokenFlowWaitTimeout.Seconds()),
+		}.Build())
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("login was not authorized within %s", timeout)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("TokenFlowWait failed: %w", err)
+		}
+		if !waitResp.GetTimeout() {
+			break
+		}
+	}
+
+	result := &LoginResult{
+		TokenId:           waitResp.GetTokenId(),
+		TokenSecret:       waitResp.GetTokenSecret(),
+		WorkspaceUsername: waitResp.GetWorkspaceUsername(),
+	}
+	if options.Profile != "" {
+		path, err := configFilePath()
+		if err != nil {
+			return nil, err
+		}
+		err = updateConfigFile(path, func(doc map[string]any) error {
+			table, err := profileTable(doc, options.Profile)
+			if err != nil {
+				return err
+			}
+			table["token_id"] = result.TokenId
+			table["token_secret"] = result.TokenSecret
+			if options.ServerURL != "" {
+				table["server_url"] = options.ServerURL
+			}
+			if options.Activate {
+				activateProfile(doc, options.Profile)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to save token: %w", err)
+		}
+		result.ConfigPath = path
+	}
+	return result, nil
+}
@@ -0,0 +1,65 @@
+package modal
+
+// Metrics hooks for RPCs, blob transfers and output streams.
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BlobDirection is the direction of a blob transfer.
+type BlobDirection string
+
+const (
+	BlobDirectionUpload   BlobDirection = "upload"   // Blob uploaded, e.g. a large Function input.
+	BlobDirectionDownload BlobDirection = "download" // Blob downloaded, e.g. a large Function output.
+)
+
+// Metrics receives measurements from a Client. Methods are called synchronously
+// from the goroutine making the call, so implementations must be fast and safe
+// for concurrent use. See the prommetrics package for a Prometheus implementation.
+type Metrics interface {
+	// RPCStarted is called when a unary RPC starts, before its first attempt.
+	// Method is the full gRPC method, e.g. "/modal.client.ModalClient/FunctionMap".
+	RPCStarted(method string)
+	// RPCFinished is called when a unary RPC returns, after all of its attempts.
+	RPCFinished(method string, code codes.Code, duration time.Duration)
+	// RPCRetried is called before an RPC is retried after an error with the given code.
+	RPCRetried(method string, code codes.Code)
+	// BlobTransferred is called after a blob is uploaded or downloaded.
+	BlobTransferred(direction BlobDirection, bytes int64)
+	// StreamReconnected is called before a streaming RPC, such as
+	// "SandboxGetLogs", is reconnected after an error.
+	StreamReconnected(stream string)
+}
+
+// noopMetrics is used when no Metrics are configured.
+type noopMetrics struct{}
+
+func (noopMetrics) RPCStarted(string)                             {}
+func (noopMetrics) RPCFinished(string, codes.Code, time.Duration) {}
+func (noopMetrics) RPCRetried(string, codes.Code)                 {}
+func (noopMetrics) BlobTransferred(BlobDirection, int64)          {}
+func (noopMetrics) StreamReconnected(string)                      {}
+
+// metricsInterceptor reports the latency and in-flight count of each unary RPC.
+func metricsInterceptor(c *Client) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply any,
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		start := time.Now()
+		c.metrics.RPCStarted(method)
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		c.metrics.RPCFinished(method, status.Code(err), time.Since(start))
+		return err
+	}
+}
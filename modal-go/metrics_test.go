@@ -0,0 +1,87 @@
+package modal
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recordingMetrics records calls to the Metrics interface.
+type recordingMetrics struct {
+	mu               sync.Mutex
+	started          []string
+	finished         []codes.Code
+	retried          []codes.Code
+	blobBytes        map[BlobDirection]int64
+	streamReconnects map[string]int
+}
+
+func (m *recordingMetrics) RPCStarted(method string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.started = append(m.started, method)
+}
+
+func (m *recordingMetrics) RPCFinished(method string, code codes.Code, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.finished = append(m.finished, code)
+}
+
+func (m *recordingMetrics) RPCRetried(method string, code codes.Code) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retried = append(m.retried, code)
+}
+
+func (m *recordingMetrics) BlobTransferred(direction BlobDirection, bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobBytes[direction] += bytes
+}
+
+func (m *recordingMetrics) StreamReconnected(stream string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.streamReconnects[stream]++
+}
+
+func TestMetricsInterceptors(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	metrics := &recordingMetrics{blobBytes: map[BlobDirection]int64{}, streamReconnects: map[string]int{}}
+	c := newTestClient(g, nil, ClientOptions{
+		RetryPolicy:       &RetryPolicy{BaseDelay: time.Millisecond},
+		StreamRetryPolicy: &RetryPolicy{BaseDelay: time.Millisecond},
+		Metrics:           metrics,
+	})
+
+	attempts := 0
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		attempts++
+		if attempts < 3 {
+			return status.Error(codes.Unavailable, "unavailable")
+		}
+		return nil
+	}
+	retry := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return retryInterceptor(c)(ctx, method, req, reply, cc, invoker, opts...)
+	}
+	err := metricsInterceptor(c)(context.Background(), "/modal.client.ModalClient/QueuePut", nil, nil, nil, retry)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+	g.Expect(metrics.started).To(gomega.Equal([]string{"/modal.client.ModalClient/QueuePut"}))
+	g.Expect(metrics.finished).To(gomega.Equal([]codes.Code{codes.OK}))
+	g.Expect(metrics.retried).To(gomega.Equal([]codes.Code{codes.Unavailable, codes.Unavailable}))
+
+	r := c.newStreamRetrier(context.Background(), "SandboxGetLogs")
+	g.Expect(r.retry(status.Error(codes.Unavailable, "unavailable"))).To(gomega.BeTrue())
+	g.Expect(metrics.streamReconnects).To(gomega.Equal(map[string]int{"SandboxGetLogs": 1}))
+}
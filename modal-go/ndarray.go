@@ -0,0 +1,283 @@
+package modal
+
+// Support for NumPy arrays in the pickle codec.
+//
+// NumPy pickles arrays in one of two ways. With protocol 5, contiguous arrays
+// are pickled as numpy.core.numeric._frombuffer(buffer, dtype, shape, order).
+// Otherwise they are pickled as numpy.core.multiarray._reconstruct(ndarray,
+// (0,), b'b') with the state (1, shape, dtype, is_fortran, data). NumPy 2
+// renamed numpy.core to numpy._core. In both cases the data is the raw items
+// of the array, in Fortran order if the array is.
+//
+// og-rek can't pickle an object with state, so NDArrays are pickled as
+// numpy.ndarray(shape, dtype, buffer, 0, None, "C").
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+
+	pickle "github.com/kisielk/og-rek"
+)
+
+// NDArray is a NumPy array, i.e. a numpy.ndarray. Data is a slice of the Go
+// type of DType with the items of the array in row-major (C) order, e.g. a
+// []float64 for a "float64" array. Shape is nil for an array of one item with
+// no dimensions.
+//
+// Python arrays are decoded into an NDArray by Decode, and NDArray arguments
+// are pickled as arrays. The supported DTypes are "bool", "int8", "int16",
+// "int32", "int64", "uint8", "uint16", "uint32", "uint64", "float32" and
+// "float64".
+type NDArray struct {
+	Shape []int
+	DType string
+	Data  any
+}
+
+// ndarrayDType is a NumPy dtype that an NDArray supports.
+type ndarrayDType struct {
+	name string       // e.g. float64
+	code string       // the kind and size of an item, e.g. f8
+	typ  reflect.Type // the type of NDArray.Data
+}
+
+var ndarrayDTypes = []ndarrayDType{
+	{"bool", "b1", reflect.TypeFor[[]bool]()},
+	{"int8", "i1", reflect.TypeFor[[]int8]()},
+	{"int16", "i2", reflect.TypeFor[[]int16]()},
+	{"int32", "i4", reflect.TypeFor[[]int32]()},
+	{"int64", "i8", reflect.TypeFor[[]int64]()},
+	{"uint8", "u1", reflect.TypeFor[[]uint8]()},
+	{"uint16", "u2", reflect.TypeFor[[]uint16]()},
+	{"uint32", "u4", reflect.TypeFor[[]uint32]()},
+	{"uint64", "u8", reflect.TypeFor[[]uint64]()},
+	{"float32", "f4", reflect.TypeFor[[]float32]()},
+	{"float64", "f8", reflect.TypeFor[[]float64]()},
+}
+
+var (
+	ndarrayType    = reflect.TypeFor[NDArray]()
+	pyNDArray      = pickle.Class{Module: "numpy", Name: "ndarray"}
+	pyNumpyDType   = pickle.Class{Module: "numpy", Name: "dtype"}
+	numpyCoreNames = []string{"numpy.core", "numpy._core"}
+)
+
+// size returns the number of items of an array of the shape.
+func (a NDArray) size() int {
+	n := 1
+	for _, d := range a.Shape {
+		n *= d
+	}
+	return n
+}
+
+// dtype returns the dtype of the array, which is derived from the type of Data
+// if DType is empty.
+func (a NDArray) dtype() (ndarrayDType, error) {
+	typ := reflect.TypeOf(a.Data)
+	for _, dt := range ndarrayDTypes {
+		if dt.name == a.DType || a.DType == "" && dt.typ == typ {
+			if typ != dt.typ {
+				return ndarrayDType{}, fmt.Errorf("NDArray data of type %v doesn't match dtype %s", typ, dt.name)
+			}
+			return dt, nil
+		}
+	}
+	if a.DType == "" {
+		return ndarrayDType{}, fmt.Errorf("unsupported NDArray data of type %v", typ)
+	}
+	return ndarrayDType{}, fmt.Errorf("unsupported NDArray dtype %q", a.DType)
+}
+
+// ndarrayToPython returns the pickle form of an NDArray.
+func ndarrayToPython(a NDArray) (any, error) {
+	dt, err := a.dtype()
+	if err != nil {
+		return nil, err
+	}
+	data := reflect.ValueOf(a.Data)
+	shape := make(pickle.Tuple, len(a.Shape))
+	for i, d := range a.Shape {
+		if d < 0 {
+			return nil, fmt.Errorf("invalid NDArray shape %v", a.Shape)
+		}
+		shape[i] = int64(d)
+	}
+	if data.Len() != a.size() {
+		return nil, fmt.Errorf("NDArray has %d items, but its shape %v has %d", data.Len(), a.Shape, a.size())
+	}
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, a.Data); err != nil {
+		return nil, err
+	}
+	byteOrder := "<"
+	if dt.code[1:] == "1" {
+		byteOrder = "|"
+	}
+	// og-rek encodes []byte as a bytearray, which gives a writable array.
+	return pickle.Call{
+		Callable: pyNDArray,
+		Args:     pickle.Tuple{shape, byteOrder + dt.code, buf.Bytes(), int64(0), pickle.None{}, "C"},
+	}, nil
+}
+
+// ndarrayFromPython returns the NDArray of a pickled NumPy array, as returned
+// by pythonToGo. It returns false if v isn't an array.
+func ndarrayFromPython(v any) (NDArray, bool, error) {
+	var shapeArg, dtypeArg, dataArg any
+	fortran := false
+	switch v := v.(type) {
+	case pickle.Call:
+		switch {
+		case isNumpyCore(v.Callable, "numeric", "_frombuffer") && len(v.Args) == 4:
+			// _frombuffer(buffer, dtype, shape, order)
+			dataArg, dtypeArg, shapeArg = v.Args[0], v.Args[1], v.Args[2]
+			fortran = v.Args[3] == "F"
+		case v.Callable == pyNDArray && len(v.Args) == 6:
+			// ndarray(shape, dtype, buffer, offset, strides, order)
+			if v.Args[3] != int64(0) || v.Args[4] != (pickle.None{}) {
+				return NDArray{}, true, fmt.Errorf("unsupported ndarray offset or strides")
+			}
+			shapeArg, dtypeArg, dataArg = v.Args[0], v.Args[1], v.Args[2]
+			fortran = v.Args[5] == "F"
+		default:
+			return NDArray{}, false, nil
+		}
+	case PythonObject:
+		if !isNumpyCore(v.Class, "multiarray", "_reconstruct") {
+			return NDArray{}, false, nil
+		}
+		state, ok := v.State.(pickle.Tuple)
+		if ok && len(state) == 5 {
+			state = state[1:] // the version of the state
+		}
+		if !ok || len(state) != 4 {
+			return NDArray{}, true, fmt.Errorf("invalid ndarray state")
+		}
+		shapeArg, dtypeArg, dataArg = state[0], state[1], state[3]
+		fortran = state[2] == true
+	default:
+		return NDArray{}, false, nil
+	}
+
+	var a NDArray
+	shape, ok := shapeArg.(pickle.Tuple)
+	if !ok {
+		return NDArray{}, true, fmt.Errorf("invalid ndarray shape")
+	}
+	for _, d := range shape {
+		d, ok := d.(int64)
+		if !ok || d < 0 {
+			return NDArray{}, true, fmt.Errorf("invalid ndarray shape")
+		}
+		a.Shape = append(a.Shape, int(d))
+	}
+	dt, byteOrder, err := numpyDType(dtypeArg)
+	if err != nil {
+		return NDArray{}, true, err
+	}
+	a.DType = dt.name
+	var data []byte
+	switch b := dataArg.(type) {
+	case []byte:
+		data = b
+	case ByteArray:
+		data = b
+	default:
+		return NDArray{}, true, fmt.Errorf("unsupported ndarray data of type %s", pythonTypeName(dataArg))
+	}
+
+	items := reflect.MakeSlice(dt.typ, a.size(), a.size())
+	if binary.Size(items.Interface()) != len(data) {
+		return NDArray{}, true, fmt.Errorf("ndarray has %d bytes of data, but its shape %v has %d", len(data), a.Shape, binary.Size(items.Interface()))
+	}
+	if err := binary.Read(bytes.NewReader(data), byteOrder, items.Interface()); err != nil {
+		return NDArray{}, true, err
+	}
+	if fortran && len(a.Shape) > 1 {
+		items = fortranToC(items, a.Shape)
+	}
+	a.Data = items.Interface()
+	return a, true, nil
+}
+
+// numpyDType returns the dtype and byte order of a pickled numpy.dtype, or of a
+// dtype string such as "<f8".
+func numpyDType(v any) (ndarrayDType, binary.ByteOrder, error) {
+	var code, byteOrder string
+	switch v := v.(type) {
+	case string:
+		if len(v) > 0 && (v[0] == '<' || v[0] == '>' || v[0] == '|' || v[0] == '=') {
+			byteOrder, code = v[:1], v[1:]
+		} else {
+			code = v
+		}
+	case pickle.Call:
+		if v.Callable == pyNumpyDType && len(v.Args) > 0 {
+			code, _ = v.Args[0].(string)
+		}
+	case PythonObject:
+		// dtype(code, align, copy) with the state (version, byte order, ...)
+		if v.Class == pyNumpyDType && len(v.Args) > 0 {
+			code, _ = v.Args[0].(string)
+			if state, ok := v.State.(pickle.Tuple); ok && len(state) > 1 {
+				byteOrder, _ = state[1].(string)
+			}
+		}
+	}
+	if code == "?" {
+		code = "b1"
+	}
+	for _, dt := range ndarrayDTypes {
+		if dt.code == code {
+			if byteOrder == ">" {
+				return dt, binary.BigEndian, nil
+			}
+			return dt, binary.LittleEndian, nil
+		}
+	}
+	if code == "" {
+		return ndarrayDType{}, nil, fmt.Errorf("invalid ndarray dtype")
+	}
+	return ndarrayDType{}, nil, fmt.Errorf("unsupported ndarray dtype %q", code)
+}
+
+// fortranToC returns the items of an array in column-major (Fortran) order in
+// row-major (C) order.
+func fortranToC(items reflect.Value, shape []int) reflect.Value {
+	out := reflect.MakeSlice(items.Type(), items.Len(), items.Len())
+	index := make([]int, len(shape))
+	for i := range out.Len() {
+		// index is the index in the array of the i-th item in C order.
+		j, stride := 0, 1
+		for d := range shape {
+			j += index[d] * stride
+			stride *= shape[d]
+		}
+		out.Index(i).Set(items.Index(j))
+		for d := len(shape) - 1; d >= 0; d-- {
+			if index[d]++; index[d] < shape[d] {
+				break
+			}
+			index[d] = 0
+		}
+	}
+	return out
+}
+
+// isNumpyCore reports whether c is the function name in the module of
+// numpy.core, or numpy._core in NumPy 2.
+func isNumpyCore(c pickle.Class, module, name string) bool {
+	if c.Name != name {
+		return false
+	}
+	for _, core := range numpyCoreNames {
+		if c.Module == core+"."+module {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package modal
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	pickle "github.com/kisielk/og-rek"
+	"github.com/onsi/gomega"
+)
+
+// The pickles were made in CPython 3.11 with a stand-in numpy module whose
+// arrays and dtypes have the __reduce_ex__ of numpy.ndarray and numpy.dtype.
+func TestNDArrayDecode(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		python   string
+		protocol map[int]string // pickles by protocol, in hex
+		expected NDArray
+	}{
+		{
+			python: "np.array([1.5, -2, 3.25])",
+			protocol: map[int]string{
+				2: "8002636e756d70792e636f72652e6d756c746961727261790a5f7265636f6e7374727563740a7100636e756d70790a6e6461727261790a71014b00857102635f636f646563730a656e636f64650a7103580100000062710458060000006c6174696e317105867106527107877108527109284b014b0385710a636e756d70790a64747970650a710b58020000006638710c898887710d52710e284b0358010000003c710f4e4e4e4affffffff4affffffff4b0074711062896803581a000000000000000000c3b83f00000000000000c3800000000000000a4071116805867112527113747114622e",
+				5: "8005958b000000000000008c126e756d70792e636f72652e6e756d65726963948c0b5f66726f6d62756666657294939428961800000000000000000000000000f83f00000000000000c00000000000000a40948c056e756d7079948c0564747970659493948c02663894898887945294284b038c013c944e4e4e4affffffff4affffffff4b007494624b0385948c014394749452942e",
+			},
+			expected: NDArray{Shape: []int{3}, DType: "float64", Data: []float64{1.5, -2, 3.25}},
+		},
+		{
+			python: "np.arange(6, dtype=np.int32).reshape(2, 3)",
+			protocol: map[int]string{
+				5: "8005958d000000000000008c126e756d70792e636f72652e6e756d65726963948c0b5f66726f6d62756666657294939428961800000000000000000000000100000002000000030000000400000005000000948c056e756d7079948c0564747970659493948c02693494898887945294284b038c013c944e4e4e4affffffff4affffffff4b007494624b024b0386948c014394749452942e",
+			},
+			expected: NDArray{Shape: []int{2, 3}, DType: "int32", Data: []int32{0, 1, 2, 3, 4, 5}},
+		},
+		{
+			python: "np.asfortranarray([[1, 2, 3], [4, 5, 6]])",
+			protocol: map[int]string{
+				2: "8002636e756d70792e636f72652e6d756c746961727261790a5f7265636f6e7374727563740a7100636e756d70790a6e6461727261790a71014b00857102635f636f646563730a656e636f64650a7103580100000062710458060000006c6174696e317105867106527107877108527109284b014b024b0386710a636e756d70790a64747970650a710b58020000006938710c898887710d52710e284b0358010000003c710f4e4e4e4affffffff4affffffff4b0074711062886803583000000001000000000000000400000000000000020000000000000005000000000000000300000000000000060000000000000071116805867112527113747114622e",
+				5: "800595a5000000000000008c126e756d70792e636f72652e6e756d65726963948c0b5f66726f6d62756666657294939428963000000000000000010000000000000004000000000000000200000000000000050000000000000003000000000000000600000000000000948c056e756d7079948c0564747970659493948c02693894898887945294284b038c013c944e4e4e4affffffff4affffffff4b007494624b024b0386948c014694749452942e",
+			},
+			expected: NDArray{Shape: []int{2, 3}, DType: "int64", Data: []int64{1, 2, 3, 4, 5, 6}},
+		},
+		{
+			python: "np.array([True, False, True])",
+			protocol: map[int]string{
+				2: "8002636e756d70792e636f72652e6d756c746961727261790a5f7265636f6e7374727563740a7100636e756d70790a6e6461727261790a71014b00857102635f636f646563730a656e636f64650a7103580100000062710458060000006c6174696e317105867106527107877108527109284b014b0385710a636e756d70790a64747970650a710b58020000006231710c898887710d52710e284b0358010000007c710f4e4e4e4affffffff4affffffff4b0074711062896803580300000001000171116805867112527113747114622e",
+				5: "80059576000000000000008c126e756d70792e636f72652e6e756d65726963948c0b5f66726f6d62756666657294939428960300000000000000010001948c056e756d7079948c0564747970659493948c02623194898887945294284b038c017c944e4e4e4affffffff4affffffff4b007494624b0385948c014394749452942e",
+			},
+			expected: NDArray{Shape: []int{3}, DType: "bool", Data: []bool{true, false, true}},
+		},
+		{
+			python: "np.array([-1, 2], dtype=np.int8)",
+			protocol: map[int]string{
+				5: "80059575000000000000008c126e756d70792e636f72652e6e756d65726963948c0b5f66726f6d62756666657294939428960200000000000000ff02948c056e756d7079948c0564747970659493948c02693194898887945294284b038c017c944e4e4e4affffffff4affffffff4b007494624b0285948c014394749452942e",
+			},
+			expected: NDArray{Shape: []int{2}, DType: "int8", Data: []int8{-1, 2}},
+		},
+		{
+			python: "np.array([[0, 1], [254, 255]], dtype=np.uint8)",
+			protocol: map[int]string{
+				2: "8002636e756d70792e636f72652e6d756c746961727261790a5f7265636f6e7374727563740a7100636e756d70790a6e6461727261790a71014b00857102635f636f646563730a656e636f64650a7103580100000062710458060000006c6174696e317105867106527107877108527109284b014b024b0286710a636e756d70790a64747970650a710b58020000007531710c898887710d52710e284b0358010000007c710f4e4e4e4affffffff4affffffff4b007471106289680358060000000001c3bec3bf71116805867112527113747114622e",
+			},
+			expected: NDArray{Shape: []int{2, 2}, DType: "uint8", Data: []uint8{0, 1, 254, 255}},
+		},
+		{
+			python: "np.array([1, 2.5], dtype='>f4')",
+			protocol: map[int]string{
+				5: "8005957b000000000000008c126e756d70792e636f72652e6e756d65726963948c0b5f66726f6d627566666572949394289608000000000000003f80000040200000948c056e756d7079948c0564747970659493948c02663494898887945294284b038c013e944e4e4e4affffffff4affffffff4b007494624b0285948c014394749452942e",
+			},
+			expected: NDArray{Shape: []int{2}, DType: "float32", Data: []float32{1, 2.5}},
+		},
+		{
+			python: "np.array(7.0)",
+			protocol: map[int]string{
+				2: "8002636e756d70792e636f72652e6d756c746961727261790a5f7265636f6e7374727563740a7100636e756d70790a6e6461727261790a71014b00857102635f636f646563730a656e636f64650a7103580100000062710458060000006c6174696e317105867106527107877108527109284b0129636e756d70790a64747970650a710a58020000006638710b898887710c52710d284b0358010000003c710e4e4e4e4affffffff4affffffff4b0074710f6289680358080000000000000000001c4071106805867111527112747113622e",
+			},
+			expected: NDArray{DType: "float64", Data: []float64{7}},
+		},
+	} {
+		for protocol, data := range tc.protocol {
+			t.Run(fmt.Sprintf("%s/%d", tc.python, protocol), func(t *testing.T) {
+				t.Parallel()
+				g := gomega.NewWithT(t)
+
+				b, err := hex.DecodeString(data)
+				g.Expect(err).ShouldNot(gomega.HaveOccurred())
+				v, err := pickleDeserialize(b)
+				g.Expect(err).ShouldNot(gomega.HaveOccurred())
+				g.Expect(pythonTypeName(v)).To(gomega.Equal("numpy.ndarray"))
+
+				var a NDArray
+				g.Expect(Decode(v, &a)).To(gomega.Succeed())
+				g.Expect(a).To(gomega.Equal(tc.expected))
+			})
+		}
+	}
+
+	t.Run("shared dtype", func(t *testing.T) {
+		t.Parallel()
+		g := gomega.NewWithT(t)
+
+		// [np.array([1.0]), np.array([2.0, 3.0])] with protocol 2, where the
+		// second array refers to the memoized dtype of the first.
+		b, err := hex.DecodeString("80025d710028636e756d70792e636f72652e6d756c746961727261790a5f7265636f6e7374727563740a7101636e756d70790a6e6461727261790a71024b00857103635f636f646563730a656e636f64650a7104580100000062710558060000006c6174696e31710686710752710887710952710a284b014b0185710b636e756d70790a64747970650a710c58020000006638710d898887710e52710f284b0358010000003c71104e4e4e4affffffff4affffffff4b00747111628968045809000000000000000000c3b03f71126806867113527114747115626801680268036808877116527117284b014b02857118680f8968045810000000000000000000004000000000000008407119680686711a52711b74711c62652e")
+		g.Expect(err).ShouldNot(gomega.HaveOccurred())
+		v, err := pickleDeserialize(b)
+		g.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+		var arrays []NDArray
+		g.Expect(Decode(v, &arrays)).To(gomega.Succeed())
+		g.Expect(arrays).To(gomega.Equal([]NDArray{
+			{Shape: []int{1}, DType: "float64", Data: []float64{1}},
+			{Shape: []int{2}, DType: "float64", Data: []float64{2, 3}},
+		}))
+	})
+
+	t.Run("unsupported dtype", func(t *testing.T) {
+		t.Parallel()
+		g := gomega.NewWithT(t)
+
+		// np.array([1+2j]) with protocol 5
+		b, err := hex.DecodeString("80059584000000000000008c126e756d70792e636f72652e6e756d65726963948c0b5f66726f6d62756666657294939428961000000000000000000000000000f03f0000000000000040948c056e756d7079948c0564747970659493948c0363313694898887945294284b038c013c944e4e4e4affffffff4affffffff4b007494624b0185948c014394749452942e")
+		g.Expect(err).ShouldNot(gomega.HaveOccurred())
+		v, err := pickleDeserialize(b)
+		g.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+		var a NDArray
+		err = Decode(v, &a)
+		g.Expect(err).To(gomega.MatchError(ErrDecode))
+		g.Expect(err.Error()).To(gomega.ContainSubstring(`unsupported ndarray dtype "c16"`))
+		g.Expect(Decode([]any{1.5}, &a)).To(gomega.MatchError(ErrDecode))
+	})
+}
+
+// The expected pickles were checked to load in CPython 3.11 with a stand-in
+// numpy.ndarray that takes the arguments of numpy.ndarray.
+func TestNDArrayEncode(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name     string
+		value    NDArray
+		expected string // in hex
+	}{
+		{
+			name:     "float64",
+			value:    NDArray{Shape: []int{2, 2}, DType: "float64", Data: []float64{1, 2, 3, 4.5}},
+			expected: "80048c056e756d70798c076e64617272617993284b024b02868c033c66388c086275696c74696e738c09627974656172726179934320000000000000f03f00000000000000400000000000000840000000000000124085524b004e8c014374522e",
+		},
+		{
+			name:     "bool with derived dtype",
+			value:    NDArray{Shape: []int{3}, Data: []bool{true, false, true}},
+			expected: "80048c056e756d70798c076e64617272617993284b03858c037c62318c086275696c74696e738c0962797465617272617993430301000185524b004e8c014374522e",
+		},
+		{
+			name:     "no dimensions",
+			value:    NDArray{Data: []int32{-7}},
+			expected: "80048c056e756d70798c076e6461727261799328298c033c69348c086275696c74696e738c09627974656172726179934304f9ffffff85524b004e8c014374522e",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			g := gomega.NewWithT(t)
+
+			buf, err := pickleSerialize(tc.value)
+			g.Expect(err).ShouldNot(gomega.HaveOccurred())
+			g.Expect(hex.EncodeToString(buf.Bytes())).To(gomega.Equal(tc.expected))
+
+			// The pickle decodes back into the array.
+			v, err := pickleDeserialize(buf.Bytes())
+			g.Expect(err).ShouldNot(gomega.HaveOccurred())
+			var a NDArray
+			g.Expect(Decode(v, &a)).To(gomega.Succeed())
+			if tc.value.DType == "" {
+				tc.value.DType = a.DType
+			}
+			g.Expect(a).To(gomega.Equal(tc.value))
+		})
+	}
+
+	g := gomega.NewWithT(t)
+	_, err := pickleSerialize(pickle.Tuple{&NDArray{Shape: []int{3}, Data: []float64{1, 2}}})
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("NDArray has 2 items, but its shape [3] has 3")))
+	_, err = pickleSerialize(NDArray{DType: "int8", Data: []float64{1}})
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("doesn't match dtype int8")))
+	_, err = pickleSerialize(NDArray{Data: []complex128{1}})
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("unsupported NDArray data")))
+}
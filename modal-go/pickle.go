@@ -0,0 +1,450 @@
+package modal
+
+// The pickle codec, which maps Go values to Python values and back, as
+// described in the package documentation.
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"reflect"
+	"time"
+
+	pickle "github.com/kisielk/og-rek"
+)
+
+// ByteArray is a Python bytearray. Python bytes are decoded as []byte, and
+// []byte is encoded as bytes, so ByteArray is only needed to tell them apart.
+type ByteArray []byte
+
+// Set is a Python set. Its items are in no particular order.
+type Set []any
+
+// FrozenSet is a Python frozenset. Its items are in no particular order.
+type FrozenSet []any
+
+// Date is a Python datetime.date, a calendar date without a time or time zone.
+type Date struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+// String returns the date in ISO 8601 format, e.g. "2024-01-31".
+func (d Date) String() string {
+	return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+}
+
+// NaiveDateTime is a Python datetime.datetime without a time zone, a "naive"
+// datetime. Its value is the date and wall clock time of Time, in Time's
+// location; decoded values are in UTC.
+type NaiveDateTime struct {
+	time.Time
+}
+
+// Decimal is a Python decimal.Decimal, in its string form, e.g. "1.50",
+// "-1E+3" or "NaN". The string form keeps the exact value and precision.
+type Decimal string
+
+// PythonObject is a Python object without a Go equivalent that was pickled
+// with state, e.g. an instance of a dataclass, whose __dict__ is pickled. The
+// object is Class(*Args), or Class.__new__(Class, *Args, **Kwargs) for
+// instances of classes that don't define how they are pickled, with State set
+// by __setstate__ or as its __dict__. Objects without state or Kwargs are
+// decoded as a pickle.Call. Within its own Args, State or Kwargs, an object is
+// referred to by a *PythonObject, since it contains itself.
+type PythonObject struct {
+	Class  pickle.Class
+	Args   pickle.Tuple
+	Kwargs map[any]any
+	State  any
+}
+
+// pickleProtocol is the protocol of the pickles that are sent to Python. Unlike
+// the og-rek default of 2, it pickles Go strings as Python 3 str.
+const pickleProtocol = 4
+
+// Serialize Go data types to the Python pickle format. Go structs are encoded
+// as dicts, see encodeValue.
+func pickleSerialize(v any) (bytes.Buffer, error) {
+	var inputBuffer bytes.Buffer
+
+	v, err := encodeValue(v)
+	if err != nil {
+		return bytes.Buffer{}, fmt.Errorf("error pickling data: %w", err)
+	}
+	e := pickle.NewEncoderWithConfig(&inputBuffer, &pickle.EncoderConfig{Protocol: pickleProtocol})
+	err = e.Encode(v)
+
+	if err != nil {
+		return bytes.Buffer{}, fmt.Errorf("error pickling data: %w", err)
+	}
+	return inputBuffer, nil
+}
+
+// Deserialize from Python pickle into Go basic types.
+func pickleDeserialize(buffer []byte) (any, error) {
+	buffer, err := rewritePickle(buffer)
+	if err != nil {
+		return nil, fmt.Errorf("error unpickling data: %w", err)
+	}
+	decoder := pickle.NewDecoderWithConfig(bytes.NewReader(buffer), &pickle.DecoderConfig{
+		PersistentLoad: loadPickleOp,
+	})
+	result, err := decoder.Decode()
+	if err != nil {
+		return nil, fmt.Errorf("error unpickling data: %w", err)
+	}
+	return pythonToGo(result, map[uintptr]any{}), nil
+}
+
+var (
+	timeType          = reflect.TypeFor[time.Time]()
+	naiveDateTimeType = reflect.TypeFor[NaiveDateTime]()
+	dateType          = reflect.TypeFor[Date]()
+	decimalType       = reflect.TypeFor[Decimal]()
+	byteArrayType     = reflect.TypeFor[ByteArray]()
+	setType           = reflect.TypeFor[Set]()
+	frozenSetType     = reflect.TypeFor[FrozenSet]()
+)
+
+var (
+	pyDatetime  = pickle.Class{Module: "datetime", Name: "datetime"}
+	pyDate      = pickle.Class{Module: "datetime", Name: "date"}
+	pyTimezone  = pickle.Class{Module: "datetime", Name: "timezone"}
+	pyTimedelta = pickle.Class{Module: "datetime", Name: "timedelta"}
+	pyDecimal   = pickle.Class{Module: "decimal", Name: "Decimal"}
+	pyZoneInfo  = pickle.Class{Module: "zoneinfo", Name: "ZoneInfo._unpickle"}
+	pySet       = pickle.Class{Module: "builtins", Name: "set"}
+	pyFrozenSet = pickle.Class{Module: "builtins", Name: "frozenset"}
+)
+
+// goToPython returns the pickle form of Go values of types that stand for a
+// Python type that og-rek doesn't encode, and false for other values.
+func goToPython(v any) (any, bool, error) {
+	switch v := v.(type) {
+	case time.Time:
+		state, err := datetimeState(v)
+		if err != nil {
+			return nil, true, err
+		}
+		// The time zone is encoded as a fixed offset, with the name of the zone
+		// at the time, e.g. timezone(timedelta(hours=-5), "EST").
+		name, offset := v.Zone()
+		days := floorDiv(offset, 86400)
+		tzArgs := pickle.Tuple{pickle.Call{
+			Callable: pyTimedelta,
+			Args:     pickle.Tuple{int64(days), int64(offset - days*86400), int64(0)},
+		}}
+		if v.Location() != time.UTC && name != "" {
+			tzArgs = append(tzArgs, name)
+		}
+		tz := pickle.Call{Callable: pyTimezone, Args: tzArgs}
+		return pickle.Call{Callable: pyDatetime, Args: pickle.Tuple{state, tz}}, true, nil
+	case NaiveDateTime:
+		state, err := datetimeState(v.Time)
+		if err != nil {
+			return nil, true, err
+		}
+		return pickle.Call{Callable: pyDatetime, Args: pickle.Tuple{state}}, true, nil
+	case Date:
+		if v.Year < 1 || v.Year > 9999 {
+			return nil, true, fmt.Errorf("date %s is out of the range of Python dates", v)
+		}
+		state := pickle.Bytes([]byte{byte(v.Year >> 8), byte(v.Year), byte(v.Month), byte(v.Day)})
+		return pickle.Call{Callable: pyDate, Args: pickle.Tuple{state}}, true, nil
+	case Decimal:
+		return pickle.Call{Callable: pyDecimal, Args: pickle.Tuple{string(v)}}, true, nil
+	case NDArray:
+		call, err := ndarrayToPython(v)
+		return call, true, err
+	case PythonObject:
+		// og-rek can't pickle the state of an object, nor create it with NEWOBJ_EX.
+		if v.Kwargs != nil {
+			return nil, true, fmt.Errorf("can't pickle %s object with keyword arguments", pythonClassName(v.Class))
+		}
+		return nil, true, fmt.Errorf("can't pickle %s object with state", pythonClassName(v.Class))
+	case ReaderArg, *ReaderArg:
+		// encodeInput replaces the ReaderArgs that are arguments of a call.
+		return nil, true, InvalidError{Exception: "ReaderArg can only be passed as an argument of a Function call, not inside other values"}
+	}
+	return nil, false, nil
+}
+
+// datetimeState returns the pickled state of a Python datetime with the date
+// and wall clock time of t.
+func datetimeState(t time.Time) (pickle.Bytes, error) {
+	if t.Year() < 1 || t.Year() > 9999 {
+		return "", fmt.Errorf("time %s is out of the range of Python datetimes", t)
+	}
+	us := t.Nanosecond() / 1000
+	return pickle.Bytes([]byte{
+		byte(t.Year() >> 8), byte(t.Year()), byte(t.Month()), byte(t.Day()),
+		byte(t.Hour()), byte(t.Minute()), byte(t.Second()),
+		byte(us >> 16), byte(us >> 8), byte(us),
+	}), nil
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// reducePython returns the result of a call in a pickle. Calls that construct a
+// Python type with a Go equivalent return the Go value, which is hashable
+// unlike a pickle.Call, so that values such as datetimes can be dict keys.
+// Other calls return a *PythonObject, so that state set on the object after
+// it was memoized is shared by all of its references; pythonToGo replaces it.
+func reducePython(callable, args any) (any, error) {
+	argv, ok := args.(pickle.Tuple)
+	if !ok {
+		return nil, fmt.Errorf("reduce: invalid args: %T", args)
+	}
+	var class pickle.Class
+	if c, ok := pythonCall(callable); ok {
+		callable = c
+	}
+	switch c := callable.(type) {
+	case pickle.Class:
+		class = c
+	case pickle.Call:
+		// getattr(cls, name) refers to a method, e.g. ZoneInfo._unpickle.
+		if len(c.Args) != 2 || !isPythonBuiltin(c.Callable, "getattr") {
+			return nil, fmt.Errorf("reduce: invalid callable: %s", pythonClassName(c.Callable))
+		}
+		cls, ok1 := c.Args[0].(pickle.Class)
+		name, ok2 := c.Args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("reduce: invalid getattr args")
+		}
+		class = pickle.Class{Module: cls.Module, Name: cls.Name + "." + name}
+	default:
+		return nil, fmt.Errorf("reduce: invalid callable: %T", callable)
+	}
+
+	switch {
+	case class == pickle.Class{Module: "_codecs", Name: "encode"} && len(argv) == 2:
+		// Python 3 pickles bytes as _codecs.encode(latin1_str, "latin1") with
+		// protocols older than 3.
+		if s, ok := argv[0].(string); ok && argv[1] == "latin1" {
+			return pickle.Bytes(latin1Bytes(s)), nil
+		}
+	case isPythonBuiltin(class, "bytearray"):
+		switch {
+		case len(argv) == 0:
+			return []byte{}, nil
+		case len(argv) == 1:
+			if b, ok := argv[0].(pickle.Bytes); ok {
+				return []byte(b), nil
+			}
+		case len(argv) == 2 && argv[1] == "latin-1":
+			if s, ok := argv[0].(string); ok {
+				return latin1Bytes(s), nil
+			}
+		}
+	case isPythonBuiltin(class, "set") || isPythonBuiltin(class, "frozenset"):
+		var items []any
+		if len(argv) == 1 {
+			if items, ok = argv[0].([]any); !ok {
+				break
+			}
+		} else if len(argv) > 1 {
+			break
+		}
+		if class.Name == "set" {
+			s := Set(items)
+			return &s, nil
+		}
+		return FrozenSet(items), nil
+	case class == pyDatetime && (len(argv) == 1 || len(argv) == 2):
+		state, ok := stateBytes(argv[0])
+		if !ok || len(state) != 10 {
+			break
+		}
+		year := int(state[0])<<8 | int(state[1])
+		us := int(state[7])<<16 | int(state[8])<<8 | int(state[9])
+		loc := time.UTC
+		if len(argv) == 2 {
+			if loc, ok = pythonTimeZone(argv[1]); !ok {
+				break
+			}
+		}
+		// The high bit of the month is the fold of the time, which Go doesn't need.
+		t := time.Date(year, time.Month(state[2]&0x7f), int(state[3]), int(state[4]), int(state[5]), int(state[6]), us*1000, loc)
+		if len(argv) == 1 {
+			return NaiveDateTime{t}, nil
+		}
+		return t, nil
+	case class == pyDate && len(argv) == 1:
+		if state, ok := stateBytes(argv[0]); ok && len(state) == 4 {
+			return Date{Year: int(state[0])<<8 | int(state[1]), Month: time.Month(state[2]), Day: int(state[3])}, nil
+		}
+	case class == pyDecimal && len(argv) == 1:
+		if s, ok := argv[0].(string); ok {
+			return Decimal(s), nil
+		}
+	}
+	return &PythonObject{Class: class, Args: argv}, nil
+}
+
+// pythonCall returns a call of a Python class without state, as it is before
+// and after pythonToGo.
+func pythonCall(v any) (pickle.Call, bool) {
+	switch v := v.(type) {
+	case pickle.Call:
+		return v, true
+	case *PythonObject:
+		if v.State == nil && v.Kwargs == nil {
+			return pickle.Call{Callable: v.Class, Args: v.Args}, true
+		}
+	}
+	return pickle.Call{}, false
+}
+
+// pythonTimeZone returns the location of a pickled Python tzinfo, if it is a
+// datetime.timezone, zoneinfo.ZoneInfo or None.
+func pythonTimeZone(v any) (*time.Location, bool) {
+	c, ok := pythonCall(v)
+	switch {
+	case !ok:
+		return time.UTC, v == pickle.None{}
+	case c.Callable == pyTimezone && (len(c.Args) == 1 || len(c.Args) == 2):
+		td, ok := pythonCall(c.Args[0])
+		if !ok || td.Callable != pyTimedelta || len(td.Args) != 3 {
+			return nil, false
+		}
+		days, ok1 := td.Args[0].(int64)
+		seconds, ok2 := td.Args[1].(int64)
+		if !ok1 || !ok2 {
+			return nil, false
+		}
+		offset := int(days*86400 + seconds)
+		name, _ := c.Args[len(c.Args)-1].(string)
+		if offset == 0 && len(c.Args) == 1 {
+			return time.UTC, true
+		}
+		return time.FixedZone(name, offset), true
+	case c.Callable == pyZoneInfo && len(c.Args) >= 1:
+		key, ok := c.Args[0].(string)
+		if !ok {
+			return nil, false
+		}
+		loc, err := time.LoadLocation(key)
+		return loc, err == nil
+	}
+	return nil, false
+}
+
+// stateBytes returns the bytes of the pickled state of a Python object, which
+// Python 2 pickles as a str.
+func stateBytes(v any) ([]byte, bool) {
+	switch v := v.(type) {
+	case pickle.Bytes:
+		return []byte(v), true
+	case string:
+		return latin1Bytes(v), true
+	}
+	return nil, false
+}
+
+// latin1Bytes returns the bytes of a string decoded as latin-1.
+func latin1Bytes(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		b = append(b, byte(r))
+	}
+	return b
+}
+
+// isPythonBuiltin reports whether c is the builtin with the given name, which
+// Python 3 pickles in the "__builtin__" module with protocols older than 3.
+func isPythonBuiltin(c pickle.Class, name string) bool {
+	return c.Name == name && (c.Module == "builtins" || c.Module == "__builtin__")
+}
+
+// pythonToGo converts a value decoded by og-rek to the Go types of the pickle
+// codec: ints are int64 if they fit, bytes are []byte and bytearrays are
+// ByteArray. Dict keys that would be unhashable in Go, such as bytes, are left
+// as they are.
+//
+// memo holds the converted dicts, sets and objects by their address, so that
+// values referenced more than once in a pickle are converted once, and values
+// that contain themselves, such as a dict that is one of its own values, don't
+// recurse forever.
+func pythonToGo(v any, memo map[uintptr]any) any {
+	switch v := v.(type) {
+	case *big.Int:
+		if v.IsInt64() {
+			return v.Int64()
+		}
+	case pickle.Bytes:
+		return []byte(v)
+	case []byte:
+		return ByteArray(v)
+	case *Set:
+		key := reflect.ValueOf(v).Pointer()
+		if set, ok := memo[key]; ok {
+			return set
+		}
+		set := Set(pythonToGoItems(*v, memo))
+		memo[key] = set
+		return set
+	case FrozenSet:
+		return FrozenSet(pythonToGoItems(v, memo))
+	case []any:
+		return pythonToGoItems(v, memo)
+	case pickle.Tuple:
+		return pickle.Tuple(pythonToGoItems(v, memo))
+	case map[any]any:
+		key := reflect.ValueOf(v).Pointer()
+		if m, ok := memo[key]; ok {
+			return m
+		}
+		m := make(map[any]any, len(v))
+		memo[key] = m
+		for k, item := range v {
+			if gk := pythonToGo(k, memo); reflect.TypeOf(gk).Comparable() {
+				k = gk
+			}
+			m[k] = pythonToGo(item, memo)
+		}
+		return m
+	case *PythonObject:
+		key := reflect.ValueOf(v).Pointer()
+		if obj, ok := memo[key]; ok {
+			return obj
+		}
+		if v.State == nil && v.Kwargs == nil {
+			call := pickle.Call{Callable: v.Class, Args: pythonToGoItems(v.Args, memo)}
+			memo[key] = call
+			return call
+		}
+		// The object refers to itself as a *PythonObject while it's converted.
+		obj := &PythonObject{Class: v.Class}
+		memo[key] = obj
+		obj.Args = pythonToGoItems(v.Args, memo)
+		obj.State = pythonToGo(v.State, memo)
+		if v.Kwargs != nil {
+			obj.Kwargs = pythonToGo(v.Kwargs, memo).(map[any]any)
+		}
+		memo[key] = *obj
+		return *obj
+	case pickle.Call:
+		return pickle.Call{Callable: v.Callable, Args: pythonToGoItems(v.Args, memo)}
+	}
+	return v
+}
+
+// pythonToGoItems returns a copy of items converted by pythonToGo. The items
+// are copied since a list may be decoded more than once, if it's referenced
+// more than once in a pickle.
+func pythonToGoItems(items []any, memo map[uintptr]any) []any {
+	result := make([]any, len(items))
+	for i, item := range items {
+		result[i] = pythonToGo(item, memo)
+	}
+	return result
+}
@@ -0,0 +1,290 @@
+package modal
+
+// Support for pickle opcodes that the og-rek decoder doesn't implement.
+//
+// Python pickles with protocol 5 by default, whose sets, frozensets and large
+// ints use opcodes that og-rek can't decode, nor can it set the state of
+// objects with BUILD or create them with NEWOBJ, as CPython does for instances
+// of classes such as dataclasses. Before decoding, rewritePickle replaces these
+// opcodes, and REDUCE, with opcodes that og-rek can decode: ones that push
+// the operands and a tag in a tuple, followed by BINPERSID. og-rek then calls
+// loadPickleOp with the tuple as a persistent ID, and pushes its result.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	pickle "github.com/kisielk/og-rek"
+)
+
+// Pickle opcodes, from Lib/pickle.py.
+const (
+	opStop            = '.'
+	opBinpersid       = 'Q'
+	opReduce          = 'R'
+	opBuild           = 'b'
+	opNewobj          = '\x81'
+	opNewobjEx        = '\x92'
+	opTuple           = 't'
+	opLong            = 'L'
+	opTuple1          = '\x85'
+	opTuple2          = '\x86'
+	opTuple3          = '\x87'
+	opLong4           = '\x8b'
+	opShortBinUnicode = '\x8c'
+	opEmptySet        = '\x8f'
+	opAddItems        = '\x90'
+	opFrozenSet       = '\x91'
+)
+
+// Tags of the operations that are rewritten to BINPERSID.
+const (
+	pickleOpTag       = "\x00modal-go:"
+	pickleOpReduce    = pickleOpTag + "reduce"
+	pickleOpBuild     = pickleOpTag + "build"
+	pickleOpNewobj    = pickleOpTag + "newobj"
+	pickleOpNewobjEx  = pickleOpTag + "newobj_ex"
+	pickleOpEmptySet  = pickleOpTag + "empty_set"
+	pickleOpAddItems  = pickleOpTag + "additems"
+	pickleOpFrozenSet = pickleOpTag + "frozenset"
+)
+
+var errPickleTruncated = errors.New("pickle data was truncated")
+
+// rewritePickle returns the pickle data with the opcodes that og-rek can't
+// decode rewritten. data is returned as it is if there's nothing to rewrite.
+func rewritePickle(data []byte) ([]byte, error) {
+	var out []byte // nil until an opcode is rewritten
+	emit := func(b ...byte) {
+		out = append(out, b...)
+	}
+	emitTag := func(tag string) {
+		emit(opShortBinUnicode, byte(len(tag)))
+		out = append(out, tag...)
+	}
+
+	for pos := 0; pos < len(data); {
+		op := data[pos]
+		n, err := pickleArgLen(data, pos)
+		if err != nil {
+			return nil, err
+		}
+		next := pos + 1 + n
+		switch op {
+		case opReduce, opBuild, opNewobj, opNewobjEx, opEmptySet, opAddItems, opFrozenSet, opLong4:
+		case opStop:
+			if out != nil {
+				emit(data[pos:]...)
+			}
+			pos = len(data)
+			continue
+		default:
+			if out != nil {
+				emit(data[pos:next]...)
+			}
+			pos = next
+			continue
+		}
+
+		if out == nil {
+			out = append(make([]byte, 0, len(data)+64), data[:pos]...)
+		}
+		switch op {
+		case opReduce:
+			// callable args -> (callable, args, tag)
+			emitTag(pickleOpReduce)
+			emit(opTuple3)
+		case opBuild:
+			// object state -> (object, state, tag)
+			emitTag(pickleOpBuild)
+			emit(opTuple3)
+		case opNewobj:
+			// class args -> (class, args, tag)
+			emitTag(pickleOpNewobj)
+			emit(opTuple3)
+		case opNewobjEx:
+			// class args kwargs -> ((class, args, kwargs), tag)
+			emit(opTuple3)
+			emitTag(pickleOpNewobjEx)
+			emit(opTuple2)
+		case opEmptySet:
+			// -> (tag,)
+			emitTag(pickleOpEmptySet)
+			emit(opTuple1)
+		case opAddItems:
+			// set mark items -> (set, (items...), tag)
+			emit(opTuple)
+			emitTag(pickleOpAddItems)
+			emit(opTuple3)
+		case opFrozenSet:
+			// mark items -> ((items...), tag)
+			emit(opTuple)
+			emitTag(pickleOpFrozenSet)
+			emit(opTuple2)
+		case opLong4:
+			// LONG4 is a little-endian two's complement int, and LONG is decimal.
+			emit(opLong)
+			out = append(out, decodePickleLong(data[pos+5:next]).String()...)
+			emit('L', '\n')
+			pos = next
+			continue
+		}
+		emit(opBinpersid)
+		pos = next
+	}
+	if out == nil {
+		return data, nil
+	}
+	return out, nil
+}
+
+// pickleArgLen returns the length of the argument of the opcode at pos.
+func pickleArgLen(data []byte, pos int) (int, error) {
+	rest := data[pos+1:]
+	fixed := func(n int) (int, error) {
+		if len(rest) < n {
+			return 0, errPickleTruncated
+		}
+		return n, nil
+	}
+	counted := func(size int) (int, error) {
+		if len(rest) < size {
+			return 0, errPickleTruncated
+		}
+		var n uint64
+		switch size {
+		case 1:
+			n = uint64(rest[0])
+		case 4:
+			n = uint64(binary.LittleEndian.Uint32(rest))
+		case 8:
+			n = binary.LittleEndian.Uint64(rest)
+		}
+		if n > uint64(len(rest)-size) {
+			return 0, errPickleTruncated
+		}
+		return size + int(n), nil
+	}
+	lines := func(count int) (int, error) {
+		n := 0
+		for range count {
+			i := bytes.IndexByte(rest[n:], '\n')
+			if i < 0 {
+				return 0, errPickleTruncated
+			}
+			n += i + 1
+		}
+		return n, nil
+	}
+
+	switch op := data[pos]; op {
+	case '(', '.', '0', '1', '2', 'N', 'R', 'a', 'b', 'd', '}', 'e', 'l', ']', 'o', 's', 't', ')', 'u', 'Q',
+		'\x81', '\x85', '\x86', '\x87', '\x88', '\x89', '\x8f', '\x90', '\x91', '\x92', '\x93', '\x94', '\x97', '\x98':
+		return 0, nil
+	case 'F', 'I', 'L', 'S', 'V', 'P', 'g', 'p':
+		return lines(1)
+	case 'c', 'i':
+		return lines(2)
+	case 'K', 'h', 'q', '\x80', '\x82':
+		return fixed(1)
+	case 'M', '\x83':
+		return fixed(2)
+	case 'J', 'j', 'r', '\x84':
+		return fixed(4)
+	case 'G', '\x95':
+		return fixed(8)
+	case 'U', 'C', '\x8a', '\x8c':
+		return counted(1)
+	case 'T', 'X', 'B', '\x8b':
+		return counted(4)
+	case '\x8d', '\x8e', '\x96':
+		return counted(8)
+	default:
+		return 0, fmt.Errorf("unknown pickle opcode %q at position %d", op, pos)
+	}
+}
+
+// decodePickleLong decodes a little-endian two's complement int.
+func decodePickleLong(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i, x := range b {
+		be[len(b)-1-i] = x
+	}
+	n := new(big.Int).SetBytes(be)
+	if len(b) > 0 && b[len(b)-1]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(8*len(b))))
+	}
+	return n
+}
+
+// loadPickleOp runs an operation that rewritePickle replaced by BINPERSID.
+// Other persistent IDs are left as pickle.Ref values.
+func loadPickleOp(ref pickle.Ref) (any, error) {
+	pid, ok := ref.Pid.(pickle.Tuple)
+	if !ok || len(pid) == 0 {
+		return nil, nil
+	}
+	tag, _ := pid[len(pid)-1].(string)
+	switch {
+	case tag == pickleOpReduce && len(pid) == 3:
+		return reducePython(pid[0], pid[1])
+	case tag == pickleOpBuild && len(pid) == 3:
+		obj, ok := pid[0].(*PythonObject)
+		if !ok {
+			return nil, fmt.Errorf("build: can't set the state of %T", pid[0])
+		}
+		obj.State = pid[1]
+		return obj, nil
+	case tag == pickleOpNewobj && len(pid) == 3:
+		return newPythonObject(pid[0], pid[1], nil)
+	case tag == pickleOpNewobjEx && len(pid) == 2:
+		call, ok := pid[0].(pickle.Tuple)
+		if !ok || len(call) != 3 {
+			return nil, fmt.Errorf("newobj_ex: invalid operands: %v", pid[0])
+		}
+		return newPythonObject(call[0], call[1], call[2])
+	case tag == pickleOpEmptySet && len(pid) == 1:
+		return &Set{}, nil
+	case tag == pickleOpAddItems && len(pid) == 3:
+		s, ok := pid[0].(*Set)
+		if !ok {
+			return nil, fmt.Errorf("additems: expected a set, got %T", pid[0])
+		}
+		items, ok := pid[1].(pickle.Tuple)
+		if !ok {
+			return nil, fmt.Errorf("additems: expected items, got %T", pid[1])
+		}
+		*s = append(*s, items...)
+		return s, nil
+	case tag == pickleOpFrozenSet && len(pid) == 2:
+		items, ok := pid[0].(pickle.Tuple)
+		if !ok {
+			return nil, fmt.Errorf("frozenset: expected items, got %T", pid[0])
+		}
+		return FrozenSet(items), nil
+	}
+	return nil, nil
+}
+
+// newPythonObject returns the object created by class.__new__(class, *args,
+// **kwargs), whose state is usually set by BUILD next.
+func newPythonObject(class, args, kwargs any) (*PythonObject, error) {
+	c, ok := class.(pickle.Class)
+	if !ok {
+		return nil, fmt.Errorf("newobj: expected a class, got %T", class)
+	}
+	argv, ok := args.(pickle.Tuple)
+	if !ok {
+		return nil, fmt.Errorf("newobj: invalid args: %T", args)
+	}
+	obj := &PythonObject{Class: c, Args: argv}
+	if kwargs != nil {
+		if obj.Kwargs, ok = kwargs.(map[any]any); !ok {
+			return nil, fmt.Errorf("newobj_ex: invalid kwargs: %T", kwargs)
+		}
+	}
+	return obj, nil
+}
@@ -0,0 +1,137 @@
+package modal
+
+// Connectivity and credential checks, for readiness probes and startup checks.
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// WarningType is the kind of a ServerWarning.
+type WarningType string
+
+const (
+	WarningTypeUnspecified           WarningType = "unspecified"
+	WarningTypeClientDeprecation     WarningType = "client_deprecation"     // The client version is deprecated, and should be upgraded.
+	WarningTypeResourceLimit         WarningType = "resource_limit"         // A resource limit of the workspace is close to being reached.
+	WarningTypeFunctionConfiguration WarningType = "function_configuration" // A Function is configured in a way that may not work as intended.
+)
+
+func warningTypeFromProto(t pb.Warning_WarningType) WarningType {
+	switch t {
+	case pb.Warning_WARNING_TYPE_CLIENT_DEPRECATION:
+		return WarningTypeClientDeprecation
+	case pb.Warning_WARNING_TYPE_RESOURCE_LIMIT:
+		return WarningTypeResourceLimit
+	case pb.Warning_WARNING_TYPE_FUNCTION_CONFIGURATION:
+		return WarningTypeFunctionConfiguration
+	default:
+		return WarningTypeUnspecified
+	}
+}
+
+// ServerWarning is a warning sent by the server, such as a deprecation notice.
+type ServerWarning struct {
+	Type    WarningType
+	Message string
+}
+
+// Workspace identifies the workspace that a client's token belongs to.
+type Workspace struct {
+	Name string
+}
+
+// PingResult describes a successful connection to the server.
+type PingResult struct {
+	ServerURL           string
+	Workspace           Workspace
+	Environment         string          // environment of the client, empty for the workspace's default
+	ImageBuilderVersion string          // default image builder version of the workspace
+	Warnings            []ServerWarning // e.g. a notice that the client needs to be upgraded
+	Latency             time.Duration   // round-trip time of the ClientHello RPC
+}
+
+// Ping checks that the server is reachable and that the client's credentials are
+// valid, using the default client.
+func Ping(ctx context.Context) (*PingResult, error) {
+	c, err := getDefaultClient()
+	if err != nil {
+		return nil, err
+	}
+	return c.Ping(ctx)
+}
+
+// Ping checks that the server is reachable and that the client's credentials are
+// valid, and returns information about the workspace and server. Warnings from
+// the server are returned, and logged at the warn level.
+func (c *Client) Ping(ctx context.Context) (*PingResult, error) {
+	var err error
+	ctx, span := c.startSpan(ctx, "Client.Ping")
+	defer func() { endSpan(span, err) }()
+
+	ctx, err = c.clientContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := c.cpClient.ClientHello(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	latency := time.Since(start)
+
+	workspace, err := c.workspace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []ServerWarning
+	if resp.GetWarning() != "" {
+		warnings = append(warnings, ServerWarning{Type: WarningTypeClientDeprecation, Message: resp.GetWarning()})
+	}
+	for _, w := range resp.GetServerWarnings() {
+		warnings = append(warnings, ServerWarning{Type: warningTypeFromProto(w.GetType()), Message: w.GetMessage()})
+	}
+	for _, w := range warnings {
+		c.logger.WarnContext(ctx, "server warning", "type", string(w.Type), "message", w.Message)
+	}
+
+	return &PingResult{
+		ServerURL:           c.profile.ServerURL,
+		Workspace:           workspace,
+		Environment:         c.profile.Environment,
+		ImageBuilderVersion: resp.GetImageBuilderVersion(),
+		Warnings:            warnings,
+		Latency:             latency,
+	}, nil
+}
+
+// LookupWorkspace returns the workspace of the default client's credentials.
+func LookupWorkspace(ctx context.Context) (Workspace, error) {
+	c, err := getDefaultClient()
+	if err != nil {
+		return Workspace{}, err
+	}
+	return c.LookupWorkspace(ctx)
+}
+
+// LookupWorkspace returns the workspace that the client's credentials belong to.
+func (c *Client) LookupWorkspace(ctx context.Context) (Workspace, error) {
+	ctx, err := c.clientContext(ctx)
+	if err != nil {
+		return Workspace{}, err
+	}
+	return c.workspace(ctx)
+}
+
+func (c *Client) workspace(ctx context.Context) (Workspace, error) {
+	resp, err := c.cpClient.WorkspaceNameLookup(ctx, &emptypb.Empty{})
+	if err != nil {
+		return Workspace{}, err
+	}
+	return Workspace{Name: resp.GetUsername()}, nil
+}
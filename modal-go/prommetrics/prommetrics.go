@@ -0,0 +1,100 @@
+// Package prommetrics exports the metrics of a Modal client to Prometheus.
+//
+//	collector := prommetrics.NewCollector()
+//	prometheus.MustRegister(collector)
+//	mc, err := modal.NewClient(modal.ClientOptions{Metrics: collector})
+package prommetrics
+
+import (
+	"time"
+
+	modal "github.com/modal-labs/libmodal/modal-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+)
+
+// Collector implements modal.Metrics and prometheus.Collector.
+type Collector struct {
+	rpcDuration      *prometheus.HistogramVec
+	rpcInFlight      *prometheus.GaugeVec
+	rpcRetries       *prometheus.CounterVec
+	blobBytes        *prometheus.CounterVec
+	streamReconnects *prometheus.CounterVec
+}
+
+var (
+	_ modal.Metrics        = (*Collector)(nil)
+	_ prometheus.Collector = (*Collector)(nil)
+)
+
+// NewCollector creates a Collector for the following metrics:
+//
+//   - modal_rpc_duration_seconds: histogram of unary RPC latency, including retries, by method and code.
+//   - modal_rpc_in_flight: number of unary RPCs in progress, by method.
+//   - modal_rpc_retries_total: number of retried RPC attempts, by method and code.
+//   - modal_blob_bytes_total: number of blob bytes transferred, by direction.
+//   - modal_stream_reconnects_total: number of reconnects of streaming RPCs, by stream.
+func NewCollector() *Collector {
+	return &Collector{
+		rpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "modal_rpc_duration_seconds",
+			Help:    "Latency of unary Modal RPCs, including retries.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 14), // 5ms to ~41s
+		}, []string{"method", "code"}),
+		rpcInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "modal_rpc_in_flight",
+			Help: "Number of unary Modal RPCs in progress.",
+		}, []string{"method"}),
+		rpcRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modal_rpc_retries_total",
+			Help: "Number of retried Modal RPC attempts.",
+		}, []string{"method", "code"}),
+		blobBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modal_blob_bytes_total",
+			Help: "Number of blob bytes uploaded to or downloaded from Modal.",
+		}, []string{"direction"}),
+		streamReconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modal_stream_reconnects_total",
+			Help: "Number of reconnects of streaming Modal RPCs.",
+		}, []string{"stream"}),
+	}
+}
+
+func (c *Collector) RPCStarted(method string) {
+	c.rpcInFlight.WithLabelValues(method).Inc()
+}
+
+func (c *Collector) RPCFinished(method string, code codes.Code, duration time.Duration) {
+	c.rpcInFlight.WithLabelValues(method).Dec()
+	c.rpcDuration.WithLabelValues(method, code.String()).Observe(duration.Seconds())
+}
+
+func (c *Collector) RPCRetried(method string, code codes.Code) {
+	c.rpcRetries.WithLabelValues(method, code.String()).Inc()
+}
+
+func (c *Collector) BlobTransferred(direction modal.BlobDirection, bytes int64) {
+	c.blobBytes.WithLabelValues(string(direction)).Add(float64(bytes))
+}
+
+func (c *Collector) StreamReconnected(stream string) {
+	c.streamReconnects.WithLabelValues(stream).Inc()
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.rpcDuration.Describe(ch)
+	c.rpcInFlight.Describe(ch)
+	c.rpcRetries.Describe(ch)
+	c.blobBytes.Describe(ch)
+	c.streamReconnects.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.rpcDuration.Collect(ch)
+	c.rpcInFlight.Collect(ch)
+	c.rpcRetries.Collect(ch)
+	c.blobBytes.Collect(ch)
+	c.streamReconnects.Collect(ch)
+}
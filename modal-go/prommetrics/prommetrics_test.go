@@ -0,0 +1,46 @@
+package prommetrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	modal "github.com/modal-labs/libmodal/modal-go"
+	"github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc/codes"
+)
+
+func TestCollector(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	c := NewCollector()
+	reg := prometheus.NewPedanticRegistry()
+	g.Expect(reg.Register(c)).To(gomega.Succeed())
+
+	c.RPCStarted("/modal.client.ModalClient/QueuePut")
+	c.RPCRetried("/modal.client.ModalClient/QueuePut", codes.Unavailable)
+	c.RPCFinished("/modal.client.ModalClient/QueuePut", codes.OK, 20*time.Millisecond)
+	c.BlobTransferred(modal.BlobDirectionUpload, 1024)
+	c.BlobTransferred(modal.BlobDirectionUpload, 1024)
+	c.StreamReconnected("SandboxGetLogs")
+
+	err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP modal_blob_bytes_total Number of blob bytes uploaded to or downloaded from Modal.
+# TYPE modal_blob_bytes_total counter
+modal_blob_bytes_total{direction="upload"} 2048
+# HELP modal_rpc_in_flight Number of unary Modal RPCs in progress.
+# TYPE modal_rpc_in_flight gauge
+modal_rpc_in_flight{method="/modal.client.ModalClient/QueuePut"} 0
+# HELP modal_rpc_retries_total Number of retried Modal RPC attempts.
+# TYPE modal_rpc_retries_total counter
+modal_rpc_retries_total{code="Unavailable",method="/modal.client.ModalClient/QueuePut"} 1
+# HELP modal_stream_reconnects_total Number of reconnects of streaming Modal RPCs.
+# TYPE modal_stream_reconnects_total counter
+modal_stream_reconnects_total{stream="SandboxGetLogs"} 1
+`), "modal_blob_bytes_total", "modal_rpc_in_flight", "modal_rpc_retries_total", "modal_stream_reconnects_total")
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(testutil.CollectAndCount(c, "modal_rpc_duration_seconds")).To(gomega.Equal(1))
+}
@@ -22,10 +22,19 @@ type ProxyFromNameOptions struct {
 	Environment string
 }
 
-// ProxyFromName references a modal.Proxy by its name.
+// ProxyFromName references a modal.Proxy by its name, using the default client.
 func ProxyFromName(ctx context.Context, name string, options *ProxyFromNameOptions) (*Proxy, error) {
+	c, err := getDefaultClient()
+	if err != nil {
+		return nil, err
+	}
+	return c.ProxyFromName(ctx, name, options)
+}
+
+// ProxyFromName references a modal.Proxy by its name.
+func (c *Client) ProxyFromName(ctx context.Context, name string, options *ProxyFromNameOptions) (*Proxy, error) {
 	var err error
-	ctx, err = clientContext(ctx)
+	ctx, err = c.clientContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -34,20 +43,20 @@ func ProxyFromName(ctx context.Context, name string, options *ProxyFromNameOptio
 		options = &ProxyFromNameOptions{}
 	}
 
-	resp, err := client.ProxyGet(ctx, pb.ProxyGetRequest_builder{
+	resp, err := c.cpClient.ProxyGet(ctx, pb.ProxyGetRequest_builder{
 		Name:            name,
-		EnvironmentName: environmentName(options.Environment),
+		EnvironmentName: c.environmentName(options.Environment),
 	}.Build())
 
-	if status, ok := status.FromError(err); ok && status.Code() == codes.NotFound {
-		return nil, NotFoundError{fmt.Sprintf("Proxy '%s' not found", name)}
+	if status.Code(err) == codes.NotFound {
+		return nil, withNotFoundMessage(err, fmt.Sprintf("Proxy '%s' not found", name))
 	}
 	if err != nil {
 		return nil, err
 	}
 
 	if resp.GetProxy() == nil || resp.GetProxy().GetProxyId() == "" {
-		return nil, NotFoundError{fmt.Sprintf("Proxy '%s' not found", name)}
+		return nil, NotFoundError{Exception: fmt.Sprintf("Proxy '%s' not found", name)}
 	}
 
 	return &Proxy{ProxyId: resp.GetProxy().GetProxyId(), ctx: ctx}, nil
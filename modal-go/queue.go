@@ -9,6 +9,8 @@ import (
 	"time"
 
 	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -25,7 +27,7 @@ func validatePartitionKey(partition string) ([]byte, error) {
 	}
 	b := []byte(partition)
 	if len(b) == 0 || len(b) > 64 {
-		return nil, InvalidError{"queue partition key must be 1–64 bytes long"}
+		return nil, InvalidError{Exception: "queue partition key must be 1–64 bytes long"}
 	}
 	return b, nil
 }
@@ -44,6 +46,7 @@ type QueuePutOptions struct {
 	Timeout      *time.Duration // max wait for space (nil = indefinitely)
 	Partition    string
 	PartitionTtl time.Duration // ttl for the *partition* (default 24h)
+	RetryPolicy  *RetryPolicy  // overrides the client's retry policy for failed RPCs (nil = client default)
 }
 
 type QueueLenOptions struct {
@@ -62,33 +65,43 @@ type Queue struct {
 	Name      string
 	cancel    context.CancelFunc // only for ephemeral queues
 	ephemeral bool
-	ctx       context.Context
+	client    *Client
+	codec     Codec // overrides the client's codec, if set
 }
 
-// QueueEphemeral creates a nameless, temporary queue. Caller must CloseEphemeral.
+// QueueEphemeral creates a nameless, temporary queue using the default client. Caller must CloseEphemeral.
 func QueueEphemeral(ctx context.Context, options *EphemeralOptions) (*Queue, error) {
+	c, err := getDefaultClient()
+	if err != nil {
+		return nil, err
+	}
+	return c.QueueEphemeral(ctx, options)
+}
+
+// QueueEphemeral creates a nameless, temporary queue. Caller must CloseEphemeral.
+func (c *Client) QueueEphemeral(ctx context.Context, options *EphemeralOptions) (*Queue, error) {
 	if options == nil {
 		options = &EphemeralOptions{}
 	}
 	var err error
-	ctx, err = clientContext(ctx)
+	ctx, err = c.clientContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := client.QueueGetOrCreate(ctx, pb.QueueGetOrCreateRequest_builder{
+	resp, err := c.cpClient.QueueGetOrCreate(ctx, pb.QueueGetOrCreateRequest_builder{
 		ObjectCreationType: pb.ObjectCreationType_OBJECT_CREATION_TYPE_EPHEMERAL,
-		EnvironmentName:    environmentName(options.Environment),
+		EnvironmentName:    c.environmentName(options.Environment),
 	}.Build())
 	if err != nil {
 		return nil, err
 	}
 
-	heartbeatCtx, cancel := context.WithCancel(ctx)
-	q := &Queue{QueueId: resp.GetQueueId(), cancel: cancel, ephemeral: true, ctx: ctx}
+	heartbeatCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	q := &Queue{QueueId: resp.GetQueueId(), cancel: cancel, ephemeral: true, client: c}
 
 	// backgroundheart‑beat goroutine
-	go func() {
+	c.goBackground(heartbeatCtx, func(heartbeatCtx context.Context) {
 		t := time.NewTicker(ephemeralObjectHeartbeatSleep)
 		defer t.Stop()
 		for {
@@ -96,16 +109,35 @@ func QueueEphemeral(ctx context.Context, options *EphemeralOptions) (*Queue, err
 			case <-heartbeatCtx.Done():
 				return
 			case <-t.C:
-				_, _ = client.QueueHeartbeat(heartbeatCtx, pb.QueueHeartbeatRequest_builder{
+				_, err := c.cpClient.QueueHeartbeat(heartbeatCtx, pb.QueueHeartbeatRequest_builder{
 					QueueId: q.QueueId,
-				}.Build()) // ignore errors – next call will retry or context will cancel
+				}.Build())
+				// Don't fail on errors – next call will retry or context will cancel.
+				if err != nil && heartbeatCtx.Err() == nil {
+					c.logger.WarnContext(heartbeatCtx, "queue heartbeat failed",
+						"queue_id", q.QueueId, "code", status.Code(err).String(), "error", err)
+				}
 			}
 		}
-	}()
+	})
 
 	return q, nil
 }
 
+// startSpan starts a tracing span for a Queue operation.
+func (q *Queue) startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return q.client.startSpan(ctx, name, attribute.String("modal.queue_id", q.QueueId))
+}
+
+// WithCodec returns a copy of the Queue that encodes and decodes its items with
+// codec, instead of the client's Codec. Closing either copy of an ephemeral
+// Queue closes it.
+func (q *Queue) WithCodec(codec Codec) *Queue {
+	qc := *q
+	qc.codec = codec
+	return &qc
+}
+
 // CloseEphemeral deletes an ephemeral queue, only used with QueueEphemeral.
 func (q *Queue) CloseEphemeral() {
 	if q.ephemeral {
@@ -117,13 +149,22 @@ func (q *Queue) CloseEphemeral() {
 	}
 }
 
-// QueueLookup returns a handle to a (possibly new) queue by deployment name.
+// QueueLookup returns a handle to a (possibly new) queue by deployment name, using the default client.
 func QueueLookup(ctx context.Context, name string, options *LookupOptions) (*Queue, error) {
+	c, err := getDefaultClient()
+	if err != nil {
+		return nil, err
+	}
+	return c.QueueLookup(ctx, name, options)
+}
+
+// QueueLookup returns a handle to a (possibly new) queue by deployment name.
+func (c *Client) QueueLookup(ctx context.Context, name string, options *LookupOptions) (*Queue, error) {
 	if options == nil {
 		options = &LookupOptions{}
 	}
 	var err error
-	ctx, err = clientContext(ctx)
+	ctx, err = c.clientContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -133,49 +174,75 @@ func QueueLookup(ctx context.Context, name string, options *LookupOptions) (*Que
 		creationType = pb.ObjectCreationType_OBJECT_CREATION_TYPE_CREATE_IF_MISSING
 	}
 
-	resp, err := client.QueueGetOrCreate(ctx, pb.QueueGetOrCreateRequest_builder{
+	resp, err := c.cpClient.QueueGetOrCreate(ctx, pb.QueueGetOrCreateRequest_builder{
 		DeploymentName:     name,
-		EnvironmentName:    environmentName(options.Environment),
+		EnvironmentName:    c.environmentName(options.Environment),
 		ObjectCreationType: creationType,
 	}.Build())
 	if err != nil {
 		return nil, err
 	}
-	return &Queue{ctx: ctx, QueueId: resp.GetQueueId(), Name: name}, nil
+	return &Queue{client: c, QueueId: resp.GetQueueId(), Name: name}, nil
 }
 
-// QueueDelete removes a queue by name.
+// QueueDelete removes a queue by name, using the default client.
 func QueueDelete(ctx context.Context, name string, options *DeleteOptions) error {
+	c, err := getDefaultClient()
+	if err != nil {
+		return err
+	}
+	return c.QueueDelete(ctx, name, options)
+}
+
+// QueueDelete removes a queue by name.
+func (c *Client) QueueDelete(ctx context.Context, name string, options *DeleteOptions) error {
 	if options == nil {
 		options = &DeleteOptions{}
 	}
 	var err error
-	ctx, err = clientContext(ctx)
+	ctx, err = c.clientContext(ctx)
 	if err != nil {
 		return err
 	}
 
-	q, err := QueueLookup(ctx, name, &LookupOptions{Environment: options.Environment})
+	q, err := c.QueueLookup(ctx, name, &LookupOptions{Environment: options.Environment})
 	if err != nil {
 		return err
 	}
-	_, err = client.QueueDelete(ctx, pb.QueueDeleteRequest_builder{QueueId: q.QueueId}.Build())
+	_, err = c.cpClient.QueueDelete(ctx, pb.QueueDeleteRequest_builder{QueueId: q.QueueId}.Build())
 	return err
 }
 
-// Clear removes all objects from a queue partition.
+// Clear removes all objects from a queue partition. It is ClearContext with
+// context.Background().
 func (q *Queue) Clear(options *QueueClearOptions) error {
+	return q.ClearContext(context.Background(), options)
+}
+
+// ClearContext removes all objects from a queue partition.
+func (q *Queue) ClearContext(ctx context.Context, options *QueueClearOptions) error {
+	ctx, span := q.startSpan(ctx, "Queue.Clear")
+	err := q.clear(ctx, options)
+	endSpan(span, err)
+	return err
+}
+
+func (q *Queue) clear(ctx context.Context, options *QueueClearOptions) error {
 	if options == nil {
 		options = &QueueClearOptions{}
 	}
 	if options.Partition != "" && options.All {
-		return InvalidError{"options.Partition must be \"\" when clearing all partitions"}
+		return InvalidError{Exception: "options.Partition must be \"\" when clearing all partitions"}
 	}
 	key, err := validatePartitionKey(options.Partition)
 	if err != nil {
 		return err
 	}
-	_, err = client.QueueClear(q.ctx, pb.QueueClearRequest_builder{
+	ctx, err = q.client.clientContext(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = q.client.cpClient.QueueClear(ctx, pb.QueueClearRequest_builder{
 		QueueId:       q.QueueId,
 		PartitionKey:  key,
 		AllPartitions: options.All,
@@ -184,7 +251,7 @@ func (q *Queue) Clear(options *QueueClearOptions) error {
 }
 
 // internal helper for both Get and GetMany.
-func (q *Queue) get(n int, options *QueueGetOptions) ([]any, error) {
+func (q *Queue) get(ctx context.Context, n int, options *QueueGetOptions) ([]any, error) {
 	if options == nil {
 		options = &QueueGetOptions{}
 	}
@@ -192,6 +259,10 @@ func (q *Queue) get(n int, options *QueueGetOptions) ([]any, error) {
 	if err != nil {
 		return nil, err
 	}
+	ctx, err = q.client.clientContext(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	startTime := time.Now()
 	pollTimeout := 50 * time.Second
@@ -200,7 +271,7 @@ func (q *Queue) get(n int, options *QueueGetOptions) ([]any, error) {
 	}
 
 	for {
-		resp, err := client.QueueGet(q.ctx, pb.QueueGetRequest_builder{
+		resp, err := q.client.cpClient.QueueGet(ctx, pb.QueueGetRequest_builder{
 			QueueId:      q.QueueId,
 			PartitionKey: partitionKey,
 			Timeout:      float32(pollTimeout.Seconds()),
@@ -210,9 +281,10 @@ func (q *Queue) get(n int, options *QueueGetOptions) ([]any, error) {
 			return nil, err
 		}
 		if len(resp.GetValues()) > 0 {
+			codec := codecOrDefault(q.codec, q.client)
 			out := make([]any, len(resp.GetValues()))
 			for i, raw := range resp.GetValues() {
-				v, err := pickleDeserialize(raw)
+				v, err := codec.Decode(raw)
 				if err != nil {
 					return nil, err
 				}
@@ -236,8 +308,17 @@ func (q *Queue) get(n int, options *QueueGetOptions) ([]any, error) {
 // By default, this will wait until at least one item is present in the queue.
 // If `timeout` is set, returns `QueueEmptyError` if no items are available
 // within that timeout in milliseconds.
+//
+// Get is GetContext with context.Background().
 func (q *Queue) Get(options *QueueGetOptions) (any, error) {
-	vals, err := q.get(1, options)
+	return q.GetContext(context.Background(), options)
+}
+
+// GetContext removes and returns one item, waiting until ctx is done. See Get.
+func (q *Queue) GetContext(ctx context.Context, options *QueueGetOptions) (any, error) {
+	ctx, span := q.startSpan(ctx, "Queue.Get")
+	vals, err := q.get(ctx, 1, options)
+	endSpan(span, err)
 	if err != nil {
 		return nil, err
 	}
@@ -249,12 +330,22 @@ func (q *Queue) Get(options *QueueGetOptions) (any, error) {
 // By default, this will wait until at least one item is present in the queue.
 // If `timeout` is set, returns `QueueEmptyError` if no items are available
 // within that timeout in milliseconds.
+//
+// GetMany is GetManyContext with context.Background().
 func (q *Queue) GetMany(n int, options *QueueGetOptions) ([]any, error) {
-	return q.get(n, options)
+	return q.GetManyContext(context.Background(), n, options)
+}
+
+// GetManyContext removes up to n items, waiting until ctx is done. See GetMany.
+func (q *Queue) GetManyContext(ctx context.Context, n int, options *QueueGetOptions) ([]any, error) {
+	ctx, span := q.startSpan(ctx, "Queue.GetMany")
+	vals, err := q.get(ctx, n, options)
+	endSpan(span, err)
+	return vals, err
 }
 
 // internal put helper (single/many).
-func (q *Queue) put(values []any, options *QueuePutOptions) error {
+func (q *Queue) put(ctx context.Context, values []any, options *QueuePutOptions) error {
 	if options == nil {
 		options = &QueuePutOptions{}
 	}
@@ -262,14 +353,19 @@ func (q *Queue) put(values []any, options *QueuePutOptions) error {
 	if err != nil {
 		return err
 	}
+	ctx, err = q.client.clientContext(ctx)
+	if err != nil {
+		return err
+	}
 
+	codec := codecOrDefault(q.codec, q.client)
 	valuesEncoded := make([][]byte, len(values))
 	for i, v := range values {
-		b, err := pickleSerialize(v)
+		b, err := codec.Encode(v)
 		if err != nil {
 			return err
 		}
-		valuesEncoded[i] = b.Bytes()
+		valuesEncoded[i] = b
 	}
 
 	deadline := time.Time{}
@@ -284,12 +380,12 @@ func (q *Queue) put(values []any, options *QueuePutOptions) error {
 	}
 
 	for {
-		_, err := client.QueuePut(q.ctx, pb.QueuePutRequest_builder{
+		_, err := q.client.cpClient.QueuePut(ctx, pb.QueuePutRequest_builder{
 			QueueId:             q.QueueId,
 			Values:              valuesEncoded,
 			PartitionKey:        key,
 			PartitionTtlSeconds: int32(ttl.Seconds()),
-		}.Build())
+		}.Build(), retryOptions(options.RetryPolicy)...)
 		if err == nil {
 			return nil // success
 		}
@@ -308,8 +404,8 @@ func (q *Queue) put(values []any, options *QueuePutOptions) error {
 			delay = min(delay, remaining)
 		}
 		select {
-		case <-q.ctx.Done():
-			return q.ctx.Err()
+		case <-ctx.Done():
+			return ctx.Err()
 		case <-time.After(delay):
 		}
 	}
@@ -320,8 +416,19 @@ func (q *Queue) put(values []any, options *QueuePutOptions) error {
 // If the queue is full, this will retry with exponential backoff until the
 // provided `timeout` is reached, or indefinitely if `timeout` is not set.
 // Raises `QueueFullError` if the queue is still full after the timeout.
+//
+// Put is PutContext with context.Background().
 func (q *Queue) Put(v any, options *QueuePutOptions) error {
-	return q.put([]any{v}, options)
+	return q.PutContext(context.Background(), v, options)
+}
+
+// PutContext adds a single item to the end of the queue, retrying until ctx is
+// done if the queue is full. See Put.
+func (q *Queue) PutContext(ctx context.Context, v any, options *QueuePutOptions) error {
+	ctx, span := q.startSpan(ctx, "Queue.Put")
+	err := q.put(ctx, []any{v}, options)
+	endSpan(span, err)
+	return err
 }
 
 // PutMany adds multiple items to the end of the queue.
@@ -329,23 +436,51 @@ func (q *Queue) Put(v any, options *QueuePutOptions) error {
 // If the queue is full, this will retry with exponential backoff until the
 // provided `timeout` is reached, or indefinitely if `timeout` is not set.
 // Raises `QueueFullError` if the queue is still full after the timeout.
+//
+// PutMany is PutManyContext with context.Background().
 func (q *Queue) PutMany(values []any, options *QueuePutOptions) error {
-	return q.put(values, options)
+	return q.PutManyContext(context.Background(), values, options)
 }
 
-// Len returns the number of objects in the queue.
+// PutManyContext adds multiple items to the end of the queue, retrying until ctx
+// is done if the queue is full. See PutMany.
+func (q *Queue) PutManyContext(ctx context.Context, values []any, options *QueuePutOptions) error {
+	ctx, span := q.startSpan(ctx, "Queue.PutMany")
+	err := q.put(ctx, values, options)
+	endSpan(span, err)
+	return err
+}
+
+// Len returns the number of objects in the queue. It is LenContext with
+// context.Background().
 func (q *Queue) Len(options *QueueLenOptions) (int, error) {
+	return q.LenContext(context.Background(), options)
+}
+
+// LenContext returns the number of objects in the queue.
+func (q *Queue) LenContext(ctx context.Context, options *QueueLenOptions) (int, error) {
+	ctx, span := q.startSpan(ctx, "Queue.Len")
+	n, err := q.len(ctx, options)
+	endSpan(span, err)
+	return n, err
+}
+
+func (q *Queue) len(ctx context.Context, options *QueueLenOptions) (int, error) {
 	if options == nil {
 		options = &QueueLenOptions{}
 	}
 	if options.Partition != "" && options.Total {
-		return 0, InvalidError{"partition must be empty when requesting total length"}
+		return 0, InvalidError{Exception: "partition must be empty when requesting total length"}
 	}
 	key, err := validatePartitionKey(options.Partition)
 	if err != nil {
 		return 0, err
 	}
-	resp, err := client.QueueLen(q.ctx, pb.QueueLenRequest_builder{
+	ctx, err = q.client.clientContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := q.client.cpClient.QueueLen(ctx, pb.QueueLenRequest_builder{
 		QueueId:      q.QueueId,
 		PartitionKey: key,
 		Total:        options.Total,
@@ -356,8 +491,15 @@ func (q *Queue) Len(options *QueueLenOptions) (int, error) {
 	return int(resp.GetLen()), nil
 }
 
-// Iterate yields items from the queue until it is empty.
+// Iterate yields items from the queue until it is empty. It is IterateContext
+// with context.Background().
 func (q *Queue) Iterate(options *QueueIterateOptions) iter.Seq2[any, error] {
+	return q.IterateContext(context.Background(), options)
+}
+
+// IterateContext yields items from the queue until it is empty, or until ctx is
+// done.
+func (q *Queue) IterateContext(ctx context.Context, options *QueueIterateOptions) iter.Seq2[any, error] {
 	if options == nil {
 		options = &QueueIterateOptions{}
 	}
@@ -367,16 +509,26 @@ func (q *Queue) Iterate(options *QueueIterateOptions) iter.Seq2[any, error] {
 	maxPoll := 30 * time.Second
 
 	return func(yield func(any, error) bool) {
+		ctx, span := q.startSpan(ctx, "Queue.Iterate")
+		var err error
+		defer func() { endSpan(span, err) }()
+
 		key, err := validatePartitionKey(options.Partition)
 		if err != nil {
 			yield(nil, err)
 			return
 		}
+		ctx, err = q.client.clientContext(ctx)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
 
 		fetchDeadline := time.Now().Add(itemPoll)
 		for {
 			pollDuration := max(0, min(maxPoll, time.Until(fetchDeadline)))
-			resp, err := client.QueueNextItems(q.ctx, pb.QueueNextItemsRequest_builder{
+			var resp *pb.QueueNextItemsResponse
+			resp, err = q.client.cpClient.QueueNextItems(ctx, pb.QueueNextItemsRequest_builder{
 				QueueId:         q.QueueId,
 				PartitionKey:    key,
 				ItemPollTimeout: float32(pollDuration.Seconds()),
@@ -388,7 +540,8 @@ func (q *Queue) Iterate(options *QueueIterateOptions) iter.Seq2[any, error] {
 			}
 			if len(resp.GetItems()) > 0 {
 				for _, item := range resp.GetItems() {
-					v, err := pickleDeserialize(item.GetValue())
+					var v any
+					v, err = codecOrDefault(q.codec, q.client).Decode(item.GetValue())
 					if err != nil {
 						yield(nil, err)
 						return
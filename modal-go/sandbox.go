@@ -12,6 +12,7 @@ import (
 	"github.com/djherbis/buffer"
 	"github.com/djherbis/nio/v3"
 	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -77,43 +78,78 @@ type Sandbox struct {
 	Stdout    io.ReadCloser
 	Stderr    io.ReadCloser
 
-	ctx     context.Context
+	client *Client
+
+	mu      sync.Mutex // protects taskId and tunnels
 	taskId  string
 	tunnels map[int]*Tunnel
 }
 
-// newSandbox creates a new Sandbox object from ID.
-func newSandbox(ctx context.Context, sandboxId string) *Sandbox {
-	sb := &Sandbox{SandboxId: sandboxId, ctx: ctx}
-	sb.Stdin = inputStreamSb(ctx, sandboxId)
-	sb.Stdout = outputStreamSb(ctx, sandboxId, pb.FileDescriptor_FILE_DESCRIPTOR_STDOUT)
-	sb.Stderr = outputStreamSb(ctx, sandboxId, pb.FileDescriptor_FILE_DESCRIPTOR_STDERR)
+// newSandbox creates a new Sandbox object from ID. Its streams send RPCs with the
+// client headers of ctx, but aren't cancelled with it.
+func newSandbox(ctx context.Context, client *Client, sandboxId string) *Sandbox {
+	ctx = context.WithoutCancel(ctx)
+	sb := &Sandbox{SandboxId: sandboxId, client: client}
+	sb.Stdin = inputStreamSb(ctx, client, sandboxId)
+	sb.Stdout = outputStreamSb(ctx, client, sandboxId, pb.FileDescriptor_FILE_DESCRIPTOR_STDOUT)
+	sb.Stderr = outputStreamSb(ctx, client, sandboxId, pb.FileDescriptor_FILE_DESCRIPTOR_STDERR)
 	return sb
 }
 
-// SandboxFromId returns a running Sandbox object from an ID.
+// SandboxFromId returns a running Sandbox object from an ID, using the default client.
 func SandboxFromId(ctx context.Context, sandboxId string) (*Sandbox, error) {
-	ctx, err := clientContext(ctx)
+	c, err := getDefaultClient()
 	if err != nil {
 		return nil, err
 	}
+	return c.SandboxFromId(ctx, sandboxId)
+}
 
-	_, err = client.SandboxWait(ctx, pb.SandboxWaitRequest_builder{
+// SandboxFromId returns a running Sandbox object from an ID.
+func (c *Client) SandboxFromId(ctx context.Context, sandboxId string) (*Sandbox, error) {
+	ctx, err := c.clientContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.cpClient.SandboxWait(ctx, pb.SandboxWaitRequest_builder{
 		SandboxId: sandboxId,
 		Timeout:   0,
 	}.Build())
-	if status, ok := status.FromError(err); ok && status.Code() == codes.NotFound {
-		return nil, NotFoundError{fmt.Sprintf("Sandbox with id: '%s' not found", sandboxId)}
+	if status.Code(err) == codes.NotFound {
+		return nil, withNotFoundMessage(err, fmt.Sprintf("Sandbox with id: '%s' not found", sandboxId))
 	}
 	if err != nil {
 		return nil, err
 	}
-	return newSandbox(ctx, sandboxId), nil
+	return newSandbox(ctx, c, sandboxId), nil
 }
 
-// Exec runs a command in the sandbox and returns text streams.
+// Exec runs a command in the sandbox and returns text streams. It is ExecContext
+// with context.Background().
 func (sb *Sandbox) Exec(command []string, opts ExecOptions) (*ContainerProcess, error) {
-	if err := sb.ensureTaskId(); err != nil {
+	return sb.ExecContext(context.Background(), command, opts)
+}
+
+// ExecContext runs a command in the sandbox and returns text streams. Only
+// starting the command is bound to ctx, and the process can outlive it.
+func (sb *Sandbox) ExecContext(ctx context.Context, command []string, opts ExecOptions) (*ContainerProcess, error) {
+	ctx, span := sb.client.startSpan(ctx, "Sandbox.Exec", attribute.String("modal.sandbox_id", sb.SandboxId))
+	cp, err := sb.exec(ctx, command, opts)
+	if cp != nil {
+		span.SetAttributes(attribute.String("modal.exec_id", cp.execId))
+	}
+	endSpan(span, err)
+	return cp, err
+}
+
+func (sb *Sandbox) exec(ctx context.Context, command []string, opts ExecOptions) (*ContainerProcess, error) {
+	ctx, err := sb.client.clientContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	taskId, err := sb.ensureTaskId(ctx)
+	if err != nil {
 		return nil, err
 	}
 	var workdir *string
@@ -127,8 +163,8 @@ func (sb *Sandbox) Exec(command []string, opts ExecOptions) (*ContainerProcess,
 		}
 	}
 
-	resp, err := client.ContainerExec(sb.ctx, pb.ContainerExecRequest_builder{
-		TaskId:      sb.taskId,
+	resp, err := sb.client.cpClient.ContainerExec(ctx, pb.ContainerExecRequest_builder{
+		TaskId:      taskId,
 		Command:     command,
 		Workdir:     workdir,
 		TimeoutSecs: uint32(opts.Timeout.Seconds()),
@@ -137,23 +173,35 @@ func (sb *Sandbox) Exec(command []string, opts ExecOptions) (*ContainerProcess,
 	if err != nil {
 		return nil, err
 	}
-	return newContainerProcess(sb.ctx, resp.GetExecId(), opts), nil
+	return newContainerProcess(ctx, sb.client, resp.GetExecId(), opts), nil
 }
 
 // Open opens a file in the sandbox filesystem.
 // The mode parameter follows the same conventions as os.OpenFile:
 // "r" for read-only, "w" for write-only (truncates), "a" for append, etc.
+// It is OpenContext with context.Background().
 func (sb *Sandbox) Open(path, mode string) (*SandboxFile, error) {
-	if err := sb.ensureTaskId(); err != nil {
+	return sb.OpenContext(context.Background(), path, mode)
+}
+
+// OpenContext opens a file in the sandbox filesystem. Only opening the file is
+// bound to ctx, and the SandboxFile can outlive it. See Open.
+func (sb *Sandbox) OpenContext(ctx context.Context, path, mode string) (*SandboxFile, error) {
+	ctx, err := sb.client.clientContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	taskId, err := sb.ensureTaskId(ctx)
+	if err != nil {
 		return nil, err
 	}
 
-	_, resp, err := runFilesystemExec(sb.ctx, pb.ContainerFilesystemExecRequest_builder{
+	_, resp, err := runFilesystemExec(ctx, sb.client, pb.ContainerFilesystemExecRequest_builder{
 		FileOpenRequest: pb.ContainerFileOpenRequest_builder{
 			Path: path,
 			Mode: mode,
 		}.Build(),
-		TaskId: sb.taskId,
+		TaskId: taskId,
 	}.Build(), nil)
 
 	if err != nil {
@@ -162,46 +210,72 @@ func (sb *Sandbox) Open(path, mode string) (*SandboxFile, error) {
 
 	return &SandboxFile{
 		fileDescriptor: resp.GetFileDescriptor(),
-		taskId:         sb.taskId,
-		ctx:            sb.ctx,
+		taskId:         taskId,
+		client:         sb.client,
 	}, nil
 }
 
-func (sb *Sandbox) ensureTaskId() error {
+// ensureTaskId returns the task ID of the sandbox, fetching it on first use.
+// Concurrent callers share a single SandboxGetTaskId request.
+func (sb *Sandbox) ensureTaskId(ctx context.Context) (string, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
 	if sb.taskId == "" {
-		resp, err := client.SandboxGetTaskId(sb.ctx, pb.SandboxGetTaskIdRequest_builder{
+		resp, err := sb.client.cpClient.SandboxGetTaskId(ctx, pb.SandboxGetTaskIdRequest_builder{
 			SandboxId: sb.SandboxId,
 		}.Build())
 		if err != nil {
-			return err
+			return "", err
 		}
 		if resp.GetTaskId() == "" {
-			return fmt.Errorf("Sandbox %s does not have a task ID, it may not be running", sb.SandboxId)
+			return "", fmt.Errorf("Sandbox %s does not have a task ID, it may not be running", sb.SandboxId)
 		}
 		if resp.GetTaskResult() != nil {
-			return fmt.Errorf("Sandbox %s has already completed with result: %v", sb.SandboxId, resp.GetTaskResult())
+			return "", fmt.Errorf("Sandbox %s has already completed with result: %v", sb.SandboxId, resp.GetTaskResult())
 		}
 		sb.taskId = resp.GetTaskId()
 	}
-	return nil
+	return sb.taskId, nil
 }
 
 // Terminate stops the sandbox.
 func (sb *Sandbox) Terminate() error {
-	_, err := client.SandboxTerminate(sb.ctx, pb.SandboxTerminateRequest_builder{
+	return sb.TerminateContext(context.Background())
+}
+
+// TerminateContext stops the sandbox.
+func (sb *Sandbox) TerminateContext(ctx context.Context) error {
+	ctx, err := sb.client.clientContext(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = sb.client.cpClient.SandboxTerminate(ctx, pb.SandboxTerminateRequest_builder{
 		SandboxId: sb.SandboxId,
 	}.Build())
 	if err != nil {
 		return err
 	}
+	sb.client.untrackSandbox(sb.SandboxId)
+	sb.mu.Lock()
 	sb.taskId = ""
+	sb.mu.Unlock()
 	return nil
 }
 
-// Wait blocks until the sandbox exits.
+// Wait blocks until the sandbox exits. It is WaitContext with
+// context.Background().
 func (sb *Sandbox) Wait() (int, error) {
+	return sb.WaitContext(context.Background())
+}
+
+// WaitContext blocks until the sandbox exits, or until ctx is done.
+func (sb *Sandbox) WaitContext(ctx context.Context) (int, error) {
+	ctx, err := sb.client.clientContext(ctx)
+	if err != nil {
+		return 0, err
+	}
 	for {
-		resp, err := client.SandboxWait(sb.ctx, pb.SandboxWaitRequest_builder{
+		resp, err := sb.client.cpClient.SandboxWait(ctx, pb.SandboxWaitRequest_builder{
 			SandboxId: sb.SandboxId,
 			Timeout:   10,
 		}.Build())
@@ -222,11 +296,23 @@ func (sb *Sandbox) Wait() (int, error) {
 // Returns SandboxTimeoutError if the tunnels are not available after the timeout.
 // Returns a map of Tunnel objects keyed by the container port.
 func (sb *Sandbox) Tunnels(timeout time.Duration) (map[int]*Tunnel, error) {
-	if sb.tunnels != nil {
-		return sb.tunnels, nil
+	return sb.TunnelsContext(context.Background(), timeout)
+}
+
+// TunnelsContext gets Tunnel metadata for the sandbox. See Tunnels.
+func (sb *Sandbox) TunnelsContext(ctx context.Context, timeout time.Duration) (map[int]*Tunnel, error) {
+	sb.mu.Lock()
+	tunnels := sb.tunnels
+	sb.mu.Unlock()
+	if tunnels != nil {
+		return tunnels, nil
 	}
 
-	resp, err := client.SandboxGetTunnels(sb.ctx, pb.SandboxGetTunnelsRequest_builder{
+	ctx, err := sb.client.clientContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := sb.client.cpClient.SandboxGetTunnels(ctx, pb.SandboxGetTunnelsRequest_builder{
 		SandboxId: sb.SandboxId,
 		Timeout:   float32(timeout.Seconds()),
 	}.Build())
@@ -238,9 +324,9 @@ func (sb *Sandbox) Tunnels(timeout time.Duration) (map[int]*Tunnel, error) {
 		return nil, SandboxTimeoutError{Exception: "Sandbox operation timed out"}
 	}
 
-	sb.tunnels = make(map[int]*Tunnel)
+	tunnels = make(map[int]*Tunnel)
 	for _, t := range resp.GetTunnels() {
-		sb.tunnels[int(t.GetContainerPort())] = &Tunnel{
+		tunnels[int(t.GetContainerPort())] = &Tunnel{
 			Host:            t.GetHost(),
 			Port:            int(t.GetPort()),
 			UnencryptedHost: t.GetUnencryptedHost(),
@@ -248,13 +334,25 @@ func (sb *Sandbox) Tunnels(timeout time.Duration) (map[int]*Tunnel, error) {
 		}
 	}
 
-	return sb.tunnels, nil
+	sb.mu.Lock()
+	sb.tunnels = tunnels
+	sb.mu.Unlock()
+	return tunnels, nil
 }
 
 // Snapshot the filesystem of the Sandbox.
 // Returns an Image object which can be used to spawn a new Sandbox with the same filesystem.
 func (sb *Sandbox) SnapshotFilesystem(timeout time.Duration) (*Image, error) {
-	resp, err := client.SandboxSnapshotFs(sb.ctx, pb.SandboxSnapshotFsRequest_builder{
+	return sb.SnapshotFilesystemContext(context.Background(), timeout)
+}
+
+// SnapshotFilesystemContext snapshots the filesystem of the Sandbox. See SnapshotFilesystem.
+func (sb *Sandbox) SnapshotFilesystemContext(ctx context.Context, timeout time.Duration) (*Image, error) {
+	ctx, err := sb.client.clientContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := sb.client.cpClient.SandboxSnapshotFs(ctx, pb.SandboxSnapshotFsRequest_builder{
 		SandboxId: sb.SandboxId,
 		Timeout:   float32(timeout.Seconds()),
 	}.Build())
@@ -270,13 +368,22 @@ func (sb *Sandbox) SnapshotFilesystem(timeout time.Duration) (*Image, error) {
 		return nil, ExecutionError{Exception: "Sandbox snapshot response missing image ID"}
 	}
 
-	return &Image{ImageId: resp.GetImageId(), ctx: sb.ctx}, nil
+	return &Image{ImageId: resp.GetImageId()}, nil
 }
 
 // Poll checks if the Sandbox has finished running.
 // Returns nil if the Sandbox is still running, else returns the exit code.
 func (sb *Sandbox) Poll() (*int, error) {
-	resp, err := client.SandboxWait(sb.ctx, pb.SandboxWaitRequest_builder{
+	return sb.PollContext(context.Background())
+}
+
+// PollContext checks if the Sandbox has finished running. See Poll.
+func (sb *Sandbox) PollContext(ctx context.Context) (*int, error) {
+	ctx, err := sb.client.clientContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := sb.client.cpClient.SandboxWait(ctx, pb.SandboxWaitRequest_builder{
 		SandboxId: sb.SandboxId,
 		Timeout:   0,
 	}.Build())
@@ -289,12 +396,21 @@ func (sb *Sandbox) Poll() (*int, error) {
 
 // SetTags sets key-value tags on the Sandbox. Tags can be used to filter results in SandboxList.
 func (sb *Sandbox) SetTags(tags map[string]string) error {
+	return sb.SetTagsContext(context.Background(), tags)
+}
+
+// SetTagsContext sets key-value tags on the Sandbox. See SetTags.
+func (sb *Sandbox) SetTagsContext(ctx context.Context, tags map[string]string) error {
+	ctx, err := sb.client.clientContext(ctx)
+	if err != nil {
+		return err
+	}
 	tagsList := make([]*pb.SandboxTag, 0, len(tags))
 	for k, v := range tags {
 		tagsList = append(tagsList, pb.SandboxTag_builder{TagName: k, TagValue: v}.Build())
 	}
-	_, err := client.SandboxTagsSet(sb.ctx, pb.SandboxTagsSetRequest_builder{
-		EnvironmentName: environmentName(""),
+	_, err = sb.client.cpClient.SandboxTagsSet(ctx, pb.SandboxTagsSetRequest_builder{
+		EnvironmentName: sb.client.environmentName(""),
 		SandboxId:       sb.SandboxId,
 		Tags:            tagsList,
 	}.Build())
@@ -308,14 +424,24 @@ type SandboxListOptions struct {
 	Environment string            // Override environment for this request
 }
 
-// SandboxList lists Sandboxes for the current environment (or provided App ID), optionally filtered by tags.
+// SandboxList lists Sandboxes for the current environment (or provided App ID), optionally filtered by tags,
+// using the default client.
 func SandboxList(ctx context.Context, options *SandboxListOptions) (iter.Seq2[*Sandbox, error], error) {
+	c, err := getDefaultClient()
+	if err != nil {
+		return nil, err
+	}
+	return c.SandboxList(ctx, options)
+}
+
+// SandboxList lists Sandboxes for the current environment (or provided App ID), optionally filtered by tags.
+func (c *Client) SandboxList(ctx context.Context, options *SandboxListOptions) (iter.Seq2[*Sandbox, error], error) {
 	if options == nil {
 		options = &SandboxListOptions{}
 	}
 
 	var err error
-	ctx, err = clientContext(ctx)
+	ctx, err = c.clientContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -328,10 +454,10 @@ func SandboxList(ctx context.Context, options *SandboxListOptions) (iter.Seq2[*S
 	return func(yield func(*Sandbox, error) bool) {
 		var before float64
 		for {
-			resp, err := client.SandboxList(ctx, pb.SandboxListRequest_builder{
+			resp, err := c.cpClient.SandboxList(ctx, pb.SandboxListRequest_builder{
 				AppId:           options.AppId,
 				BeforeTimestamp: before,
-				EnvironmentName: environmentName(options.Environment),
+				EnvironmentName: c.environmentName(options.Environment),
 				IncludeFinished: false,
 				Tags:            tagsList,
 			}.Build())
@@ -344,7 +470,7 @@ func SandboxList(ctx context.Context, options *SandboxListOptions) (iter.Seq2[*S
 				return
 			}
 			for _, info := range sandboxes {
-				if !yield(newSandbox(ctx, info.GetId()), nil) {
+				if !yield(newSandbox(ctx, c, info.GetId()), nil) {
 					return
 				}
 			}
@@ -381,11 +507,14 @@ type ContainerProcess struct {
 	Stdout io.ReadCloser
 	Stderr io.ReadCloser
 
-	ctx    context.Context
+	client *Client
 	execId string
 }
 
-func newContainerProcess(ctx context.Context, execId string, opts ExecOptions) *ContainerProcess {
+// newContainerProcess creates a ContainerProcess for an exec. Its streams send
+// RPCs with the client headers of ctx, but aren't cancelled with it.
+func newContainerProcess(ctx context.Context, client *Client, execId string, opts ExecOptions) *ContainerProcess {
+	ctx = context.WithoutCancel(ctx)
 	stdoutBehavior := Pipe
 	stderrBehavior := Pipe
 	if opts.Stdout != "" {
@@ -395,15 +524,15 @@ func newContainerProcess(ctx context.Context, execId string, opts ExecOptions) *
 		stderrBehavior = opts.Stderr
 	}
 
-	cp := &ContainerProcess{execId: execId, ctx: ctx}
-	cp.Stdin = inputStreamCp(ctx, execId)
+	cp := &ContainerProcess{execId: execId, client: client}
+	cp.Stdin = inputStreamCp(ctx, client, execId)
 
-	cp.Stdout = outputStreamCp(ctx, execId, pb.FileDescriptor_FILE_DESCRIPTOR_STDOUT)
+	cp.Stdout = outputStreamCp(ctx, client, execId, pb.FileDescriptor_FILE_DESCRIPTOR_STDOUT)
 	if stdoutBehavior == Ignore {
 		cp.Stdout.Close()
 		cp.Stdout = io.NopCloser(bytes.NewReader(nil))
 	}
-	cp.Stderr = outputStreamCp(ctx, execId, pb.FileDescriptor_FILE_DESCRIPTOR_STDERR)
+	cp.Stderr = outputStreamCp(ctx, client, execId, pb.FileDescriptor_FILE_DESCRIPTOR_STDERR)
 	if stderrBehavior == Ignore {
 		cp.Stderr.Close()
 		cp.Stderr = io.NopCloser(bytes.NewReader(nil))
@@ -412,10 +541,21 @@ func newContainerProcess(ctx context.Context, execId string, opts ExecOptions) *
 	return cp
 }
 
-// Wait blocks until the container process exits and returns its exit code.
+// Wait blocks until the container process exits and returns its exit code. It is
+// WaitContext with context.Background().
 func (cp *ContainerProcess) Wait() (int, error) {
+	return cp.WaitContext(context.Background())
+}
+
+// WaitContext blocks until the container process exits, or until ctx is done,
+// and returns its exit code.
+func (cp *ContainerProcess) WaitContext(ctx context.Context) (int, error) {
+	ctx, err := cp.client.clientContext(ctx)
+	if err != nil {
+		return 0, err
+	}
 	for {
-		resp, err := client.ContainerExecWait(cp.ctx, pb.ContainerExecWaitRequest_builder{
+		resp, err := cp.client.cpClient.ContainerExecWait(ctx, pb.ContainerExecWaitRequest_builder{
 			ExecId:  cp.execId,
 			Timeout: 55,
 		}.Build())
@@ -428,13 +568,14 @@ func (cp *ContainerProcess) Wait() (int, error) {
 	}
 }
 
-func inputStreamSb(ctx context.Context, sandboxId string) io.WriteCloser {
-	return &sbStdin{sandboxId: sandboxId, ctx: ctx, index: 1}
+func inputStreamSb(ctx context.Context, client *Client, sandboxId string) io.WriteCloser {
+	return &sbStdin{sandboxId: sandboxId, ctx: ctx, client: client, index: 1}
 }
 
 type sbStdin struct {
 	sandboxId string
 	ctx       context.Context // context for the sandbox operations
+	client    *Client
 
 	mu    sync.Mutex // protects index
 	index uint32
@@ -445,7 +586,7 @@ func (s *sbStdin) Write(p []byte) (n int, err error) {
 	defer s.mu.Unlock()
 	index := s.index
 	s.index++
-	_, err = client.SandboxStdinWrite(s.ctx, pb.SandboxStdinWriteRequest_builder{
+	_, err = s.client.cpClient.SandboxStdinWrite(s.ctx, pb.SandboxStdinWriteRequest_builder{
 		SandboxId: s.sandboxId,
 		Input:     p,
 		Index:     index,
@@ -459,7 +600,7 @@ func (s *sbStdin) Write(p []byte) (n int, err error) {
 func (s *sbStdin) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	_, err := client.SandboxStdinWrite(s.ctx, pb.SandboxStdinWriteRequest_builder{
+	_, err := s.client.cpClient.SandboxStdinWrite(s.ctx, pb.SandboxStdinWriteRequest_builder{
 		SandboxId: s.sandboxId,
 		Index:     s.index,
 		Eof:       true,
@@ -467,18 +608,23 @@ func (s *sbStdin) Close() error {
 	return err
 }
 
-func inputStreamCp(ctx context.Context, execId string) io.WriteCloser {
-	return &cpStdin{execId: execId, messageIndex: 1, ctx: ctx}
+func inputStreamCp(ctx context.Context, client *Client, execId string) io.WriteCloser {
+	return &cpStdin{execId: execId, messageIndex: 1, ctx: ctx, client: client}
 }
 
 type cpStdin struct {
-	execId       string
+	execId string
+	ctx    context.Context // context for the exec operations
+	client *Client
+
+	mu           sync.Mutex // protects messageIndex
 	messageIndex uint64
-	ctx          context.Context // context for the exec operations
 }
 
 func (c *cpStdin) Write(p []byte) (n int, err error) {
-	_, err = client.ContainerExecPutInput(c.ctx, pb.ContainerExecPutInputRequest_builder{
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err = c.client.cpClient.ContainerExecPutInput(c.ctx, pb.ContainerExecPutInputRequest_builder{
 		ExecId: c.execId,
 		Input: pb.RuntimeInputMessage_builder{
 			Message:      p,
@@ -493,7 +639,9 @@ func (c *cpStdin) Write(p []byte) (n int, err error) {
 }
 
 func (c *cpStdin) Close() error {
-	_, err := client.ContainerExecPutInput(c.ctx, pb.ContainerExecPutInputRequest_builder{
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.client.cpClient.ContainerExecPutInput(c.ctx, pb.ContainerExecPutInputRequest_builder{
 		ExecId: c.execId,
 		Input: pb.RuntimeInputMessage_builder{
 			MessageIndex: c.messageIndex,
@@ -503,23 +651,22 @@ func (c *cpStdin) Close() error {
 	return err
 }
 
-func outputStreamSb(ctx context.Context, sandboxId string, fd pb.FileDescriptor) io.ReadCloser {
+func outputStreamSb(ctx context.Context, client *Client, sandboxId string, fd pb.FileDescriptor) io.ReadCloser {
 	pr, pw := nio.Pipe(buffer.New(64 * 1024))
-	go func() {
+	client.goBackground(ctx, func(ctx context.Context) {
 		defer pw.Close()
 		lastIndex := "0-0"
 		completed := false
-		retries := 10
+		retrier := client.newStreamRetrier(ctx, "SandboxGetLogs", "sandbox_id", sandboxId, "fd", fd.String())
 		for !completed {
-			stream, err := client.SandboxGetLogs(ctx, pb.SandboxGetLogsRequest_builder{
+			stream, err := client.cpClient.SandboxGetLogs(ctx, pb.SandboxGetLogsRequest_builder{
 				SandboxId:      sandboxId,
 				FileDescriptor: fd,
 				Timeout:        55,
 				LastEntryId:    lastIndex,
 			}.Build())
 			if err != nil {
-				if isRetryableGrpc(err) && retries > 0 {
-					retries--
+				if retrier.retry(err) {
 					continue
 				}
 				pw.CloseWithError(fmt.Errorf("error getting output stream: %w", err))
@@ -529,9 +676,7 @@ func outputStreamSb(ctx context.Context, sandboxId string, fd pb.FileDescriptor)
 				batch, err := stream.Recv()
 				if err != nil {
 					if err != io.EOF {
-						if isRetryableGrpc(err) && retries > 0 {
-							retries--
-						} else {
+						if !retrier.retry(err) {
 							pw.CloseWithError(fmt.Errorf("error getting output stream: %w", err))
 							return
 						}
@@ -549,19 +694,19 @@ func outputStreamSb(ctx context.Context, sandboxId string, fd pb.FileDescriptor)
 				}
 			}
 		}
-	}()
+	})
 	return pr
 }
 
-func outputStreamCp(ctx context.Context, execId string, fd pb.FileDescriptor) io.ReadCloser {
+func outputStreamCp(ctx context.Context, client *Client, execId string, fd pb.FileDescriptor) io.ReadCloser {
 	pr, pw := nio.Pipe(buffer.New(64 * 1024))
-	go func() {
+	client.goBackground(ctx, func(ctx context.Context) {
 		defer pw.Close()
 		var lastIndex uint64
 		completed := false
-		retries := 10
+		retrier := client.newStreamRetrier(ctx, "ContainerExecGetOutput", "exec_id", execId, "fd", fd.String())
 		for !completed {
-			stream, err := client.ContainerExecGetOutput(ctx, pb.ContainerExecGetOutputRequest_builder{
+			stream, err := client.cpClient.ContainerExecGetOutput(ctx, pb.ContainerExecGetOutputRequest_builder{
 				ExecId:         execId,
 				FileDescriptor: fd,
 				Timeout:        55,
@@ -569,8 +714,7 @@ func outputStreamCp(ctx context.Context, execId string, fd pb.FileDescriptor) io
 				LastBatchIndex: lastIndex,
 			}.Build())
 			if err != nil {
-				if isRetryableGrpc(err) && retries > 0 {
-					retries--
+				if retrier.retry(err) {
 					continue
 				}
 				pw.CloseWithError(fmt.Errorf("error getting output stream: %w", err))
@@ -580,9 +724,7 @@ func outputStreamCp(ctx context.Context, execId string, fd pb.FileDescriptor) io
 				batch, err := stream.Recv()
 				if err != nil {
 					if err != io.EOF {
-						if isRetryableGrpc(err) && retries > 0 {
-							retries--
-						} else {
+						if !retrier.retry(err) {
 							pw.CloseWithError(fmt.Errorf("error getting output stream: %w", err))
 							return
 						}
@@ -600,6 +742,6 @@ func outputStreamCp(ctx context.Context, execId string, fd pb.FileDescriptor) io
 				}
 			}
 		}
-	}()
+	})
 	return pr
 }
@@ -12,14 +12,19 @@ import (
 type SandboxFile struct {
 	fileDescriptor string
 	taskId         string
-	ctx            context.Context
+	client         *Client
 }
 
 // Read reads up to len(p) bytes from the file into p.
 // It returns the number of bytes read and any error encountered.
 func (f *SandboxFile) Read(p []byte) (int, error) {
+	return f.ReadContext(context.Background(), p)
+}
+
+// ReadContext reads up to len(p) bytes from the file into p.
+func (f *SandboxFile) ReadContext(ctx context.Context, p []byte) (int, error) {
 	nBytes := uint32(len(p))
-	totalRead, _, err := runFilesystemExec(f.ctx, pb.ContainerFilesystemExecRequest_builder{
+	totalRead, _, err := runFilesystemExec(ctx, f.client, pb.ContainerFilesystemExecRequest_builder{
 		FileReadRequest: pb.ContainerFileReadRequest_builder{
 			FileDescriptor: f.fileDescriptor,
 			N:              &nBytes,
@@ -38,7 +43,12 @@ func (f *SandboxFile) Read(p []byte) (int, error) {
 // Write writes len(p) bytes from p to the file.
 // It returns the number of bytes written and any error encountered.
 func (f *SandboxFile) Write(p []byte) (n int, err error) {
-	_, _, err = runFilesystemExec(f.ctx, pb.ContainerFilesystemExecRequest_builder{
+	return f.WriteContext(context.Background(), p)
+}
+
+// WriteContext writes len(p) bytes from p to the file.
+func (f *SandboxFile) WriteContext(ctx context.Context, p []byte) (n int, err error) {
+	_, _, err = runFilesystemExec(ctx, f.client, pb.ContainerFilesystemExecRequest_builder{
 		FileWriteRequest: pb.ContainerFileWriteRequest_builder{
 			FileDescriptor: f.fileDescriptor,
 			Data:           p,
@@ -53,7 +63,12 @@ func (f *SandboxFile) Write(p []byte) (n int, err error) {
 
 // Flush flushes any buffered data to the file.
 func (f *SandboxFile) Flush() error {
-	_, _, err := runFilesystemExec(f.ctx, pb.ContainerFilesystemExecRequest_builder{
+	return f.FlushContext(context.Background())
+}
+
+// FlushContext flushes any buffered data to the file.
+func (f *SandboxFile) FlushContext(ctx context.Context) error {
+	_, _, err := runFilesystemExec(ctx, f.client, pb.ContainerFilesystemExecRequest_builder{
 		FileFlushRequest: pb.ContainerFileFlushRequest_builder{
 			FileDescriptor: f.fileDescriptor,
 		}.Build(),
@@ -67,7 +82,12 @@ func (f *SandboxFile) Flush() error {
 
 // Close closes the file, rendering it unusable for I/O.
 func (f *SandboxFile) Close() error {
-	_, _, err := runFilesystemExec(f.ctx, pb.ContainerFilesystemExecRequest_builder{
+	return f.CloseContext(context.Background())
+}
+
+// CloseContext closes the file, rendering it unusable for I/O.
+func (f *SandboxFile) CloseContext(ctx context.Context) error {
+	_, _, err := runFilesystemExec(ctx, f.client, pb.ContainerFilesystemExecRequest_builder{
 		FileCloseRequest: pb.ContainerFileCloseRequest_builder{
 			FileDescriptor: f.fileDescriptor,
 		}.Build(),
@@ -79,22 +99,25 @@ func (f *SandboxFile) Close() error {
 	return nil
 }
 
-func runFilesystemExec(ctx context.Context, req *pb.ContainerFilesystemExecRequest, p []byte) (int, *pb.ContainerFilesystemExecResponse, error) {
-	resp, err := client.ContainerFilesystemExec(ctx, req)
+func runFilesystemExec(ctx context.Context, client *Client, req *pb.ContainerFilesystemExecRequest, p []byte) (int, *pb.ContainerFilesystemExecResponse, error) {
+	ctx, err := client.clientContext(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	resp, err := client.cpClient.ContainerFilesystemExec(ctx, req)
 	if err != nil {
 		return 0, nil, err
 	}
-	retries := 10
+	retrier := client.newStreamRetrier(ctx, "ContainerFilesystemExecGetOutput", "exec_id", resp.GetExecId())
 	totalRead := 0
 
 	for {
-		outputIterator, err := client.ContainerFilesystemExecGetOutput(ctx, pb.ContainerFilesystemExecGetOutputRequest_builder{
+		outputIterator, err := client.cpClient.ContainerFilesystemExecGetOutput(ctx, pb.ContainerFilesystemExecGetOutputRequest_builder{
 			ExecId:  resp.GetExecId(),
 			Timeout: 55,
 		}.Build())
 		if err != nil {
-			if isRetryableGrpc(err) && retries > 0 {
-				retries--
+			if retrier.retry(err) {
 				continue
 			}
 			return 0, nil, err
@@ -106,8 +129,7 @@ func runFilesystemExec(ctx context.Context, req *pb.ContainerFilesystemExecReque
 				break
 			}
 			if err != nil {
-				if isRetryableGrpc(err) && retries > 0 {
-					retries--
+				if retrier.retry(err) {
 					break
 				}
 				return 0, nil, err
@@ -21,10 +21,19 @@ type SecretFromNameOptions struct {
 	RequiredKeys []string
 }
 
-// SecretFromName references a modal.Secret by its name.
+// SecretFromName references a modal.Secret by its name, using the default client.
 func SecretFromName(ctx context.Context, name string, options *SecretFromNameOptions) (*Secret, error) {
+	c, err := getDefaultClient()
+	if err != nil {
+		return nil, err
+	}
+	return c.SecretFromName(ctx, name, options)
+}
+
+// SecretFromName references a modal.Secret by its name.
+func (c *Client) SecretFromName(ctx context.Context, name string, options *SecretFromNameOptions) (*Secret, error) {
 	var err error
-	ctx, err = clientContext(ctx)
+	ctx, err = c.clientContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -33,9 +42,9 @@ func SecretFromName(ctx context.Context, name string, options *SecretFromNameOpt
 		options = &SecretFromNameOptions{}
 	}
 
-	resp, err := client.SecretGetOrCreate(ctx, pb.SecretGetOrCreateRequest_builder{
+	resp, err := c.cpClient.SecretGetOrCreate(ctx, pb.SecretGetOrCreateRequest_builder{
 		DeploymentName:  name,
-		EnvironmentName: environmentName(options.Environment),
+		EnvironmentName: c.environmentName(options.Environment),
 		RequiredKeys:    options.RequiredKeys,
 	}.Build())
 
@@ -51,10 +60,19 @@ type SecretFromMapOptions struct {
 	Environment string
 }
 
-// SecretFromMap creates a Secret from a map of key-value pairs.
+// SecretFromMap creates a Secret from a map of key-value pairs, using the default client.
 func SecretFromMap(ctx context.Context, keyValuePairs map[string]string, options *SecretFromMapOptions) (*Secret, error) {
+	c, err := getDefaultClient()
+	if err != nil {
+		return nil, err
+	}
+	return c.SecretFromMap(ctx, keyValuePairs, options)
+}
+
+// SecretFromMap creates a Secret from a map of key-value pairs.
+func (c *Client) SecretFromMap(ctx context.Context, keyValuePairs map[string]string, options *SecretFromMapOptions) (*Secret, error) {
 	var err error
-	ctx, err = clientContext(ctx)
+	ctx, err = c.clientContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -63,10 +81,10 @@ func SecretFromMap(ctx context.Context, keyValuePairs map[string]string, options
 		options = &SecretFromMapOptions{}
 	}
 
-	resp, err := client.SecretGetOrCreate(ctx, pb.SecretGetOrCreateRequest_builder{
+	resp, err := c.cpClient.SecretGetOrCreate(ctx, pb.SecretGetOrCreateRequest_builder{
 		ObjectCreationType: pb.ObjectCreationType_OBJECT_CREATION_TYPE_EPHEMERAL,
 		EnvDict:            keyValuePairs,
-		EnvironmentName:    environmentName(options.Environment),
+		EnvironmentName:    c.environmentName(options.Environment),
 	}.Build())
 	if err != nil {
 		return nil, err
@@ -3,8 +3,17 @@ package modal
 // Test to make sure serialization behaviors are consistent.
 
 import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+	_ "time/tzdata" // for zoneinfo time zones
 
+	pickle "github.com/kisielk/og-rek"
 	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
 	"github.com/onsi/gomega"
 )
@@ -48,3 +57,370 @@ func TestParameterSerialization(t *testing.T) {
 	byteData = []byte("\n\x08\n\x01x\x10\x042\x01\x00")
 	g.Expect(serializedParams).Should(gomega.Equal(byteData))
 }
+
+var paris, _ = time.LoadLocation("Europe/Paris")
+
+// Pickles produced by CPython 3.11, with pickle.dumps(value, protocol=2) and the
+// default protocol 5 of cloudpickle, which the Python SDK uses.
+func TestPickleDeserializeCPython(t *testing.T) {
+	t.Parallel()
+
+	hugeInt := new(big.Int).Lsh(big.NewInt(1), 2048)
+	for _, tc := range []struct {
+		python   string
+		protocol map[int]string // pickles by protocol, in hex
+		expected any
+	}{
+		{
+			python: "{1, 2}",
+			protocol: map[int]string{
+				2: "8002635f5f6275696c74696e5f5f0a7365740a71005d7101284b014b02658571025271032e",
+				5: "80059509000000000000008f94284b014b02902e",
+			},
+			expected: Set{int64(1), int64(2)},
+		},
+		{
+			python: "set()",
+			protocol: map[int]string{
+				2: "8002635f5f6275696c74696e5f5f0a7365740a71005d71018571025271032e",
+				5: "80058f942e",
+			},
+			expected: Set{},
+		},
+		{
+			python: "frozenset({'a'})",
+			protocol: map[int]string{
+				2: "8002635f5f6275696c74696e5f5f0a66726f7a656e7365740a71005d71015801000000617102618571035271042e",
+				5: "8005950800000000000000288c01619491942e",
+			},
+			expected: FrozenSet{"a"},
+		},
+		{
+			python: "datetime.datetime(2024, 1, 2, 3, 4, 5, 6)",
+			protocol: map[int]string{
+				2: "8002636461746574696d650a6461746574696d650a7100635f636f646563730a656e636f64650a7101580b00000007c3a80102030405000006710258060000006c6174696e3171038671045271058571065271072e",
+				5: "8005952a000000000000008c086461746574696d65948c086461746574696d65949394430a07e8010203040500000694859452942e",
+			},
+			expected: NaiveDateTime{time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)},
+		},
+		{
+			python: "datetime.datetime(2024, 1, 2, 3, 4, 5, 6, tzinfo=datetime.timezone.utc)",
+			protocol: map[int]string{
+				2: "8002636461746574696d650a6461746574696d650a7100635f636f646563730a656e636f64650a7101580b00000007c3a80102030405000006710258060000006c6174696e317103867104527105636461746574696d650a74696d657a6f6e650a7106636461746574696d650a74696d6564656c74610a71074b004b004b0087710852710985710a52710b86710c52710d2e",
+				5: "80059557000000000000008c086461746574696d65948c086461746574696d65949394430a07e801020304050000069468008c0874696d657a6f6e6594939468008c0974696d6564656c74619493944b004b004b008794529485945294869452942e",
+			},
+			expected: time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC),
+		},
+		{
+			python: "datetime.datetime(2024, 1, 2, tzinfo=datetime.timezone(datetime.timedelta(hours=-5), 'EST'))",
+			protocol: map[int]string{
+				2: "8002636461746574696d650a6461746574696d650a7100635f636f646563730a656e636f64650a7101580b00000007c3a80102000000000000710258060000006c6174696e317103867104527105636461746574696d650a74696d657a6f6e650a7106636461746574696d650a74696d6564656c74610a71074affffffff4a300b01004b008771085271095803000000455354710a86710b52710c86710d52710e2e",
+				5: "80059563000000000000008c086461746574696d65948c086461746574696d65949394430a07e801020000000000009468008c0874696d657a6f6e6594939468008c0974696d6564656c74619493944affffffff4a300b01004b00879452948c034553549486945294869452942e",
+			},
+			expected: time.Date(2024, 1, 2, 0, 0, 0, 0, time.FixedZone("EST", -5*60*60)),
+		},
+		{
+			python: "datetime.datetime(2024, 6, 1, 12, tzinfo=zoneinfo.ZoneInfo('Europe/Paris'))",
+			protocol: map[int]string{
+				2: "8002636461746574696d650a6461746574696d650a7100635f636f646563730a656e636f64650a7101580b00000007c3a806010c0000000000710258060000006c6174696e317103867104527105635f5f6275696c74696e5f5f0a676574617474720a7106637a6f6e65696e666f0a5a6f6e65496e666f0a710758090000005f756e7069636b6c65710886710952710a580c0000004575726f70652f5061726973710b4b0186710c52710d86710e52710f2e",
+				5: "8005957e000000000000008c086461746574696d65948c086461746574696d65949394430a07e806010c0000000000948c086275696c74696e73948c07676574617474729493948c087a6f6e65696e666f948c085a6f6e65496e666f9493948c095f756e7069636b6c6594869452948c0c4575726f70652f5061726973944b0186945294869452942e",
+			},
+			expected: time.Date(2024, 6, 1, 12, 0, 0, 0, paris),
+		},
+		{
+			python: "datetime.date(2024, 2, 29)",
+			protocol: map[int]string{
+				2: "8002636461746574696d650a646174650a7100635f636f646563730a656e636f64650a7101580500000007c3a8021d710258060000006c6174696e3171038671045271058571065271072e",
+				5: "80059520000000000000008c086461746574696d65948c0464617465949394430407e8021d94859452942e",
+			},
+			expected: Date{Year: 2024, Month: time.February, Day: 29},
+		},
+		{
+			python: "decimal.Decimal('1.50')",
+			protocol: map[int]string{
+				2: "800263646563696d616c0a446563696d616c0a71005804000000312e353071018571025271032e",
+				5: "80059522000000000000008c07646563696d616c948c07446563696d616c9493948c04312e353094859452942e",
+			},
+			expected: Decimal("1.50"),
+		},
+		{
+			python: "(1, 'a', None)",
+			protocol: map[int]string{
+				2: "80024b0158010000006171004e8771012e",
+				5: "8005950a000000000000004b018c0161944e87942e",
+			},
+			expected: pickle.Tuple{int64(1), "a", pickle.None{}},
+		},
+		{
+			python: `b'\x00\xff'`,
+			protocol: map[int]string{
+				2: "8002635f636f646563730a656e636f64650a7100580300000000c3bf710158060000006c6174696e3171028671035271042e",
+				5: "8005950600000000000000430200ff942e",
+			},
+			expected: []byte{0x00, 0xff},
+		},
+		{
+			python: "bytearray(b'ab')",
+			protocol: map[int]string{
+				2: "8002635f5f6275696c74696e5f5f0a6279746561727261790a7100635f636f646563730a656e636f64650a710158020000006162710258060000006c6174696e3171038671045271058571065271072e",
+				5: "8005950d000000000000009602000000000000006162942e",
+			},
+			expected: ByteArray("ab"),
+		},
+		{
+			python: "2**40",
+			protocol: map[int]string{
+				2: "80028a060000000000012e",
+				5: "80059509000000000000008a060000000000012e",
+			},
+			expected: int64(1 << 40),
+		},
+		{
+			python: "-2**100",
+			protocol: map[int]string{
+				2: "80028a0d000000000000000000000000f02e",
+				5: "80059510000000000000008a0d000000000000000000000000f02e",
+			},
+			expected: new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), 100)),
+		},
+		{
+			python: "2**2048",
+			protocol: map[int]string{
+				2: "80028b01010000" + strings.Repeat("00", 256) + "012e",
+				5: "80059507010000000000008b01010000" + strings.Repeat("00", 256) + "012e",
+			},
+			expected: hugeInt,
+		},
+		{
+			python: "{datetime.date(2024, 1, 1): 1, b'k': 2}",
+			protocol: map[int]string{
+				2: "80027d710028636461746574696d650a646174650a7101635f636f646563730a656e636f64650a7102580500000007c3a80101710358060000006c6174696e3171048671055271068571075271084b01680258010000006b7109680486710a52710b4b02752e",
+				5: "8005952c000000000000007d94288c086461746574696d65948c0464617465949394430407e8010194859452944b0143016b944b02752e",
+			},
+			// bytes keys are left as pickle.Bytes, since []byte can't be a map key.
+			expected: map[any]any{Date{Year: 2024, Month: time.January, Day: 1}: int64(1), pickle.Bytes("k"): int64(2)},
+		},
+		{
+			python: "e, where e = ValueError('bad'); e.code = 1",
+			protocol: map[int]string{
+				5: "80059532000000000000008c086275696c74696e73948c0a56616c75654572726f729493948c0362616494859452947d948c04636f6465944b0173622e",
+			},
+			expected: PythonObject{
+				Class: pickle.Class{Module: "builtins", Name: "ValueError"},
+				Args:  pickle.Tuple{"bad"},
+				State: map[any]any{"code": int64(1)},
+			},
+		},
+		{
+			python: "P(1, 'a'), where P is the dataclass shapes.P(x: int, y: str)",
+			protocol: map[int]string{
+				2: "8002637368617065730a500a7100298171017d71022858010000007871034b015801000000797104580100000061710575622e",
+				5: "80059526000000000000008c06736861706573948c01509493942981947d94288c0178944b018c0179948c01619475622e",
+			},
+			expected: PythonObject{
+				Class: pickle.Class{Module: "shapes", Name: "P"},
+				Args:  pickle.Tuple{},
+				State: map[any]any{"x": int64(1), "y": "a"},
+			},
+		},
+		{
+			python: "K(1, b=2), where K.__getnewargs_ex__ returns ((1,), {'b': 2})",
+			protocol: map[int]string{
+				5: "80059529000000000000008c06736861706573948c014b9493944b0185947d948c0162944b027392947d948c0161944b0173622e",
+			},
+			expected: PythonObject{
+				Class:  pickle.Class{Module: "shapes", Name: "K"},
+				Args:   pickle.Tuple{int64(1)},
+				Kwargs: map[any]any{"b": int64(2)},
+				State:  map[any]any{"a": int64(1)},
+			},
+		},
+		{
+			python: "[s, s], where s = {1}",
+			protocol: map[int]string{
+				2: "80025d710028635f5f6275696c74696e5f5f0a7365740a71015d71024b01618571035271046804652e",
+				5: "8005950d000000000000005d94288f94284b01906801652e",
+			},
+			expected: []any{Set{int64(1)}, Set{int64(1)}},
+		},
+	} {
+		for protocol, data := range tc.protocol {
+			t.Run(fmt.Sprintf("%s/%d", tc.python, protocol), func(t *testing.T) {
+				t.Parallel()
+				g := gomega.NewWithT(t)
+
+				b, err := hex.DecodeString(data)
+				g.Expect(err).ShouldNot(gomega.HaveOccurred())
+				v, err := pickleDeserialize(b)
+				g.Expect(err).ShouldNot(gomega.HaveOccurred())
+				g.Expect(v).To(gomega.Equal(tc.expected))
+			})
+		}
+	}
+}
+
+// The expected pickles were checked to load as the expected value in CPython 3.11.
+func TestPickleSerialize(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		python   string
+		value    any
+		expected string // pickle in hex
+	}{
+		{
+			python:   "'héllo'",
+			value:    "héllo",
+			expected: "80048c0668c3a96c6c6f2e",
+		},
+		{
+			python:   `b'\x00\xff'`,
+			value:    []byte{0x00, 0xff},
+			expected: "8004430200ff2e",
+		},
+		{
+			python:   "bytearray(b'ab')",
+			value:    ByteArray("ab"),
+			expected: "80048c086275696c74696e738c09627974656172726179934302616285522e",
+		},
+		{
+			python:   "{1}",
+			value:    Set{1},
+			expected: "80048c086275696c74696e738c0373657493284b016c85522e",
+		},
+		{
+			python:   "frozenset({'a'})",
+			value:    FrozenSet{"a"},
+			expected: "80048c086275696c74696e738c0966726f7a656e73657493288c01616c85522e",
+		},
+		{
+			python:   "datetime.datetime(2024, 1, 2, 3, 4, 5, 6, tzinfo=datetime.timezone.utc)",
+			value:    time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC),
+			expected: "80048c086461746574696d658c086461746574696d6593430a07e801020304050000068c086461746574696d658c0874696d657a6f6e65938c086461746574696d658c0974696d6564656c7461934b004b004b008752855286522e",
+		},
+		{
+			python:   "datetime.datetime(2024, 1, 2, tzinfo=datetime.timezone(datetime.timedelta(hours=-5), 'EST'))",
+			value:    time.Date(2024, 1, 2, 0, 0, 0, 0, time.FixedZone("EST", -5*60*60)),
+			expected: "80048c086461746574696d658c086461746574696d6593430a07e801020000000000008c086461746574696d658c0874696d657a6f6e65938c086461746574696d658c0974696d6564656c7461934affffffff4a300b01004b0087528c03455354865286522e",
+		},
+		{
+			python:   "datetime.datetime(2024, 1, 2, 3, 4, 5, 6)",
+			value:    NaiveDateTime{time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)},
+			expected: "80048c086461746574696d658c086461746574696d6593430a07e8010203040500000685522e",
+		},
+		{
+			python:   "datetime.date(2024, 2, 29)",
+			value:    Date{Year: 2024, Month: time.February, Day: 29},
+			expected: "80048c086461746574696d658c046461746593430407e8021d85522e",
+		},
+		{
+			python:   "decimal.Decimal('1.50')",
+			value:    Decimal("1.50"),
+			expected: "80048c07646563696d616c8c07446563696d616c938c04312e353085522e",
+		},
+		{
+			python:   "2**100",
+			value:    new(big.Int).Lsh(big.NewInt(1), 100),
+			expected: "80044c313236373635303630303232383232393430313439363730333230353337364c0a2e",
+		},
+		{
+			python:   "2**64 - 1",
+			value:    uint64(math.MaxUint64),
+			expected: "80044931383434363734343037333730393535313631350a2e",
+		},
+		{
+			python:   "(1, 'a')",
+			value:    pickle.Tuple{1, "a"},
+			expected: "80044b018c0161862e",
+		},
+	} {
+		t.Run(tc.python, func(t *testing.T) {
+			t.Parallel()
+			g := gomega.NewWithT(t)
+
+			buf, err := pickleSerialize(tc.value)
+			g.Expect(err).ShouldNot(gomega.HaveOccurred())
+			g.Expect(hex.EncodeToString(buf.Bytes())).To(gomega.Equal(tc.expected))
+		})
+	}
+
+	g := gomega.NewWithT(t)
+	_, err := pickleSerialize(time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC))
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("out of the range of Python datetimes")))
+}
+
+// Values round-trip through the pickle codec.
+func TestPickleRoundTrip(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	values := []any{
+		"héllo", []byte("bytes"), ByteArray("bytearray"), Set{int64(1), "a"}, FrozenSet{int64(2)},
+		time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC),
+		time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("EST", -5*60*60)),
+		NaiveDateTime{time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC)},
+		Date{Year: 9999, Month: time.December, Day: 31},
+		Decimal("-Infinity"),
+		int64(math.MinInt64), new(big.Int).Lsh(big.NewInt(1), 64),
+		pickle.Tuple{int64(1), pickle.Tuple{}},
+		map[any]any{"k": []any{Set{}}},
+	}
+	buf, err := pickleSerialize(values)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	v, err := pickleDeserialize(buf.Bytes())
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(v).To(gomega.Equal(values))
+
+	_, err = pickleDeserialize(buf.Bytes()[:buf.Len()/2])
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestPickleDeserializeInvalidOperands(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	// Persistent IDs with the tags of rewritten opcodes, but invalid operands, are
+	// errors.
+	tag := func(tag string) string { return "\x8c" + string(rune(len(tag))) + tag }
+	for _, data := range []string{
+		"\x80\x04\x8fK\x01" + tag(pickleOpAddItems) + "\x87Q.",
+		"\x80\x04K\x01" + tag(pickleOpFrozenSet) + "\x86Q.",
+		"\x80\x04K\x01)" + tag(pickleOpNewobj) + "\x87Q.",
+	} {
+		_, err := pickleDeserialize([]byte(data))
+		g.Expect(err).To(gomega.HaveOccurred(), "%q", data)
+	}
+}
+
+func TestPickleDeserializeCycles(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	mustDecodeHex := func(data string) []byte {
+		b, err := hex.DecodeString(data)
+		g.Expect(err).ShouldNot(gomega.HaveOccurred())
+		return b
+	}
+
+	// d = {}; d["a"] = d
+	v, err := pickleDeserialize(mustDecodeHex("8004950a000000000000007d948c0161946800732e"))
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	d := v.(map[any]any)
+	g.Expect(d).To(gomega.HaveLen(1))
+	g.Expect(reflect.ValueOf(d["a"]).Pointer()).To(gomega.Equal(reflect.ValueOf(d).Pointer()))
+
+	// d = {}; [d, d]
+	v, err = pickleDeserialize(mustDecodeHex("80025d7100287d71016801652e"))
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	l := v.([]any)
+	g.Expect(reflect.ValueOf(l[0]).Pointer()).To(gomega.Equal(reflect.ValueOf(l[1]).Pointer()))
+
+	// An object whose state is (self,).
+	v, err = pickleDeserialize(mustDecodeHex("8002635f5f6d61696e5f5f0a430a7100295271016801857102622e"))
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	obj := v.(PythonObject)
+	g.Expect(obj.Class).To(gomega.Equal(pickle.Class{Module: "__main__", Name: "C"}))
+	self := obj.State.(pickle.Tuple)[0].(*PythonObject)
+	g.Expect(self.Class).To(gomega.Equal(obj.Class))
+	g.Expect(self.State.(pickle.Tuple)[0]).To(gomega.BeIdenticalTo(self))
+}
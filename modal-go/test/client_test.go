@@ -0,0 +1,60 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	modal "github.com/modal-labs/libmodal/modal-go"
+	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+	"github.com/modal-labs/libmodal/modal-go/testsupport/grpcmock"
+	"github.com/onsi/gomega"
+)
+
+func TestNewClientUsesOwnEnvironment(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	mock, cleanup := grpcmock.Install()
+	t.Cleanup(cleanup)
+
+	clientA, err := modal.NewClient(modal.ClientOptions{TokenId: "ak-a", TokenSecret: "as-a", Environment: "env-a"})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	clientB, err := modal.NewClient(modal.ClientOptions{TokenId: "ak-b", TokenSecret: "as-b", Environment: "env-b"})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+	g.Expect(clientA.Profile().TokenId).To(gomega.Equal("ak-a"))
+	g.Expect(clientB.Profile().Environment).To(gomega.Equal("env-b"))
+
+	grpcmock.HandleUnary(
+		mock, "FunctionGet",
+		func(req *pb.FunctionGetRequest) (*pb.FunctionGetResponse, error) {
+			g.Expect(req.GetEnvironmentName()).To(gomega.Equal("env-a"))
+			return pb.FunctionGetResponse_builder{FunctionId: "fid-a"}.Build(), nil
+		},
+	)
+	grpcmock.HandleUnary(
+		mock, "FunctionGet",
+		func(req *pb.FunctionGetRequest) (*pb.FunctionGetResponse, error) {
+			g.Expect(req.GetEnvironmentName()).To(gomega.Equal("env-b"))
+			return pb.FunctionGetResponse_builder{FunctionId: "fid-b"}.Build(), nil
+		},
+	)
+
+	fa, err := clientA.FunctionLookup(context.Background(), "libmodal-test-support", "echo_string", nil)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(fa.FunctionId).To(gomega.Equal("fid-a"))
+
+	fb, err := clientB.FunctionLookup(context.Background(), "libmodal-test-support", "echo_string", nil)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(fb.FunctionId).To(gomega.Equal("fid-b"))
+
+	// An explicit environment in the options still takes precedence.
+	grpcmock.HandleUnary(
+		mock, "FunctionGet",
+		func(req *pb.FunctionGetRequest) (*pb.FunctionGetResponse, error) {
+			g.Expect(req.GetEnvironmentName()).To(gomega.Equal("override"))
+			return pb.FunctionGetResponse_builder{FunctionId: "fid-c"}.Build(), nil
+		},
+	)
+	_, err = clientA.FunctionLookup(context.Background(), "libmodal-test-support", "echo_string", &modal.LookupOptions{Environment: "override"})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+}
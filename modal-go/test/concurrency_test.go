@@ -0,0 +1,152 @@
+package test
+
+// Tests that drive the SDK from many goroutines. Run with `go test -race`.
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	pickle "github.com/kisielk/og-rek"
+	modal "github.com/modal-labs/libmodal/modal-go"
+	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+	"github.com/modal-labs/libmodal/modal-go/testsupport/grpcmock"
+	"github.com/onsi/gomega"
+)
+
+const concurrentCalls = 50
+
+func TestFunctionRemoteConcurrent(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	mock, cleanup := grpcmock.Install()
+	t.Cleanup(cleanup)
+
+	var buf bytes.Buffer
+	g.Expect(pickle.NewEncoder(&buf).Encode("output: hello")).To(gomega.Succeed())
+
+	for range concurrentCalls {
+		grpcmock.HandleUnary(
+			mock, "FunctionMap",
+			func(req *pb.FunctionMapRequest) (*pb.FunctionMapResponse, error) {
+				return pb.FunctionMapResponse_builder{
+					FunctionCallId:  "fc-123",
+					PipelinedInputs: []*pb.FunctionPutInputsResponseItem{pb.FunctionPutInputsResponseItem_builder{InputJwt: "jwt"}.Build()},
+				}.Build(), nil
+			},
+		)
+		grpcmock.HandleUnary(
+			mock, "FunctionGetOutputs",
+			func(req *pb.FunctionGetOutputsRequest) (*pb.FunctionGetOutputsResponse, error) {
+				return pb.FunctionGetOutputsResponse_builder{
+					Outputs: []*pb.FunctionGetOutputsItem{pb.FunctionGetOutputsItem_builder{
+						Result: pb.GenericResult_builder{
+							Status: pb.GenericResult_GENERIC_STATUS_SUCCESS,
+							Data:   buf.Bytes(),
+						}.Build(),
+						DataFormat: pb.DataFormat_DATA_FORMAT_PICKLE,
+					}.Build()},
+				}.Build(), nil
+			},
+		)
+	}
+
+	f := &modal.Function{FunctionId: "fid-concurrent"}
+
+	var wg sync.WaitGroup
+	for range concurrentCalls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := f.Remote([]any{"hello"}, nil)
+			g.Expect(err).ShouldNot(gomega.HaveOccurred())
+			g.Expect(result).Should(gomega.Equal("output: hello"))
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSandboxExecConcurrent(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	mock, cleanup := grpcmock.Install()
+	t.Cleanup(cleanup)
+
+	grpcmock.HandleUnary(
+		mock, "SandboxWait",
+		func(req *pb.SandboxWaitRequest) (*pb.SandboxWaitResponse, error) {
+			return &pb.SandboxWaitResponse{}, nil
+		},
+	)
+	// The task ID is fetched once and shared by all concurrent Exec calls.
+	grpcmock.HandleUnary(
+		mock, "SandboxGetTaskId",
+		func(req *pb.SandboxGetTaskIdRequest) (*pb.SandboxGetTaskIdResponse, error) {
+			g.Expect(req.GetSandboxId()).To(gomega.Equal("sb-123"))
+			return pb.SandboxGetTaskIdResponse_builder{TaskId: ptr("ta-123")}.Build(), nil
+		},
+	)
+	for range concurrentCalls {
+		grpcmock.HandleUnary(
+			mock, "ContainerExec",
+			func(req *pb.ContainerExecRequest) (*pb.ContainerExecResponse, error) {
+				g.Expect(req.GetTaskId()).To(gomega.Equal("ta-123"))
+				return pb.ContainerExecResponse_builder{ExecId: "ce-123"}.Build(), nil
+			},
+		)
+	}
+
+	mc, err := modal.NewClient(modal.ClientOptions{TokenId: "ak-123", TokenSecret: "as-123"})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	sb, err := mc.SandboxFromId(context.Background(), "sb-123")
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+	var wg sync.WaitGroup
+	for range concurrentCalls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := sb.Exec([]string{"echo", "hello"}, modal.ExecOptions{Stdout: modal.Ignore, Stderr: modal.Ignore})
+			g.Expect(err).ShouldNot(gomega.HaveOccurred())
+		}()
+	}
+	wg.Wait()
+}
+
+func TestInitializeClientConcurrent(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	mock, cleanup := grpcmock.Install()
+	t.Cleanup(cleanup)
+
+	for range concurrentCalls {
+		grpcmock.HandleUnary(
+			mock, "SecretGetOrCreate",
+			func(req *pb.SecretGetOrCreateRequest) (*pb.SecretGetOrCreateResponse, error) {
+				return pb.SecretGetOrCreateResponse_builder{SecretId: "st-123"}.Build(), nil
+			},
+		)
+	}
+
+	err := modal.InitializeClient(modal.ClientOptions{TokenId: "ak-123", TokenSecret: "as-123"})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+	var wg sync.WaitGroup
+	for range concurrentCalls {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := modal.SecretFromName(context.Background(), "my-secret", nil)
+			g.Expect(err).ShouldNot(gomega.HaveOccurred())
+		}()
+		go func() {
+			defer wg.Done()
+			err := modal.InitializeClient(modal.ClientOptions{TokenId: "ak-123", TokenSecret: "as-123"})
+			g.Expect(err).ShouldNot(gomega.HaveOccurred())
+		}()
+	}
+	wg.Wait()
+}
+
+func ptr[T any](v T) *T { return &v }
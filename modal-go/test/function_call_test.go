@@ -1,13 +1,17 @@
 package test
 
 import (
+	"bytes"
 	"context"
 	"testing"
 	"time"
 
 	pickle "github.com/kisielk/og-rek"
 	"github.com/modal-labs/libmodal/modal-go"
+	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+	"github.com/modal-labs/libmodal/modal-go/testsupport/grpcmock"
 	"github.com/onsi/gomega"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestFunctionSpawn(t *testing.T) {
@@ -94,3 +98,73 @@ func TestFunctionCallGet0(t *testing.T) {
 	g.Expect(err).ShouldNot(gomega.HaveOccurred())
 	g.Expect(result).Should(gomega.Equal(pickle.None{}))
 }
+
+func TestFunctionSpawnInputPlaneMock(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	mock, cleanup := grpcmock.Install()
+	t.Cleanup(cleanup)
+
+	grpcmock.HandleUnary(
+		mock, "FunctionGet",
+		func(req *pb.FunctionGetRequest) (*pb.FunctionGetResponse, error) {
+			return pb.FunctionGetResponse_builder{
+				FunctionId:     "fu-ip",
+				HandleMetadata: pb.FunctionHandleMetadata_builder{InputPlaneUrl: proto.String("https://ip.modal.test:443")}.Build(),
+			}.Build(), nil
+		},
+	)
+	grpcmock.HandleUnary(
+		mock, "AttemptStart",
+		func(req *pb.AttemptStartRequest) (*pb.AttemptStartResponse, error) {
+			g.Expect(req.GetFunctionId()).To(gomega.Equal("fu-ip"))
+			return pb.AttemptStartResponse_builder{AttemptToken: "attempt-123"}.Build(), nil
+		},
+	)
+	var buf bytes.Buffer
+	g.Expect(pickle.NewEncoder(&buf).Encode("output: hello")).To(gomega.Succeed())
+	for range 2 {
+		grpcmock.HandleUnary(
+			mock, "AttemptAwait",
+			func(req *pb.AttemptAwaitRequest) (*pb.AttemptAwaitResponse, error) {
+				g.Expect(req.GetAttemptToken()).To(gomega.Equal("attempt-123"))
+				return pb.AttemptAwaitResponse_builder{
+					Output: pb.FunctionGetOutputsItem_builder{
+						Result:     pb.GenericResult_builder{Status: pb.GenericResult_GENERIC_STATUS_SUCCESS, Data: buf.Bytes()}.Build(),
+						DataFormat: pb.DataFormat_DATA_FORMAT_PICKLE,
+					}.Build(),
+				}.Build(), nil
+			},
+		)
+	}
+
+	ctx := context.Background()
+	function, err := modal.FunctionLookup(ctx, "libmodal-test-support", "input_plane", nil)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	functionCall, err := function.Spawn([]any{"hello"}, nil)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(functionCall.FunctionCallId).To(gomega.HavePrefix("ip-"))
+
+	result, err := functionCall.Get(nil)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(result).Should(gomega.Equal("output: hello"))
+
+	// The call is found again from its ID.
+	functionCall, err = modal.FunctionCallFromId(ctx, functionCall.FunctionCallId)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	result, err = functionCall.Get(nil)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(result).Should(gomega.Equal("output: hello"))
+
+	// The input plane has no cancellation RPC, so cancelling fails without
+	// making any call.
+	err = functionCall.Cancel(nil)
+	g.Expect(err).Should(gomega.MatchError(modal.ErrInvalid))
+	g.Expect(err).Should(gomega.MatchError(gomega.ContainSubstring("doesn't support cancellation")))
+	err = functionCall.CancelContext(ctx, &modal.FunctionCallCancelOptions{TerminateContainers: true})
+	g.Expect(err).Should(gomega.MatchError(modal.ErrInvalid))
+	g.Expect(mock.AssertExhausted()).To(gomega.Succeed())
+
+	_, err = modal.FunctionCallFromId(ctx, "ip-not-a-call")
+	g.Expect(err).Should(gomega.MatchError(modal.ErrInvalid))
+}
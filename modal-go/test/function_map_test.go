@@ -0,0 +1,443 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pickle "github.com/kisielk/og-rek"
+	"github.com/modal-labs/libmodal/modal-go"
+	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+	"github.com/modal-labs/libmodal/modal-go/testsupport/grpcmock"
+	"github.com/onsi/gomega"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestFunctionMap(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	function, err := modal.FunctionLookup(
+		context.Background(),
+		"libmodal-test-support", "echo_string", nil,
+	)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+	inputs := func(yield func([]any) bool) {
+		for i := range 20 {
+			if !yield([]any{fmt.Sprint(i)}) {
+				return
+			}
+		}
+	}
+	var outputs []any
+	for output, err := range function.Map(context.Background(), inputs, modal.MapOptions{BatchSize: 8}) {
+		g.Expect(err).ShouldNot(gomega.HaveOccurred())
+		outputs = append(outputs, output)
+	}
+	g.Expect(outputs).To(gomega.HaveLen(20))
+	g.Expect(outputs[0]).To(gomega.Equal("output: 0"))
+	g.Expect(outputs[19]).To(gomega.Equal("output: 19"))
+}
+
+func mapOutput(g *gomega.WithT, idx int32, retryCount uint32, status pb.GenericResult_GenericStatus, v any) *pb.FunctionGetOutputsItem {
+	var buf bytes.Buffer
+	g.Expect(pickle.NewEncoder(&buf).Encode(v)).To(gomega.Succeed())
+	result := pb.GenericResult_builder{Status: status}
+	if status == pb.GenericResult_GENERIC_STATUS_SUCCESS {
+		result.Data = buf.Bytes()
+	} else {
+		result.Exception = fmt.Sprint(v)
+	}
+	return pb.FunctionGetOutputsItem_builder{
+		Idx:        idx,
+		RetryCount: retryCount,
+		Result:     result.Build(),
+		DataFormat: pb.DataFormat_DATA_FORMAT_PICKLE,
+	}.Build()
+}
+
+func argsSeq(n int) func(yield func([]any) bool) {
+	return func(yield func([]any) bool) {
+		for i := range n {
+			if !yield([]any{i}) {
+				return
+			}
+		}
+	}
+}
+
+// handleMapStart registers the FunctionMap call of a map, and FunctionPutInputs
+// calls with the given batch sizes. The returned channel is closed once all
+// inputs were put.
+func handleMapStart(g *gomega.WithT, mock *grpcmock.Mock, batchSizes ...int) <-chan struct{} {
+	grpcmock.HandleUnary(
+		mock, "FunctionMap",
+		func(req *pb.FunctionMapRequest) (*pb.FunctionMapResponse, error) {
+			g.Expect(req.GetFunctionCallType()).To(gomega.Equal(pb.FunctionCallType_FUNCTION_CALL_TYPE_MAP))
+			g.Expect(req.GetPipelinedInputs()).To(gomega.BeEmpty())
+			return pb.FunctionMapResponse_builder{FunctionCallId: "fc-map", FunctionCallJwt: "fc-jwt"}.Build(), nil
+		},
+	)
+	putDone := make(chan struct{})
+	for i, size := range batchSizes {
+		grpcmock.HandleUnary(
+			mock, "FunctionPutInputs",
+			func(req *pb.FunctionPutInputsRequest) (*pb.FunctionPutInputsResponse, error) {
+				g.Expect(req.GetFunctionCallId()).To(gomega.Equal("fc-map"))
+				g.Expect(req.GetInputs()).To(gomega.HaveLen(size))
+				var items []*pb.FunctionPutInputsResponseItem
+				for _, in := range req.GetInputs() {
+					items = append(items, pb.FunctionPutInputsResponseItem_builder{
+						Idx:      in.GetIdx(),
+						InputJwt: fmt.Sprintf("jwt-%d", in.GetIdx()),
+					}.Build())
+				}
+				if i == len(batchSizes)-1 {
+					close(putDone)
+				}
+				return pb.FunctionPutInputsResponse_builder{Inputs: items}.Build(), nil
+			},
+		)
+	}
+	return putDone
+}
+
+func waitFor(g *gomega.WithT, ch <-chan struct{}) {
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		g.Expect(fmt.Errorf("timed out waiting for inputs")).ShouldNot(gomega.HaveOccurred())
+	}
+}
+
+func TestFunctionMapMockOrderedWithRetry(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	mock, cleanup := grpcmock.Install()
+	t.Cleanup(cleanup)
+
+	putDone := handleMapStart(g, mock, 2, 1)
+	grpcmock.HandleUnary(
+		mock, "FunctionGetOutputs",
+		func(req *pb.FunctionGetOutputsRequest) (*pb.FunctionGetOutputsResponse, error) {
+			waitFor(g, putDone)
+			return pb.FunctionGetOutputsResponse_builder{
+				Outputs: []*pb.FunctionGetOutputsItem{
+					mapOutput(g, 2, 0, pb.GenericResult_GENERIC_STATUS_SUCCESS, int64(20)),
+					mapOutput(g, 1, 0, pb.GenericResult_GENERIC_STATUS_INTERNAL_FAILURE, "worker lost"),
+					mapOutput(g, 0, 0, pb.GenericResult_GENERIC_STATUS_SUCCESS, int64(0)),
+				},
+				LastEntryId: "1-3",
+			}.Build(), nil
+		},
+	)
+	grpcmock.HandleUnary(
+		mock, "FunctionRetryInputs",
+		func(req *pb.FunctionRetryInputsRequest) (*pb.FunctionRetryInputsResponse, error) {
+			g.Expect(req.GetFunctionCallJwt()).To(gomega.Equal("fc-jwt"))
+			g.Expect(req.GetInputs()).To(gomega.HaveLen(1))
+			g.Expect(req.GetInputs()[0].GetInputJwt()).To(gomega.Equal("jwt-1"))
+			g.Expect(req.GetInputs()[0].GetRetryCount()).To(gomega.Equal(uint32(1)))
+			return pb.FunctionRetryInputsResponse_builder{InputJwts: []string{"jwt-1-retry"}}.Build(), nil
+		},
+	)
+	grpcmock.HandleUnary(
+		mock, "FunctionGetOutputs",
+		func(req *pb.FunctionGetOutputsRequest) (*pb.FunctionGetOutputsResponse, error) {
+			g.Expect(req.GetLastEntryId()).To(gomega.Equal("1-3"))
+			return pb.FunctionGetOutputsResponse_builder{
+				Outputs: []*pb.FunctionGetOutputsItem{
+					mapOutput(g, 1, 1, pb.GenericResult_GENERIC_STATUS_SUCCESS, int64(10)),
+				},
+				LastEntryId: "1-4",
+			}.Build(), nil
+		},
+	)
+
+	f := &modal.Function{FunctionId: "fid-map"}
+	var outputs []any
+	for output, err := range f.Map(context.Background(), argsSeq(3), modal.MapOptions{BatchSize: 2}) {
+		g.Expect(err).ShouldNot(gomega.HaveOccurred())
+		outputs = append(outputs, output)
+	}
+	g.Expect(outputs).To(gomega.Equal([]any{int64(0), int64(10), int64(20)}))
+}
+
+func TestFunctionMapMockUnorderedReturnExceptions(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	mock, cleanup := grpcmock.Install()
+	t.Cleanup(cleanup)
+
+	putDone := handleMapStart(g, mock, 3)
+	grpcmock.HandleUnary(
+		mock, "FunctionGetOutputs",
+		func(req *pb.FunctionGetOutputsRequest) (*pb.FunctionGetOutputsResponse, error) {
+			waitFor(g, putDone)
+			return pb.FunctionGetOutputsResponse_builder{
+				Outputs: []*pb.FunctionGetOutputsItem{
+					mapOutput(g, 2, 0, pb.GenericResult_GENERIC_STATUS_SUCCESS, int64(20)),
+					mapOutput(g, 0, 0, pb.GenericResult_GENERIC_STATUS_FAILURE, "ValueError('bad input')"),
+					mapOutput(g, 1, 0, pb.GenericResult_GENERIC_STATUS_SUCCESS, int64(10)),
+				},
+			}.Build(), nil
+		},
+	)
+
+	f := &modal.Function{FunctionId: "fid-map"}
+	var outputs []any
+	var errs []error
+	for output, err := range f.Map(context.Background(), argsSeq(3), modal.MapOptions{Unordered: true, ReturnExceptions: true}) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		outputs = append(outputs, output)
+	}
+	g.Expect(outputs).To(gomega.Equal([]any{int64(20), int64(10)}))
+	g.Expect(errs).To(gomega.HaveLen(1))
+	g.Expect(errs[0]).To(gomega.MatchError(modal.RemoteException("ValueError")))
+}
+
+func TestFunctionMapMockStopsAtError(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	mock, cleanup := grpcmock.Install()
+	t.Cleanup(cleanup)
+
+	putDone := handleMapStart(g, mock, 2)
+	grpcmock.HandleUnary(
+		mock, "FunctionGetOutputs",
+		func(req *pb.FunctionGetOutputsRequest) (*pb.FunctionGetOutputsResponse, error) {
+			waitFor(g, putDone)
+			return pb.FunctionGetOutputsResponse_builder{
+				Outputs: []*pb.FunctionGetOutputsItem{
+					mapOutput(g, 0, 0, pb.GenericResult_GENERIC_STATUS_FAILURE, "ValueError('bad input')"),
+				},
+			}.Build(), nil
+		},
+	)
+	// The remaining input is cancelled.
+	cancelled := make(chan struct{})
+	grpcmock.HandleUnary(
+		mock, "FunctionCallCancel",
+		func(req *pb.FunctionCallCancelRequest) (*emptypb.Empty, error) {
+			g.Expect(req.GetFunctionCallId()).To(gomega.Equal("fc-map"))
+			close(cancelled)
+			return &emptypb.Empty{}, nil
+		},
+	)
+
+	f := &modal.Function{FunctionId: "fid-map"}
+	var errs []error
+	for output, err := range f.Map(context.Background(), argsSeq(2), modal.MapOptions{}) {
+		g.Expect(output).To(gomega.BeNil())
+		errs = append(errs, err)
+	}
+	g.Expect(errs).To(gomega.HaveLen(1))
+	g.Expect(errs[0]).To(gomega.MatchError(modal.ErrRemote))
+	waitFor(g, cancelled)
+}
+
+func TestFunctionMapMockBackpressure(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	mock, cleanup := grpcmock.Install()
+	t.Cleanup(cleanup)
+
+	// With one input outstanding, each input is put once the previous one has an
+	// output.
+	handleMapStart(g, mock, 1)
+	for idx := range int32(3) {
+		if idx > 0 {
+			grpcmock.HandleUnary(
+				mock, "FunctionPutInputs",
+				func(req *pb.FunctionPutInputsRequest) (*pb.FunctionPutInputsResponse, error) {
+					g.Expect(req.GetInputs()).To(gomega.HaveLen(1))
+					g.Expect(req.GetInputs()[0].GetIdx()).To(gomega.Equal(idx))
+					return pb.FunctionPutInputsResponse_builder{}.Build(), nil
+				},
+			)
+		}
+		grpcmock.HandleUnary(
+			mock, "FunctionGetOutputs",
+			func(req *pb.FunctionGetOutputsRequest) (*pb.FunctionGetOutputsResponse, error) {
+				return pb.FunctionGetOutputsResponse_builder{
+					Outputs: []*pb.FunctionGetOutputsItem{
+						mapOutput(g, idx, 0, pb.GenericResult_GENERIC_STATUS_SUCCESS, int64(idx)),
+					},
+				}.Build(), nil
+			},
+		)
+	}
+
+	f := &modal.Function{FunctionId: "fid-map"}
+	var outputs []any
+	for output, err := range f.Map(context.Background(), argsSeq(3), modal.MapOptions{MaxInputsOutstanding: 1}) {
+		g.Expect(err).ShouldNot(gomega.HaveOccurred())
+		outputs = append(outputs, output)
+	}
+	g.Expect(outputs).To(gomega.Equal([]any{int64(0), int64(1), int64(2)}))
+}
+
+func TestFunctionMapMockRetryBeforeInputJwt(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	mock, cleanup := grpcmock.Install()
+	t.Cleanup(cleanup)
+
+	// The input fails before FunctionPutInputs returns its JWT, and is retried
+	// once the JWT is known.
+	grpcmock.HandleUnary(
+		mock, "FunctionMap",
+		func(req *pb.FunctionMapRequest) (*pb.FunctionMapResponse, error) {
+			return pb.FunctionMapResponse_builder{FunctionCallId: "fc-map", FunctionCallJwt: "fc-jwt"}.Build(), nil
+		},
+	)
+	failed := make(chan struct{})
+	grpcmock.HandleUnary(
+		mock, "FunctionPutInputs",
+		func(req *pb.FunctionPutInputsRequest) (*pb.FunctionPutInputsResponse, error) {
+			waitFor(g, failed)
+			time.Sleep(50 * time.Millisecond)
+			return pb.FunctionPutInputsResponse_builder{
+				Inputs: []*pb.FunctionPutInputsResponseItem{pb.FunctionPutInputsResponseItem_builder{Idx: 0, InputJwt: "jwt-0"}.Build()},
+			}.Build(), nil
+		},
+	)
+	grpcmock.HandleUnary(
+		mock, "FunctionGetOutputs",
+		func(req *pb.FunctionGetOutputsRequest) (*pb.FunctionGetOutputsResponse, error) {
+			defer close(failed)
+			return pb.FunctionGetOutputsResponse_builder{
+				Outputs: []*pb.FunctionGetOutputsItem{
+					mapOutput(g, 0, 0, pb.GenericResult_GENERIC_STATUS_INTERNAL_FAILURE, "worker lost"),
+				},
+				LastEntryId: "1-1",
+			}.Build(), nil
+		},
+	)
+	grpcmock.HandleUnary(
+		mock, "FunctionRetryInputs",
+		func(req *pb.FunctionRetryInputsRequest) (*pb.FunctionRetryInputsResponse, error) {
+			g.Expect(req.GetInputs()).To(gomega.HaveLen(1))
+			g.Expect(req.GetInputs()[0].GetInputJwt()).To(gomega.Equal("jwt-0"))
+			return pb.FunctionRetryInputsResponse_builder{InputJwts: []string{"jwt-0-retry"}}.Build(), nil
+		},
+	)
+	grpcmock.HandleUnary(
+		mock, "FunctionGetOutputs",
+		func(req *pb.FunctionGetOutputsRequest) (*pb.FunctionGetOutputsResponse, error) {
+			return pb.FunctionGetOutputsResponse_builder{
+				Outputs: []*pb.FunctionGetOutputsItem{
+					mapOutput(g, 0, 1, pb.GenericResult_GENERIC_STATUS_SUCCESS, int64(0)),
+				},
+				LastEntryId: "1-2",
+			}.Build(), nil
+		},
+	)
+
+	f := &modal.Function{FunctionId: "fid-map"}
+	var outputs []any
+	for output, err := range f.Map(context.Background(), argsSeq(1), modal.MapOptions{}) {
+		g.Expect(err).ShouldNot(gomega.HaveOccurred())
+		outputs = append(outputs, output)
+	}
+	g.Expect(outputs).To(gomega.Equal([]any{int64(0)}))
+}
+
+func TestFunctionMapMockClientClose(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	mock, cleanup := grpcmock.Install()
+	t.Cleanup(cleanup)
+
+	// Closing the client stops a running map, and waits for it.
+	grpcmock.HandleUnary(
+		mock, "FunctionGet",
+		func(req *pb.FunctionGetRequest) (*pb.FunctionGetResponse, error) {
+			return pb.FunctionGetResponse_builder{FunctionId: "fid-map"}.Build(), nil
+		},
+	)
+	handleMapStart(g, mock, 1)
+	polling, closing := make(chan struct{}), make(chan struct{})
+	grpcmock.HandleUnary(
+		mock, "FunctionGetOutputs",
+		func(req *pb.FunctionGetOutputsRequest) (*pb.FunctionGetOutputsResponse, error) {
+			close(polling)
+			waitFor(g, closing)
+			return pb.FunctionGetOutputsResponse_builder{}.Build(), nil
+		},
+	)
+
+	client, err := modal.NewClient(modal.ClientOptions{TokenId: "ak-123", TokenSecret: "as-123"})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	f, err := client.FunctionLookup(context.Background(), "libmodal-test-support", "echo_string", nil)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	errs := make(chan error, 1)
+	go func() {
+		for _, err := range f.Map(context.Background(), argsSeq(1), modal.MapOptions{}) {
+			errs <- err
+			return
+		}
+	}()
+	waitFor(g, polling)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(closing)
+	}()
+	g.Expect(client.Close(context.Background())).To(gomega.Succeed())
+	g.Expect(<-errs).To(gomega.MatchError(context.Canceled))
+}
+
+func TestFunctionMapMockStopsReadingInputs(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	mock, cleanup := grpcmock.Install()
+	t.Cleanup(cleanup)
+
+	putDone := handleMapStart(g, mock, 2)
+	grpcmock.HandleUnary(
+		mock, "FunctionGetOutputs",
+		func(req *pb.FunctionGetOutputsRequest) (*pb.FunctionGetOutputsResponse, error) {
+			waitFor(g, putDone)
+			return pb.FunctionGetOutputsResponse_builder{
+				Outputs: []*pb.FunctionGetOutputsItem{
+					mapOutput(g, 0, 0, pb.GenericResult_GENERIC_STATUS_SUCCESS, int64(0)),
+				},
+			}.Build(), nil
+		},
+	)
+
+	// The inputs aren't read once the map is cancelled, and not at all after Map
+	// returns.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var pulled atomic.Int32
+	inputsDone := make(chan struct{})
+	inputs := func(yield func([]any) bool) {
+		defer close(inputsDone)
+		for i := 0; ; i++ {
+			if i == 2 {
+				<-ctx.Done()
+			}
+			pulled.Add(1)
+			if !yield([]any{i}) {
+				return
+			}
+		}
+	}
+	f := &modal.Function{FunctionId: "fid-map"}
+	for _, err := range f.Map(ctx, inputs, modal.MapOptions{BatchSize: 2}) {
+		if err == nil {
+			cancel()
+		}
+	}
+	g.Expect(inputsDone).To(gomega.BeClosed())
+	g.Expect(pulled.Load()).To(gomega.Equal(int32(3)))
+}
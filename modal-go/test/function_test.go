@@ -8,6 +8,7 @@ import (
 	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
 	"github.com/modal-labs/libmodal/modal-go/testsupport/grpcmock"
 	"github.com/onsi/gomega"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestFunctionCall(t *testing.T) {
@@ -28,6 +29,22 @@ func TestFunctionCall(t *testing.T) {
 	g.Expect(result).Should(gomega.Equal("output: hello"))
 }
 
+func TestFunctionCallTyped(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	function, err := modal.FunctionLookup(context.Background(), "libmodal-test-support", "echo_string", nil)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+	result, err := modal.Call[string](context.Background(), function, "hello")
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(result).Should(gomega.Equal("output: hello"))
+
+	var n int
+	err = function.RemoteInto(context.Background(), &n, []any{"hello"}, nil)
+	g.Expect(err).Should(gomega.MatchError(modal.ErrDecode))
+}
+
 func TestFunctionCallLargeInput(t *testing.T) {
 	t.Parallel()
 	g := gomega.NewWithT(t)
@@ -129,6 +146,35 @@ func TestFunctionUpdateAutoscaler(t *testing.T) {
 
 func ptrU32(v uint32) *uint32 { return &v }
 
+func TestFunctionWithCodecMock(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	mock, cleanup := grpcmock.Install()
+	t.Cleanup(cleanup)
+
+	grpcmock.HandleUnary(
+		mock, "FunctionGet",
+		func(req *pb.FunctionGetRequest) (*pb.FunctionGetResponse, error) {
+			return pb.FunctionGetResponse_builder{
+				FunctionId:     "fu-codec",
+				HandleMetadata: pb.FunctionHandleMetadata_builder{InputPlaneUrl: proto.String("https://ip.modal.test:443")}.Build(),
+			}.Build(), nil
+		},
+	)
+
+	function, err := modal.FunctionLookup(context.Background(), "libmodal-test-support", "json", nil)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+	// Inputs in other formats than pickle would be unpickled, so they aren't
+	// sent at all.
+	for _, codec := range []modal.Codec{modal.JSONCodec{}, modal.RawCodec{}} {
+		_, err = function.WithCodec(codec).Remote([]any{"hello"}, nil)
+		g.Expect(err).To(gomega.MatchError(modal.ErrInvalid))
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("only supports as pickles")))
+	}
+	g.Expect(mock.AssertExhausted()).To(gomega.Succeed())
+}
+
 func TestFunctionGetWebURL(t *testing.T) {
 	g := gomega.NewWithT(t)
 
@@ -155,3 +201,21 @@ func TestFunctionGetWebURL(t *testing.T) {
 	g.Expect(err).ShouldNot(gomega.HaveOccurred())
 	g.Expect(wef.GetWebURL()).To(gomega.Equal("https://endpoint.internal"))
 }
+
+func TestFunctionRemoteGen(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	function, err := modal.FunctionLookup(
+		context.Background(),
+		"libmodal-test-support", "count_to", nil,
+	)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+	var values []any
+	for v, err := range function.RemoteGen(context.Background(), []any{5}, nil) {
+		g.Expect(err).ShouldNot(gomega.HaveOccurred())
+		values = append(values, v)
+	}
+	g.Expect(values).Should(gomega.Equal([]any{int64(0), int64(1), int64(2), int64(3), int64(4)}))
+}
@@ -0,0 +1,102 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	modal "github.com/modal-labs/libmodal/modal-go"
+	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+	"github.com/modal-labs/libmodal/modal-go/testsupport/grpcmock"
+	"github.com/onsi/gomega"
+	"github.com/pelletier/go-toml/v2"
+)
+
+func TestLogin(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("MODAL_PROFILE", "")
+	configPath := filepath.Join(home, ".modal.toml")
+	err := os.WriteFile(configPath, []byte(`
+[old]
+token_id = "ak-old"
+token_secret = "as-old"
+active = true
+custom_setting = "keep me"
+`), 0o600)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+	mock, cleanup := grpcmock.Install()
+	t.Cleanup(cleanup)
+
+	grpcmock.HandleUnary(
+		mock, "TokenFlowCreate",
+		func(req *pb.TokenFlowCreateRequest) (*pb.TokenFlowCreateResponse, error) {
+			return pb.TokenFlowCreateResponse_builder{
+				TokenFlowId: "tf-123",
+				WebUrl:      "https://modal.com/token-flow/tf-123",
+				Code:        "gentle-otter",
+				WaitSecret:  "wait-secret",
+			}.Build(), nil
+		},
+	)
+	// The first wait times out before the user authorizes the token.
+	grpcmock.HandleUnary(
+		mock, "TokenFlowWait",
+		func(req *pb.TokenFlowWaitRequest) (*pb.TokenFlowWaitResponse, error) {
+			g.Expect(req.GetTokenFlowId()).To(gomega.Equal("tf-123"))
+			g.Expect(req.GetWaitSecret()).To(gomega.Equal("wait-secret"))
+			return pb.TokenFlowWaitResponse_builder{Timeout: true}.Build(), nil
+		},
+	)
+	grpcmock.HandleUnary(
+		mock, "TokenFlowWait",
+		func(req *pb.TokenFlowWaitRequest) (*pb.TokenFlowWaitResponse, error) {
+			return pb.TokenFlowWaitResponse_builder{
+				TokenId:           "ak-new",
+				TokenSecret:       "as-new",
+				WorkspaceUsername: "my-workspace",
+			}.Build(), nil
+		},
+	)
+
+	var url, code string
+	result, err := modal.Login(context.Background(), modal.LoginOptions{
+		OnURL:    func(u, c string) { url, code = u, c },
+		Profile:  "my-workspace",
+		Activate: true,
+	})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(url).To(gomega.Equal("https://modal.com/token-flow/tf-123"))
+	g.Expect(code).To(gomega.Equal("gentle-otter"))
+	g.Expect(*result).To(gomega.Equal(modal.LoginResult{
+		TokenId:           "ak-new",
+		TokenSecret:       "as-new",
+		WorkspaceUsername: "my-workspace",
+		ConfigPath:        configPath,
+	}))
+
+	content, err := os.ReadFile(configPath)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	var cfg map[string]map[string]any
+	g.Expect(toml.Unmarshal(content, &cfg)).To(gomega.Succeed())
+	g.Expect(cfg).To(gomega.Equal(map[string]map[string]any{
+		"old":          {"token_id": "ak-old", "token_secret": "as-old", "custom_setting": "keep me"},
+		"my-workspace": {"token_id": "ak-new", "token_secret": "as-new", "active": true},
+	}))
+
+	profile, err := modal.ResolveProfile(modal.ClientOptions{})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(profile.Name).To(gomega.Equal("my-workspace"))
+	g.Expect(profile.TokenId).To(gomega.Equal("ak-new"))
+}
+
+func TestLoginRequiresOnURL(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	_, err := modal.Login(context.Background(), modal.LoginOptions{})
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("OnURL must be set")))
+}
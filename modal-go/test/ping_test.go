@@ -0,0 +1,94 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	modal "github.com/modal-labs/libmodal/modal-go"
+	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+	"github.com/modal-labs/libmodal/modal-go/testsupport/grpcmock"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestPing(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	mock, cleanup := grpcmock.Install()
+	t.Cleanup(cleanup)
+
+	mc, err := modal.NewClient(modal.ClientOptions{TokenId: "ak-123", TokenSecret: "as-123", Environment: "staging"})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+	grpcmock.HandleUnary(
+		mock, "ClientHello",
+		func(req *emptypb.Empty) (*pb.ClientHelloResponse, error) {
+			return pb.ClientHelloResponse_builder{
+				Warning:             "Please upgrade your client",
+				ImageBuilderVersion: "2025.06",
+				ServerWarnings: []*pb.Warning{
+					pb.Warning_builder{Type: pb.Warning_WARNING_TYPE_RESOURCE_LIMIT, Message: "Close to the container limit"}.Build(),
+				},
+			}.Build(), nil
+		},
+	)
+	grpcmock.HandleUnary(
+		mock, "WorkspaceNameLookup",
+		func(req *emptypb.Empty) (*pb.WorkspaceNameLookupResponse, error) {
+			return pb.WorkspaceNameLookupResponse_builder{Username: "my-workspace"}.Build(), nil
+		},
+	)
+
+	result, err := mc.Ping(context.Background())
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(result.Workspace).To(gomega.Equal(modal.Workspace{Name: "my-workspace"}))
+	g.Expect(result.Environment).To(gomega.Equal("staging"))
+	g.Expect(result.ImageBuilderVersion).To(gomega.Equal("2025.06"))
+	g.Expect(result.Warnings).To(gomega.Equal([]modal.ServerWarning{
+		{Type: modal.WarningTypeClientDeprecation, Message: "Please upgrade your client"},
+		{Type: modal.WarningTypeResourceLimit, Message: "Close to the container limit"},
+	}))
+}
+
+func TestPingUnauthenticated(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	mock, cleanup := grpcmock.Install()
+	t.Cleanup(cleanup)
+
+	mc, err := modal.NewClient(modal.ClientOptions{TokenId: "ak-123", TokenSecret: "as-invalid"})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+	grpcmock.HandleUnary(
+		mock, "ClientHello",
+		func(req *emptypb.Empty) (*pb.ClientHelloResponse, error) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		},
+	)
+
+	_, err = mc.Ping(context.Background())
+	g.Expect(status.Code(err)).To(gomega.Equal(codes.Unauthenticated))
+}
+
+func TestLookupWorkspace(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	mock, cleanup := grpcmock.Install()
+	t.Cleanup(cleanup)
+
+	mc, err := modal.NewClient(modal.ClientOptions{TokenId: "ak-123", TokenSecret: "as-123"})
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+	grpcmock.HandleUnary(
+		mock, "WorkspaceNameLookup",
+		func(req *emptypb.Empty) (*pb.WorkspaceNameLookupResponse, error) {
+			return pb.WorkspaceNameLookupResponse_builder{Username: "my-workspace"}.Build(), nil
+		},
+	)
+
+	workspace, err := mc.LookupWorkspace(context.Background())
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(workspace.Name).To(gomega.Equal("my-workspace"))
+}
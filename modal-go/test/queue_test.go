@@ -9,7 +9,10 @@ import (
 	"time"
 
 	"github.com/modal-labs/libmodal/modal-go"
+	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+	"github.com/modal-labs/libmodal/modal-go/testsupport/grpcmock"
 	"github.com/onsi/gomega"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 func TestQueueInvalidName(t *testing.T) {
@@ -200,3 +203,40 @@ func TestQueueNonEphemeral(t *testing.T) {
 	g.Expect(err).ShouldNot(gomega.HaveOccurred())
 	g.Expect(value).To(gomega.Equal("data"))
 }
+
+func TestQueueWithCodecMock(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	mock, cleanup := grpcmock.Install()
+	t.Cleanup(cleanup)
+
+	grpcmock.HandleUnary(
+		mock, "QueueGetOrCreate",
+		func(req *pb.QueueGetOrCreateRequest) (*pb.QueueGetOrCreateResponse, error) {
+			return pb.QueueGetOrCreateResponse_builder{QueueId: "qu-codec"}.Build(), nil
+		},
+	)
+	grpcmock.HandleUnary(
+		mock, "QueuePut",
+		func(req *pb.QueuePutRequest) (*emptypb.Empty, error) {
+			g.Expect(req.GetValues()).To(gomega.Equal([][]byte{[]byte(`{"a":[1,2.5]}`)}))
+			return &emptypb.Empty{}, nil
+		},
+	)
+	grpcmock.HandleUnary(
+		mock, "QueueGet",
+		func(req *pb.QueueGetRequest) (*pb.QueueGetResponse, error) {
+			return pb.QueueGetResponse_builder{Values: [][]byte{[]byte(`{"a":[1,2.5]}`)}}.Build(), nil
+		},
+	)
+
+	queue, err := modal.QueueLookup(context.Background(), "codec-queue", nil)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	queue = queue.WithCodec(modal.JSONCodec{})
+
+	err = queue.Put(map[string]any{"a": []any{1, 2.5}}, nil)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	v, err := queue.Get(nil)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(v).To(gomega.Equal(map[any]any{"a": []any{int64(1), 2.5}}))
+}
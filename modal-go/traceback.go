@@ -0,0 +1,170 @@
+package modal
+
+// Decoding of Python exceptions and tracebacks returned by failed Function calls.
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	pickle "github.com/kisielk/og-rek"
+	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+)
+
+// TracebackFrame is a frame of a Python traceback.
+type TracebackFrame struct {
+	File     string // e.g. "/root/app.py"
+	Line     int
+	Function string // e.g. "my_function", or "<module>"
+	Code     string // source line of the frame, if available
+}
+
+func (f TracebackFrame) String() string {
+	return fmt.Sprintf("%s:%d in %s", f.File, f.Line, f.Function)
+}
+
+// newRemoteError returns the RemoteError for a failed result, whose data holds
+// the pickled exception.
+func newRemoteError(result *pb.GenericResult, data []byte) RemoteError {
+	e := RemoteError{
+		Exception: result.GetException(),
+		Traceback: result.GetTraceback(),
+	}
+	e.Frames = parseTraceback(e.Traceback)
+
+	// Exceptions are pickled as a call of their class, with their __dict__ as
+	// state if they have attributes.
+	if len(data) > 0 {
+		if v, err := pickleDeserialize(data); err == nil {
+			var call pickle.Call
+			switch v := v.(type) {
+			case pickle.Call:
+				call = v
+			case PythonObject:
+				call = pickle.Call{Callable: v.Class, Args: v.Args}
+			}
+			if call.Callable.Name != "" {
+				e.ExceptionType = pythonClassName(call.Callable)
+				e.Args = []any(call.Args)
+				e.Message = exceptionMessage(e.ExceptionType, e.Args)
+				return e
+			}
+		}
+	}
+
+	// Fall back to the last line of the traceback, e.g. "ValueError: bad input",
+	// or the repr of the exception, e.g. "ValueError('bad input')".
+	if name, msg, ok := parseExceptionLine(lastLine(e.Traceback)); ok {
+		e.ExceptionType, e.Message = name, msg
+	} else if m := exceptionReprRe.FindStringSubmatch(e.Exception); m != nil {
+		e.ExceptionType = m[1]
+		if s, err := strconv.Unquote(pythonToGoQuote(m[2])); err == nil {
+			e.Message = s
+		}
+	}
+	return e
+}
+
+// pythonClassName returns the name of a class as shown in Python tracebacks.
+func pythonClassName(c pickle.Class) string {
+	if c.Module == "builtins" || c.Module == "__main__" || c.Module == "" {
+		return c.Name
+	}
+	return c.Module + "." + c.Name
+}
+
+// exceptionMessage returns str() of an exception with the given args.
+func exceptionMessage(exceptionType string, args []any) string {
+	switch len(args) {
+	case 0:
+		return ""
+	case 1:
+		if exceptionType == "KeyError" {
+			return pythonRepr(args[0])
+		}
+		return fmt.Sprint(args[0])
+	default:
+		reprs := make([]string, len(args))
+		for i, arg := range args {
+			reprs[i] = pythonRepr(arg)
+		}
+		return "(" + strings.Join(reprs, ", ") + ")"
+	}
+}
+
+// pythonRepr approximates repr() of a decoded Python value.
+func pythonRepr(v any) string {
+	switch v := v.(type) {
+	case string:
+		if strings.Contains(v, "'") && !strings.Contains(v, `"`) {
+			return `"` + v + `"`
+		}
+		return "'" + strings.ReplaceAll(v, "'", `\'`) + "'"
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case nil, pickle.None:
+		return "None"
+	case []byte:
+		return "b" + pythonRepr(string(v))
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+var (
+	tracebackFrameRe = regexp.MustCompile(`^\s*File "(.*)", line (\d+), in (.*)$`)
+	exceptionLineRe  = regexp.MustCompile(`^([A-Za-z_][\w.]*)(?:: (.*))?$`)
+	exceptionReprRe  = regexp.MustCompile(`^([A-Za-z_][\w.]*)\((?:('.*'|".*"),?)?.*\)$`)
+)
+
+// parseTraceback returns the frames of a formatted Python traceback. If exceptions
+// are chained, the frames of the last one are returned.
+func parseTraceback(tb string) []TracebackFrame {
+	var frames []TracebackFrame
+	for _, line := range strings.Split(tb, "\n") {
+		if strings.HasPrefix(line, "Traceback (most recent call last):") {
+			frames = nil
+			continue
+		}
+		if m := tracebackFrameRe.FindStringSubmatch(line); m != nil {
+			lineno, _ := strconv.Atoi(m[2])
+			frames = append(frames, TracebackFrame{File: m[1], Line: lineno, Function: m[3]})
+			continue
+		}
+		// The source line follows its frame, and may be followed by a line of
+		// carets that marks the failing expression.
+		code := strings.TrimSpace(line)
+		if len(frames) > 0 && strings.HasPrefix(line, "    ") && frames[len(frames)-1].Code == "" && strings.Trim(code, "^~ ") != "" {
+			frames[len(frames)-1].Code = code
+		}
+	}
+	return frames
+}
+
+// parseExceptionLine parses the last line of a traceback, e.g. "KeyError: 'k'".
+func parseExceptionLine(line string) (name, msg string, ok bool) {
+	m := exceptionLineRe.FindStringSubmatch(line)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+func lastLine(s string) string {
+	s = strings.TrimRight(s, "\n")
+	return s[strings.LastIndex(s, "\n")+1:]
+}
+
+// pythonToGoQuote converts a Python string literal in single quotes to one that
+// strconv.Unquote accepts.
+func pythonToGoQuote(s string) string {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		inner := strings.ReplaceAll(s[1:len(s)-1], `\'`, `'`)
+		return `"` + strings.ReplaceAll(inner, `"`, `\"`) + `"`
+	}
+	return s
+}
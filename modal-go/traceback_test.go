@@ -0,0 +1,135 @@
+package modal
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	pb "github.com/modal-labs/libmodal/modal-go/proto/modal_proto"
+	"github.com/onsi/gomega"
+)
+
+// Pickles of exceptions, from CPython with pickle.dumps(exc, protocol=4).
+var (
+	pickledValueError        = []byte("\x80\x04\x95-\x00\x00\x00\x00\x00\x00\x00\x8c\x08builtins\x94\x8c\nValueError\x94\x93\x94\x8c\tbad input\x94K\x03\x86\x94R\x94.")
+	pickledKeyError          = []byte("\x80\x04\x95!\x00\x00\x00\x00\x00\x00\x00\x8c\x08builtins\x94\x8c\x08KeyError\x94\x93\x94\x8c\x01k\x94\x85\x94R\x94.")
+	pickledFileNotFoundError = []byte("\x80\x04\x957\x00\x00\x00\x00\x00\x00\x00\x8c\x08builtins\x94\x8c\x11FileNotFoundError\x94\x93\x94K\x02\x8c\x0cNo such file\x94\x86\x94R\x94.")
+	pickledValueErrorState   = []byte("\x80\x04\x952\x00\x00\x00\x00\x00\x00\x00\x8c\x08builtins\x94\x8c\nValueError\x94\x93\x94\x8c\x03bad\x94\x85\x94R\x94}\x94\x8c\x04code\x94K\x01sb.") // with e.code = 1
+)
+
+const testTraceback = `Traceback (most recent call last):
+  File "/pkg/modal/_runtime/container_io_manager.py", line 778, in handle_input_exception
+    yield
+  File "/root/app.py", line 12, in parse
+    return int(value)
+           ^^^^^^^^^^
+ValueError: invalid literal for int() with base 10: 'x'
+
+During handling of the above exception, another exception occurred:
+
+Traceback (most recent call last):
+  File "/root/app.py", line 20, in handler
+    parse(value)
+  File "/root/app.py", line 14, in parse
+    raise KeyError(value)
+KeyError: 'k'
+`
+
+func TestNewRemoteErrorFromPickle(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		data    []byte
+		typ     string
+		message string
+		args    []any
+	}{
+		{pickledValueError, "ValueError", "('bad input', 3)", []any{"bad input", int64(3)}},
+		{pickledKeyError, "KeyError", "'k'", []any{"k"}},
+		{pickledFileNotFoundError, "FileNotFoundError", "(2, 'No such file')", []any{int64(2), "No such file"}},
+		{pickledValueErrorState, "ValueError", "bad", []any{"bad"}},
+	} {
+		t.Run(tc.typ, func(t *testing.T) {
+			t.Parallel()
+			g := gomega.NewWithT(t)
+
+			result := pb.GenericResult_builder{
+				Status:    pb.GenericResult_GENERIC_STATUS_FAILURE,
+				Exception: tc.typ + "(...)",
+			}.Build()
+			e := newRemoteError(result, tc.data)
+			g.Expect(e.ExceptionType).To(gomega.Equal(tc.typ))
+			g.Expect(e.Message).To(gomega.Equal(tc.message))
+			g.Expect(e.Args).To(gomega.Equal(tc.args))
+			g.Expect(e.Exception).To(gomega.Equal(tc.typ + "(...)"))
+		})
+	}
+}
+
+func TestNewRemoteErrorFallback(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	// Data that can't be unpickled falls back to the traceback.
+	result := pb.GenericResult_builder{
+		Status:    pb.GenericResult_GENERIC_STATUS_FAILURE,
+		Exception: "KeyError('k')",
+		Traceback: testTraceback,
+	}.Build()
+	e := newRemoteError(result, []byte("\x80\x04not a pickle"))
+	g.Expect(e.ExceptionType).To(gomega.Equal("KeyError"))
+	g.Expect(e.Message).To(gomega.Equal("'k'"))
+	g.Expect(e.Args).To(gomega.BeNil())
+
+	// Without a traceback, the repr is used.
+	result = pb.GenericResult_builder{
+		Status:    pb.GenericResult_GENERIC_STATUS_FAILURE,
+		Exception: `mymodule.MyError("it's broken")`,
+	}.Build()
+	e = newRemoteError(result, nil)
+	g.Expect(e.ExceptionType).To(gomega.Equal("mymodule.MyError"))
+	g.Expect(e.Message).To(gomega.Equal("it's broken"))
+
+	result = pb.GenericResult_builder{
+		Status:    pb.GenericResult_GENERIC_STATUS_FAILURE,
+		Exception: `ValueError('say "hi"')`,
+	}.Build()
+	e = newRemoteError(result, nil)
+	g.Expect(e.ExceptionType).To(gomega.Equal("ValueError"))
+	g.Expect(e.Message).To(gomega.Equal(`say "hi"`))
+}
+
+func TestParseTraceback(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	// Only the frames of the last chained exception are returned.
+	g.Expect(parseTraceback(testTraceback)).To(gomega.Equal([]TracebackFrame{
+		{File: "/root/app.py", Line: 20, Function: "handler", Code: "parse(value)"},
+		{File: "/root/app.py", Line: 14, Function: "parse", Code: "raise KeyError(value)"},
+	}))
+	g.Expect(parseTraceback("")).To(gomega.BeEmpty())
+}
+
+func TestRemoteErrorMatching(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	result := pb.GenericResult_builder{
+		Status:    pb.GenericResult_GENERIC_STATUS_FAILURE,
+		Exception: "ValueError('bad input', 3)",
+		Traceback: "Traceback (most recent call last):\n  File \"/root/app.py\", line 3, in f\n    raise ValueError('bad input', 3)\nValueError: ('bad input', 3)\n",
+	}.Build()
+	err := fmt.Errorf("calling f: %w", newRemoteError(result, pickledValueError))
+
+	g.Expect(errors.Is(err, ErrRemote)).To(gomega.BeTrue())
+	g.Expect(errors.Is(err, RemoteException("ValueError"))).To(gomega.BeTrue())
+	g.Expect(errors.Is(err, RemoteException("KeyError"))).To(gomega.BeFalse())
+
+	var remoteErr RemoteError
+	g.Expect(errors.As(err, &remoteErr)).To(gomega.BeTrue())
+	g.Expect(remoteErr.Args).To(gomega.Equal([]any{"bad input", int64(3)}))
+	g.Expect(remoteErr.Frames).To(gomega.HaveLen(1))
+	g.Expect(remoteErr.Frames[0].String()).To(gomega.Equal("/root/app.py:3 in f"))
+	g.Expect(err.Error()).To(gomega.ContainSubstring("RemoteError: ValueError('bad input', 3)"))
+}
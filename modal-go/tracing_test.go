@@ -0,0 +1,82 @@
+package modal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestTracingInterceptor(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	recorder := tracetest.NewSpanRecorder()
+	c := newTestClient(g, nil, ClientOptions{
+		RetryPolicy:    &RetryPolicy{BaseDelay: time.Millisecond},
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+	})
+
+	ctx, parent := c.startSpan(context.Background(), "Function.Remote")
+
+	var traceparents []string
+	attempts := 0
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		traceparents = append(traceparents, md.Get("traceparent")...)
+		attempts++
+		if attempts < 3 {
+			return status.Error(codes.Unavailable, "unavailable")
+		}
+		return nil
+	}
+	retry := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return retryInterceptor(c)(ctx, method, req, reply, cc, invoker, opts...)
+	}
+	err := tracingInterceptor(c)(ctx, "/modal.client.ModalClient/FunctionMap", nil, nil, nil, retry)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	parent.End()
+
+	spans := recorder.Ended()
+	g.Expect(spans).To(gomega.HaveLen(2))
+	rpc, remote := spans[0], spans[1]
+	g.Expect(remote.Name()).To(gomega.Equal("modal.Function.Remote"))
+	g.Expect(rpc.Name()).To(gomega.Equal("modal.client.ModalClient/FunctionMap"))
+	g.Expect(rpc.Parent().SpanID()).To(gomega.Equal(remote.SpanContext().SpanID()))
+	g.Expect(rpc.Events()).To(gomega.HaveLen(2))
+	g.Expect(rpc.Events()[0].Name).To(gomega.Equal("retry"))
+
+	// Every attempt carries the trace context of the RPC span.
+	g.Expect(traceparents).To(gomega.HaveLen(3))
+	for _, tp := range traceparents {
+		g.Expect(tp).To(gomega.ContainSubstring(rpc.SpanContext().SpanID().String()))
+	}
+}
+
+func TestTracingDisabledByDefault(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	c := newTestClient(g, nil, ClientOptions{})
+
+	ctx, span := c.startSpan(context.Background(), "Queue.Put")
+	defer span.End()
+	g.Expect(span.IsRecording()).To(gomega.BeFalse())
+
+	var traceparent []string
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		traceparent = md.Get("traceparent")
+		return nil
+	}
+	err := tracingInterceptor(c)(ctx, "/modal.client.ModalClient/QueuePut", nil, nil, nil, invoker)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(traceparent).To(gomega.BeEmpty())
+}
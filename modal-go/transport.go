@@ -0,0 +1,108 @@
+package modal
+
+// Network configuration shared by gRPC connections and blob transfers.
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Dialer opens network connections to an address such as "api.modal.com:443".
+type Dialer func(ctx context.Context, addr string) (net.Conn, error)
+
+// dialer returns the Dialer for the client's connections, or nil to use the
+// defaults of gRPC and net/http, which honor the HTTPS_PROXY environment variable.
+func (c *Client) dialer() Dialer {
+	base := c.customDialer
+	if c.proxyURL == nil {
+		return base
+	}
+	if base == nil {
+		var d net.Dialer
+		base = func(ctx context.Context, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", addr)
+		}
+	}
+	return proxyDialer(c.proxyURL, base)
+}
+
+// proxyDialer returns a Dialer that tunnels connections through the HTTP proxy
+// at proxyURL with a CONNECT request, using base to connect to the proxy.
+func proxyDialer(proxyURL *url.URL, base Dialer) Dialer {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		proxyAddr := proxyURL.Host
+		if proxyURL.Port() == "" {
+			proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "80")
+		}
+		conn, err := base(ctx, proxyAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial proxy %s: %w", proxyAddr, err)
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+			defer conn.SetDeadline(time.Time{})
+		}
+
+		req := &http.Request{
+			Method: http.MethodConnect,
+			URL:    &url.URL{Opaque: addr},
+			Host:   addr,
+			Header: http.Header{},
+		}
+		if u := proxyURL.User; u != nil {
+			password, _ := u.Password()
+			credentials := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + password))
+			req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+		}
+		if err := req.Write(conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to send CONNECT to proxy %s: %w", proxyAddr, err)
+		}
+
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to read CONNECT response from proxy %s: %w", proxyAddr, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			conn.Close()
+			return nil, fmt.Errorf("proxy %s refused CONNECT to %s: %s", proxyAddr, addr, resp.Status)
+		}
+		if br.Buffered() > 0 {
+			// The server can't send data before the client does, so this is a protocol error.
+			conn.Close()
+			return nil, fmt.Errorf("unexpected data from proxy %s after CONNECT", proxyAddr)
+		}
+		return conn, nil
+	}
+}
+
+// newHTTPClient returns the client used for blob transfers, which shares the
+// TLS config, proxy and dialer of the gRPC connections.
+func newHTTPClient(tlsConfig *tls.Config, proxyURL *url.URL, dialer Dialer) *http.Client {
+	if tlsConfig == nil && proxyURL == nil && dialer == nil {
+		return http.DefaultClient
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig.Clone()
+	}
+	if proxyURL != nil {
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if dialer != nil {
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer(ctx, addr)
+		}
+	}
+	return &http.Client{Transport: transport}
+}
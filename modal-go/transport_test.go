@@ -0,0 +1,110 @@
+package modal
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/onsi/gomega"
+)
+
+// startConnectProxy starts an HTTP proxy that only supports CONNECT, and
+// records the Proxy-Authorization header of each request.
+func startConnectProxy(t *testing.T, auth chan<- string) *url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				auth <- req.Header.Get("Proxy-Authorization")
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer target.Close()
+				io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				go io.Copy(target, conn)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return &url.URL{Scheme: "http", User: url.UserPassword("user", "pass"), Host: l.Addr().String()}
+}
+
+func TestProxyDialer(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	t.Cleanup(server.Close)
+
+	auth := make(chan string, 1)
+	proxyURL := startConnectProxy(t, auth)
+	c := newTestClient(g, nil, ClientOptions{ProxyURL: proxyURL})
+
+	conn, err := c.dialer()(context.Background(), server.Listener.Addr().String())
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	defer conn.Close()
+	g.Expect(<-auth).To(gomega.Equal("Basic dXNlcjpwYXNz"))
+
+	req, err := http.NewRequest("GET", server.URL, nil)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(req.Write(conn)).To(gomega.Succeed())
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	body, err := io.ReadAll(resp.Body)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(string(body)).To(gomega.Equal("hello"))
+}
+
+func TestHTTPClientUsesTLSConfigAndProxy(t *testing.T) {
+	t.Parallel()
+	g := gomega.NewWithT(t)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "blob")
+	}))
+	t.Cleanup(server.Close)
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	auth := make(chan string, 1)
+	c := newTestClient(g, nil, ClientOptions{
+		TLSConfig: &tls.Config{RootCAs: roots},
+		ProxyURL:  startConnectProxy(t, auth),
+	})
+
+	resp, err := c.httpClient.Get(server.URL)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	g.Expect(err).ShouldNot(gomega.HaveOccurred())
+	g.Expect(string(body)).To(gomega.Equal("blob"))
+	g.Expect(<-auth).To(gomega.Equal("Basic dXNlcjpwYXNz"))
+
+	// Without options, blob transfers use the default HTTP client.
+	c = newTestClient(g, nil, ClientOptions{})
+	g.Expect(c.httpClient).To(gomega.BeIdenticalTo(http.DefaultClient))
+}
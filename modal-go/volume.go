@@ -25,10 +25,19 @@ type VolumeFromNameOptions struct {
 	CreateIfMissing bool
 }
 
-// VolumeFromName references a modal.Volume by its name.
+// VolumeFromName references a modal.Volume by its name, using the default client.
 func VolumeFromName(ctx context.Context, name string, options *VolumeFromNameOptions) (*Volume, error) {
+	c, err := getDefaultClient()
+	if err != nil {
+		return nil, err
+	}
+	return c.VolumeFromName(ctx, name, options)
+}
+
+// VolumeFromName references a modal.Volume by its name.
+func (c *Client) VolumeFromName(ctx context.Context, name string, options *VolumeFromNameOptions) (*Volume, error) {
 	var err error
-	ctx, err = clientContext(ctx)
+	ctx, err = c.clientContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -42,14 +51,14 @@ func VolumeFromName(ctx context.Context, name string, options *VolumeFromNameOpt
 		creationType = pb.ObjectCreationType_OBJECT_CREATION_TYPE_CREATE_IF_MISSING
 	}
 
-	resp, err := client.VolumeGetOrCreate(ctx, pb.VolumeGetOrCreateRequest_builder{
+	resp, err := c.cpClient.VolumeGetOrCreate(ctx, pb.VolumeGetOrCreateRequest_builder{
 		DeploymentName:     name,
-		EnvironmentName:    environmentName(options.Environment),
+		EnvironmentName:    c.environmentName(options.Environment),
 		ObjectCreationType: creationType,
 	}.Build())
 
-	if status, ok := status.FromError(err); ok && status.Code() == codes.NotFound {
-		return nil, NotFoundError{fmt.Sprintf("Volume '%s' not found", name)}
+	if status.Code(err) == codes.NotFound {
+		return nil, withNotFoundMessage(err, fmt.Sprintf("Volume '%s' not found", name))
 	}
 	if err != nil {
 		return nil, err
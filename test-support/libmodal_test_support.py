@@ -20,6 +20,12 @@ def bytelength(buf: bytes) -> int:
     return len(buf)
 
 
+@app.function(min_containers=1)
+def count_to(n: int):
+    for i in range(n):
+        yield i
+
+
 @app.function(min_containers=1, experimental_options={"input_plane_region": "us-west"})
 def input_plane(s: str) -> str:
     return "output: " + s